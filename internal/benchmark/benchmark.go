@@ -0,0 +1,101 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spegel-org/spegel/pkg/registry"
+)
+
+type Measurement struct {
+	Duration time.Duration `json:"duration"`
+	Bytes    int64         `json:"bytes"`
+}
+
+type Result struct {
+	Peer         string        `json:"peer"`
+	Digest       string        `json:"digest"`
+	Measurements []Measurement `json:"measurements"`
+	Throughput   float64       `json:"throughputMBps"`
+	P50          time.Duration `json:"p50"`
+	P90          time.Duration `json:"p90"`
+	P99          time.Duration `json:"p99"`
+}
+
+// PeerBlob fetches the blob directly from the peer count times, bypassing Containerd and mirror resolution.
+func PeerBlob(ctx context.Context, client *http.Client, peer, name string, dgst digest.Digest, count int) (Result, error) {
+	u := fmt.Sprintf("http://%s/v2/%s/blobs/%s", peer, name, dgst.String())
+	measurements := []Measurement{}
+	for range count {
+		m, err := fetch(ctx, client, u)
+		if err != nil {
+			return Result{}, err
+		}
+		measurements = append(measurements, m)
+	}
+	return newResult(peer, dgst, measurements), nil
+}
+
+func fetch(ctx context.Context, client *http.Client, u string) (Measurement, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return Measurement{}, err
+	}
+	// Request is served from the peers local content instead of being mirrored.
+	req.Header.Set(registry.MirroredHeaderKey, "true")
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return Measurement{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Measurement{}, fmt.Errorf("expected peer to respond with 200 OK but received: %s", resp.Status)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return Measurement{}, err
+	}
+	return Measurement{Duration: time.Since(start), Bytes: n}, nil
+}
+
+func newResult(peer string, dgst digest.Digest, measurements []Measurement) Result {
+	result := Result{
+		Peer:         peer,
+		Digest:       dgst.String(),
+		Measurements: measurements,
+	}
+	if len(measurements) == 0 {
+		return result
+	}
+	durations := []time.Duration{}
+	var totalBytes int64
+	var totalDuration time.Duration
+	for _, m := range measurements {
+		durations = append(durations, m.Duration)
+		totalBytes += m.Bytes
+		totalDuration += m.Duration
+	}
+	slices.Sort(durations)
+	if totalDuration > 0 {
+		result.Throughput = float64(totalBytes) / 1_000_000 / totalDuration.Seconds()
+	}
+	result.P50 = percentile(durations, 0.5)
+	result.P90 = percentile(durations, 0.9)
+	result.P99 = percentile(durations, 0.99)
+	return result
+}
+
+// percentile returns the nearest rank percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	idx = max(idx, 0)
+	return sorted[idx]
+}
@@ -0,0 +1,54 @@
+package benchmark
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerBlob(t *testing.T) {
+	t.Parallel()
+
+	dgst := digest.FromString("hello world")
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/foo/blobs/"+dgst.String() || r.Header.Get("X-Spegel-Mirrored") != "true" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		//nolint:errcheck // ignore
+		w.Write([]byte("hello world"))
+	}))
+	t.Cleanup(func() {
+		svr.Close()
+	})
+
+	result, err := PeerBlob(context.Background(), svr.Client(), svr.Listener.Addr().String(), "foo", dgst, 3)
+	require.NoError(t, err)
+	require.Len(t, result.Measurements, 3)
+	for _, m := range result.Measurements {
+		require.Equal(t, int64(11), m.Bytes)
+	}
+	require.Positive(t, result.Throughput)
+
+	_, err = PeerBlob(context.Background(), svr.Client(), svr.Listener.Addr().String(), "bar", dgst, 1)
+	require.EqualError(t, err, "expected peer to respond with 200 OK but received: 404 Not Found")
+}
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+
+	durations := []time.Duration{}
+	for i := 1; i <= 100; i++ {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	require.Equal(t, 50*time.Millisecond, percentile(durations, 0.5))
+	require.Equal(t, 90*time.Millisecond, percentile(durations, 0.9))
+	require.Equal(t, 99*time.Millisecond, percentile(durations, 0.99))
+	require.Equal(t, 5*time.Millisecond, percentile(durations[:5], 0.99))
+	require.Equal(t, time.Millisecond, percentile(durations[:1], 0.5))
+}
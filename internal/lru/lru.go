@@ -0,0 +1,136 @@
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+}
+
+// Cache is a least recently used cache bounded by the total size of its values. Values have a size of one unless
+// a size function is set, bounding the cache by the amount of values.
+type Cache[K comparable, V any] struct {
+	ll      *list.List
+	entries map[K]*list.Element
+	sizeFn  func(V) int64
+	onEvict func(K, V)
+	mx      sync.Mutex
+	size    int64
+	maxSize int64
+}
+
+type Option[K comparable, V any] func(*Cache[K, V])
+
+// WithSize sets the function used to determine the size of a value.
+func WithSize[K comparable, V any](sizeFn func(V) int64) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.sizeFn = sizeFn
+	}
+}
+
+// WithEvict sets a function which is called for every value evicted to make room for a new value. The function
+// is called while the cache is locked and must not call the cache.
+func WithEvict[K comparable, V any](onEvict func(K, V)) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.onEvict = onEvict
+	}
+}
+
+func New[K comparable, V any](maxSize int64, opts ...Option[K, V]) *Cache[K, V] {
+	c := &Cache[K, V]{
+		ll:      list.New(),
+		entries: map[K]*list.Element{},
+		maxSize: maxSize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Get returns the value for the key and marks it as recently used.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	e, ok := elem.Value.(*entry[K, V])
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.ll.MoveToFront(elem)
+	return e.value, true
+}
+
+// Add sets the value for the key, evicting the least recently used values until it fits. Values larger than the
+// max size are never cached, in which case false is returned.
+func (c *Cache[K, V]) Add(key K, value V) bool {
+	size := int64(1)
+	if c.sizeFn != nil {
+		size = c.sizeFn(value)
+	}
+	if size > c.maxSize {
+		return false
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.removeLocked(key)
+	for c.size+size > c.maxSize {
+		evicted, ok := c.ll.Remove(c.ll.Back()).(*entry[K, V])
+		if !ok {
+			continue
+		}
+		delete(c.entries, evicted.key)
+		c.size -= evicted.size
+		if c.onEvict != nil {
+			c.onEvict(evicted.key, evicted.value)
+		}
+	}
+	c.entries[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, size: size})
+	c.size += size
+	return true
+}
+
+// Remove removes the value for the key without calling the evict function.
+func (c *Cache[K, V]) Remove(key K) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.removeLocked(key)
+}
+
+func (c *Cache[K, V]) removeLocked(key K) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	delete(c.entries, key)
+	e, ok := c.ll.Remove(elem).(*entry[K, V])
+	if !ok {
+		return
+	}
+	c.size -= e.size
+}
+
+// Purge removes all values without calling the evict function.
+func (c *Cache[K, V]) Purge() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.ll.Init()
+	c.entries = map[K]*list.Element{}
+	c.size = 0
+}
+
+// Size returns the total size of the cached values.
+func (c *Cache[K, V]) Size() int64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.size
+}
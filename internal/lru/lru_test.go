@@ -0,0 +1,76 @@
+package lru
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheCount(t *testing.T) {
+	t.Parallel()
+
+	c := New[string, int](2)
+	_, ok := c.Get("foo")
+	require.False(t, ok)
+
+	require.True(t, c.Add("foo", 1))
+	require.True(t, c.Add("bar", 2))
+	v, ok := c.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	// Least recently used values are evicted first.
+	require.True(t, c.Add("baz", 3))
+	_, ok = c.Get("bar")
+	require.False(t, ok)
+	_, ok = c.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, int64(2), c.Size())
+
+	// Adding an existing key replaces the value.
+	require.True(t, c.Add("foo", 4))
+	v, ok = c.Get("foo")
+	require.True(t, ok)
+	require.Equal(t, 4, v)
+	require.Equal(t, int64(2), c.Size())
+
+	c.Remove("foo")
+	_, ok = c.Get("foo")
+	require.False(t, ok)
+	require.Equal(t, int64(1), c.Size())
+
+	c.Purge()
+	_, ok = c.Get("baz")
+	require.False(t, ok)
+	require.Zero(t, c.Size())
+}
+
+func TestCacheSize(t *testing.T) {
+	t.Parallel()
+
+	evicted := []string{}
+	c := New(10, WithSize[string](func(v string) int64 {
+		return int64(len(v))
+	}), WithEvict(func(k, _ string) {
+		evicted = append(evicted, k)
+	}))
+
+	require.True(t, c.Add("foo", "foo"))
+	require.True(t, c.Add("bar", "bar"))
+	require.Equal(t, int64(6), c.Size())
+
+	require.True(t, c.Add("hello", "hello"))
+	require.Equal(t, []string{"foo"}, evicted)
+	require.Equal(t, int64(8), c.Size())
+
+	// Values larger than the max size are not cached.
+	require.False(t, c.Add("too large", "too large!!"))
+	_, ok := c.Get("too large")
+	require.False(t, ok)
+	require.Equal(t, int64(8), c.Size())
+
+	// Removed and purged values are not evicted.
+	c.Remove("bar")
+	c.Purge()
+	require.Equal(t, []string{"foo"}, evicted)
+}
@@ -13,6 +13,8 @@ type ResponseWriter interface {
 	Error() error
 	Status() int
 	Size() int64
+	SetAttrs(keysAndValues ...any)
+	Attrs() []any
 }
 
 var (
@@ -25,6 +27,7 @@ var (
 type response struct {
 	http.ResponseWriter
 	error         error
+	attrs         []any
 	status        int
 	size          int64
 	writtenHeader bool
@@ -87,3 +90,12 @@ func (r *response) Error() error {
 func (r *response) Size() int64 {
 	return r.size
 }
+
+// SetAttrs adds key value pairs describing the request which are included when logging the request.
+func (r *response) SetAttrs(keysAndValues ...any) {
+	r.attrs = append(r.attrs, keysAndValues...)
+}
+
+func (r *response) Attrs() []any {
+	return r.attrs
+}
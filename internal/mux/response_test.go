@@ -26,6 +26,10 @@ func TestResponseWriter(t *testing.T) {
 	require.NoError(t, rw.Error())
 	require.Equal(t, int64(0), rw.Size())
 	require.Equal(t, http.StatusOK, rw.Status())
+	require.Empty(t, rw.Attrs())
+	rw.SetAttrs("foo", "bar")
+	rw.SetAttrs("count", 1)
+	require.Equal(t, []any{"foo", "bar", "count", 1}, rw.Attrs())
 
 	rw = &response{
 		ResponseWriter: httptest.NewRecorder(),
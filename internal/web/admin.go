@@ -0,0 +1,84 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/spegel-org/spegel/pkg/routing"
+)
+
+// Minimum duration between bootstrap requests to stop the endpoint from being used to flood peers.
+const bootstrapRateLimit = 30 * time.Second
+
+type BootstrapResponse struct {
+	Error            string `json:"error,omitempty"`
+	RoutingTableSize int    `json:"routingTableSize"`
+}
+
+// NewAdminHandler returns a handler for operational actions. Requests have to set the token as a bearer token.
+// Peers can be added to and removed from the blocklist when it is not nil.
+func NewAdminHandler(token string, bootstrap func(ctx context.Context) (int, error), blocklist *routing.Blocklist) *http.ServeMux {
+	limiter := rate.NewLimiter(rate.Every(bootstrapRateLimit), 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/bootstrap", func(w http.ResponseWriter, req *http.Request) {
+		if !authorized(req, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !limiter.Allow() {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "bootstrap was requested too recently", http.StatusTooManyRequests)
+			return
+		}
+		size, err := bootstrap(req.Context())
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			writeJSON(w, BootstrapResponse{RoutingTableSize: size, Error: err.Error()})
+			return
+		}
+		writeJSON(w, BootstrapResponse{RoutingTableSize: size})
+	})
+	if blocklist != nil {
+		mux.HandleFunc("PUT /admin/blocklist", func(w http.ResponseWriter, req *http.Request) {
+			updateBlocklist(w, req, token, blocklist, blocklist.Add)
+		})
+		mux.HandleFunc("DELETE /admin/blocklist", func(w http.ResponseWriter, req *http.Request) {
+			updateBlocklist(w, req, token, blocklist, blocklist.Remove)
+		})
+	}
+	return mux
+}
+
+// updateBlocklist applies the update to the peer in the ip parameter and responds with the resulting blocklist.
+func updateBlocklist(w http.ResponseWriter, req *http.Request, token string, blocklist *routing.Blocklist, update func(netip.Addr)) {
+	if !authorized(req, token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	addr, err := netip.ParseAddr(req.URL.Query().Get("ip"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse blocklist ip: %v", err), http.StatusBadRequest)
+		return
+	}
+	update(addr)
+	writeJSON(w, blocklist.List())
+}
+
+func authorized(req *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	v, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(v), []byte(token)) == 1
+}
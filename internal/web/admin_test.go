@@ -0,0 +1,152 @@
+package web
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spegel-org/spegel/pkg/routing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	handler := NewAdminHandler("secret", func(ctx context.Context) (int, error) {
+		calls++
+		return 3, nil
+	}, nil)
+
+	tests := []struct {
+		name           string
+		authorization  string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "missing token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong token",
+			authorization:  "Bearer foo",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "bootstrap",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"routingTableSize":3}`,
+		},
+		{
+			name:           "rate limited",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusTooManyRequests,
+		},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/admin/bootstrap", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		resp := rw.Result()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, tt.expectedStatus, resp.StatusCode, tt.name)
+		if tt.expectedBody != "" {
+			require.JSONEq(t, tt.expectedBody, string(b), tt.name)
+		}
+	}
+	require.Equal(t, 1, calls)
+
+	handler = NewAdminHandler("", func(ctx context.Context) (int, error) {
+		return 0, nil
+	}, nil)
+	req := httptest.NewRequest(http.MethodPost, "/admin/bootstrap", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	resp := rw.Result()
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAdminBlocklist(t *testing.T) {
+	t.Parallel()
+
+	blocklist := routing.NewBlocklist()
+	handler := NewAdminHandler("secret", func(ctx context.Context) (int, error) {
+		return 0, nil
+	}, blocklist)
+
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		authorization  string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "missing token",
+			method:         http.MethodPut,
+			query:          "?ip=10.0.0.1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "add peer",
+			method:         http.MethodPut,
+			query:          "?ip=10.0.0.1",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `["10.0.0.1"]`,
+		},
+		{
+			name:           "invalid ip",
+			method:         http.MethodPut,
+			query:          "?ip=foo",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "remove peer wrong token",
+			method:         http.MethodDelete,
+			query:          "?ip=10.0.0.1",
+			authorization:  "Bearer foo",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "remove peer",
+			method:         http.MethodDelete,
+			query:          "?ip=10.0.0.1",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+	}
+	// Test cases are run in order as they depend on the previous state of the blocklist.
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/admin/blocklist"+tt.query, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		resp := rw.Result()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, tt.expectedStatus, resp.StatusCode, tt.name)
+		if tt.expectedBody != "" {
+			require.JSONEq(t, tt.expectedBody, string(b), tt.name)
+		}
+	}
+	require.Empty(t, blocklist.List())
+}
@@ -0,0 +1,185 @@
+package web
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spegel-org/spegel/pkg/routing"
+)
+
+type Router interface {
+	AdvertisedKeys() []string
+	Providers(ctx context.Context, key string) ([]peer.AddrInfo, error)
+	Topology() routing.Topology
+}
+
+type Provider struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+type ProvidersResponse struct {
+	Key       string     `json:"key"`
+	Providers []Provider `json:"providers"`
+}
+
+type AdvertisedResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// MirrorRequests is the total amount of mirror requests with the same labels since the node started.
+type MirrorRequests struct {
+	Registry string
+	Cache    string
+	Source   string
+	Count    float64
+}
+
+type indexData struct {
+	MirrorRequests []MirrorRequests
+	AdvertisedKeys []string
+	Topology       routing.Topology
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Spegel {{ .Topology.ID }}</title></head>
+<body>
+<h1>Spegel</h1>
+<p>ID: {{ .Topology.ID }}</p>
+<p>Addresses: {{ range .Topology.Addrs }}{{ . }} {{ end }}</p>
+<h2>Peers ({{ len .Topology.Peers }})</h2>
+<table>
+<tr><th>ID</th><th>Addresses</th></tr>
+{{- range .Topology.Peers }}
+<tr><td>{{ .ID }}</td><td>{{ range .Addrs }}{{ . }} {{ end }}</td></tr>
+{{- end }}
+</table>
+<h2>Mirror requests</h2>
+<table>
+<tr><th>Registry</th><th>Cache</th><th>Source</th><th>Count</th></tr>
+{{- range .MirrorRequests }}
+<tr><td>{{ .Registry }}</td><td>{{ .Cache }}</td><td>{{ .Source }}</td><td>{{ .Count }}</td></tr>
+{{- end }}
+</table>
+<h2>Advertised keys ({{ len .AdvertisedKeys }})</h2>
+<ul>
+{{- range .AdvertisedKeys }}
+<li><a href="providers?key={{ . }}">{{ . }}</a></li>
+{{- end }}
+</ul>
+</body>
+</html>
+`))
+
+// NewDebugHandler returns a handler exposing the routing state for troubleshooting. It should only be served when
+// debugging as it allows anyone with access to run DHT lookups. The index page summarizes the state of the node,
+// with mirror request counts read from the gatherer.
+func NewDebugHandler(router Router, gatherer prometheus.Gatherer, lookupTimeout time.Duration) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/web/{$}", func(w http.ResponseWriter, req *http.Request) {
+		mirrorRequests, err := gatherMirrorRequests(gatherer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data := indexData{
+			Topology:       router.Topology(),
+			MirrorRequests: mirrorRequests,
+			AdvertisedKeys: router.AdvertisedKeys(),
+		}
+		buf := &bytes.Buffer{}
+		err = indexTemplate.Execute(buf, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		//nolint:errcheck // Ignore error as the client has disconnected.
+		w.Write(buf.Bytes())
+	})
+	mux.HandleFunc("GET /debug/web/advertised", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, AdvertisedResponse{Keys: router.AdvertisedKeys()})
+	})
+	mux.HandleFunc("GET /debug/web/topology", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, router.Topology())
+	})
+	mux.HandleFunc("GET /debug/web/providers", func(w http.ResponseWriter, req *http.Request) {
+		key := req.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "key query parameter is required", http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), lookupTimeout)
+		defer cancel()
+		infos, err := router.Providers(ctx, key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		providers := []Provider{}
+		for _, info := range infos {
+			addrs := []string{}
+			for _, addr := range info.Addrs {
+				addrs = append(addrs, addr.String())
+			}
+			providers = append(providers, Provider{ID: info.ID.String(), Addrs: addrs})
+		}
+		writeJSON(w, ProvidersResponse{Key: key, Providers: providers})
+	})
+	return mux
+}
+
+// gatherMirrorRequests returns the mirror request counts sorted by registry, cache, and source.
+func gatherMirrorRequests(gatherer prometheus.Gatherer) ([]MirrorRequests, error) {
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	mirrorRequests := []MirrorRequests{}
+	for _, mf := range mfs {
+		if mf.GetName() != "spegel_mirror_requests_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			mr := MirrorRequests{
+				Count: m.GetCounter().GetValue(),
+			}
+			for _, label := range m.GetLabel() {
+				switch label.GetName() {
+				case "registry":
+					mr.Registry = label.GetValue()
+				case "cache":
+					mr.Cache = label.GetValue()
+				case "source":
+					mr.Source = label.GetValue()
+				}
+			}
+			mirrorRequests = append(mirrorRequests, mr)
+		}
+	}
+	slices.SortFunc(mirrorRequests, func(a, b MirrorRequests) int {
+		return cmp.Or(cmp.Compare(a.Registry, b.Registry), cmp.Compare(a.Cache, b.Cache), cmp.Compare(a.Source, b.Source))
+	})
+	return mirrorRequests, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	//nolint:errcheck // Ignore error as the client has disconnected.
+	w.Write(b)
+}
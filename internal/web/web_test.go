@@ -0,0 +1,151 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spegel-org/spegel/pkg/routing"
+)
+
+type mockRouter struct {
+	providers map[string][]peer.AddrInfo
+	keys      []string
+	topology  routing.Topology
+}
+
+func (m *mockRouter) AdvertisedKeys() []string {
+	return m.keys
+}
+
+func (m *mockRouter) Providers(ctx context.Context, key string) ([]peer.AddrInfo, error) {
+	return m.providers[key], nil
+}
+
+func (m *mockRouter) Topology() routing.Topology {
+	return m.topology
+}
+
+func TestDebugHandler(t *testing.T) {
+	t.Parallel()
+
+	_, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPublicKey(pub)
+	require.NoError(t, err)
+	router := &mockRouter{
+		keys: []string{"bar", "foo"},
+		providers: map[string][]peer.AddrInfo{
+			"foo": {{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/10.0.0.1/tcp/5001")}}},
+		},
+		topology: routing.Topology{
+			ID:             "self",
+			Addrs:          []string{"/ip4/10.0.0.2/tcp/5001"},
+			Peers:          []routing.Peer{{ID: id.String(), Addrs: []string{"/ip4/10.0.0.1/tcp/5001"}}},
+			AdvertisedKeys: 2,
+		},
+	}
+	handler := NewDebugHandler(router, prometheus.NewRegistry(), time.Second)
+
+	tests := []struct {
+		name           string
+		target         string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "advertised keys",
+			target:         "/debug/web/advertised",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"keys":["bar","foo"]}`,
+		},
+		{
+			name:           "topology",
+			target:         "/debug/web/topology",
+			expectedStatus: http.StatusOK,
+			expectedBody:   fmt.Sprintf(`{"id":"self","addrs":["/ip4/10.0.0.2/tcp/5001"],"peers":[{"id":%q,"addrs":["/ip4/10.0.0.1/tcp/5001"]}],"advertisedKeys":2}`, id.String()),
+		},
+		{
+			name:           "providers",
+			target:         "/debug/web/providers?key=foo",
+			expectedStatus: http.StatusOK,
+			expectedBody:   fmt.Sprintf(`{"key":"foo","providers":[{"id":%q,"addrs":["/ip4/10.0.0.1/tcp/5001"]}]}`, id.String()),
+		},
+		{
+			name:           "no providers",
+			target:         "/debug/web/providers?key=bar",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"key":"bar","providers":[]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			resp := rw.Result()
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.JSONEq(t, tt.expectedBody, string(b))
+		})
+	}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/debug/web/providers", nil))
+	resp := rw.Result()
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestDebugHandlerIndex(t *testing.T) {
+	t.Parallel()
+
+	router := &mockRouter{
+		keys: []string{"sha256:foo"},
+		topology: routing.Topology{
+			ID:    "self",
+			Addrs: []string{"/ip4/10.0.0.2/tcp/5001"},
+			Peers: []routing.Peer{{ID: "peer", Addrs: []string{"/ip4/10.0.0.1/tcp/5001"}}},
+		},
+	}
+	reg := prometheus.NewRegistry()
+	mirrorRequests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spegel_mirror_requests_total",
+	}, []string{"registry", "cache", "source"})
+	reg.MustRegister(mirrorRequests)
+	mirrorRequests.WithLabelValues("docker.io", "miss", "internal").Add(2)
+	mirrorRequests.WithLabelValues("docker.io", "hit", "internal").Add(3)
+	handler := NewDebugHandler(router, reg, time.Second)
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/debug/web/", nil))
+	resp := rw.Result()
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	body := string(b)
+	require.Contains(t, body, "<p>ID: self</p>")
+	require.Contains(t, body, "<tr><td>peer</td><td>/ip4/10.0.0.1/tcp/5001 </td></tr>")
+	require.Contains(t, body, `<li><a href="providers?key=sha256%3afoo">sha256:foo</a></li>`)
+	hit := strings.Index(body, "<tr><td>docker.io</td><td>hit</td><td>internal</td><td>3</td></tr>")
+	miss := strings.Index(body, "<tr><td>docker.io</td><td>miss</td><td>internal</td><td>2</td></tr>")
+	require.NotEqual(t, -1, hit)
+	require.Greater(t, miss, hit)
+}
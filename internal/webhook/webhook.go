@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/spegel-org/spegel/pkg/metrics"
+)
+
+const (
+	// Events are dropped when the buffer is full so that sending never blocks the caller.
+	bufferSize    = 1000
+	maxBatchSize  = 100
+	flushInterval = 5 * time.Second
+)
+
+// Sender posts events as JSON arrays to a webhook URL. Events are batched and sent asynchronously.
+type Sender struct {
+	client *http.Client
+	events chan any
+	url    string
+}
+
+func NewSender(url string) *Sender {
+	return &Sender{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		events: make(chan any, bufferSize),
+		url:    url,
+	}
+}
+
+// Send queues the event without blocking. The event is dropped if the buffer is full.
+func (s *Sender) Send(event any) {
+	select {
+	case s.events <- event:
+	default:
+		metrics.EventWebhookDroppedTotal.Inc()
+	}
+}
+
+// Run sends batches of queued events until the context is cancelled.
+func (s *Sender) Run(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("webhook")
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := []any{}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := s.post(ctx, batch)
+		if err != nil {
+			log.Error(err, "could not send events to webhook", "count", len(batch))
+			metrics.EventWebhookDroppedTotal.Add(float64(len(batch)))
+		}
+		batch = []any{}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			flush()
+		case event := <-s.events:
+			batch = append(batch, event)
+			if len(batch) >= maxBatchSize {
+				flush()
+			}
+		}
+	}
+}
+
+func (s *Sender) post(ctx context.Context, batch []any) error {
+	b, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook responded with unexpected status %s", resp.Status)
+	}
+	return nil
+}
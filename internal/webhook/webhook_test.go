@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSender(t *testing.T) {
+	t.Parallel()
+
+	batchCh := make(chan []map[string]string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		batch := []map[string]string{}
+		err := json.NewDecoder(r.Body).Decode(&batch)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		batchCh <- batch
+	}))
+	t.Cleanup(srv.Close)
+
+	s := NewSender(srv.URL)
+	for range maxBatchSize {
+		s.Send(map[string]string{"foo": "bar"})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Run(ctx)
+	}()
+	batch := <-batchCh
+	require.Len(t, batch, maxBatchSize)
+	require.Equal(t, "bar", batch[0]["foo"])
+	cancel()
+	require.NoError(t, <-errCh)
+}
+
+func TestSenderDropOnFull(t *testing.T) {
+	t.Parallel()
+
+	s := NewSender("http://example.com")
+	for range bufferSize + 10 {
+		s.Send("event")
+	}
+	require.Len(t, s.events, bufferSize)
+}
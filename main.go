@@ -1,15 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/netip"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,12 +27,20 @@ import (
 
 	"github.com/alexflint/go-arg"
 	"github.com/go-logr/logr"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/opencontainers/go-digest"
+	"github.com/pelletier/go-toml/v2"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/afero"
 	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v3"
 	"k8s.io/klog/v2"
 
+	"github.com/spegel-org/spegel/internal/benchmark"
 	"github.com/spegel-org/spegel/internal/kubernetes"
+	"github.com/spegel-org/spegel/internal/web"
+	"github.com/spegel-org/spegel/internal/webhook"
 	"github.com/spegel-org/spegel/pkg/metrics"
 	"github.com/spegel-org/spegel/pkg/oci"
 	"github.com/spegel-org/spegel/pkg/registry"
@@ -35,8 +53,24 @@ type ConfigurationCmd struct {
 	ContainerdRegistryConfigPath string    `arg:"--containerd-registry-config-path,env:CONTAINERD_REGISTRY_CONFIG_PATH" default:"/etc/containerd/certs.d" help:"Directory where mirror configuration is written."`
 	Registries                   []url.URL `arg:"--registries,required,env:REGISTRIES" help:"registries that are configured to be mirrored."`
 	MirrorRegistries             []url.URL `arg:"--mirror-registries,env:MIRROR_REGISTRIES,required" help:"registries that are configured to act as mirrors."`
+	MirrorCapabilities           []string  `arg:"--mirror-capabilities,env:MIRROR_CAPABILITIES" help:"Capabilities of individual mirrors formatted as url=capability,capability, such as http://127.0.0.1:30021=pull. Mirrors without capabilities get pull, and resolve when resolve tags is true."`
 	ResolveTags                  bool      `arg:"--resolve-tags,env:RESOLVE_TAGS" default:"true" help:"When true Spegel will resolve tags to digests."`
 	AppendMirrors                bool      `arg:"--append-mirrors,env:APPEND_MIRRORS" default:"false" help:"When true existing mirror configuration will be appended to instead of replaced."`
+	PreserveUpstreamTLS          bool      `arg:"--preserve-upstream-tls,env:PRESERVE_UPSTREAM_TLS" default:"false" help:"When true TLS settings for the upstream registry will be kept from existing mirror configuration."`
+	DryRun                       bool      `arg:"--dry-run,env:DRY_RUN" default:"false" help:"When true mirror configuration will be printed to stdout instead of written."`
+}
+
+type VerifyCmd struct {
+	ContainerdRegistryConfigPath string `arg:"--containerd-registry-config-path,env:CONTAINERD_REGISTRY_CONFIG_PATH" default:"/etc/containerd/certs.d" help:"Directory where mirror configuration is written."`
+	ContainerdSock               string `arg:"--containerd-sock,env:CONTAINERD_SOCK" default:"/run/containerd/containerd.sock" help:"Endpoint of containerd service."`
+	ContainerdNamespace          string `arg:"--containerd-namespace,env:CONTAINERD_NAMESPACE" default:"k8s.io" help:"Containerd namespace to fetch images from."`
+}
+
+type BenchmarkCmd struct {
+	Peer   string        `arg:"--peer,required" help:"Address of the peer registry to fetch the blob from."`
+	Name   string        `arg:"--name,required" help:"Repository name of the blob."`
+	Digest digest.Digest `arg:"--digest,required" help:"Digest of the blob to fetch."`
+	Count  int           `arg:"--count" default:"10" help:"Amount of times the blob is fetched."`
 }
 
 type BootstrapConfig struct {
@@ -50,41 +84,122 @@ type BootstrapConfig struct {
 
 type RegistryCmd struct {
 	BootstrapConfig
-	BlobSpeed                    *throttle.Byterate `arg:"--blob-speed,env:BLOB_SPEED" help:"Maximum write speed per request when serving blob layers. Should be an integer followed by unit Bps, KBps, MBps, GBps, or TBps."`
-	ContainerdRegistryConfigPath string             `arg:"--containerd-registry-config-path,env:CONTAINERD_REGISTRY_CONFIG_PATH" default:"/etc/containerd/certs.d" help:"Directory where mirror configuration is written."`
-	MetricsAddr                  string             `arg:"--metrics-addr,required,env:METRICS_ADDR" help:"address to serve metrics."`
-	LocalAddr                    string             `arg:"--local-addr,required,env:LOCAL_ADDR" help:"Address that the local Spegel instance will be reached at."`
-	ContainerdSock               string             `arg:"--containerd-sock,env:CONTAINERD_SOCK" default:"/run/containerd/containerd.sock" help:"Endpoint of containerd service."`
-	ContainerdNamespace          string             `arg:"--containerd-namespace,env:CONTAINERD_NAMESPACE" default:"k8s.io" help:"Containerd namespace to fetch images from."`
-	ContainerdContentPath        string             `arg:"--containerd-content-path,env:CONTAINERD_CONTENT_PATH" default:"/var/lib/containerd/io.containerd.content.v1.content" help:"Path to Containerd content store"`
-	RouterAddr                   string             `arg:"--router-addr,env:ROUTER_ADDR,required" help:"address to serve router."`
-	RegistryAddr                 string             `arg:"--registry-addr,env:REGISTRY_ADDR,required" help:"address to server image registry."`
-	Registries                   []url.URL          `arg:"--registries,env:REGISTRIES,required" help:"registries that are configured to be mirrored."`
-	MirrorResolveTimeout         time.Duration      `arg:"--mirror-resolve-timeout,env:MIRROR_RESOLVE_TIMEOUT" default:"20ms" help:"Max duration spent finding a mirror."`
-	MirrorResolveRetries         int                `arg:"--mirror-resolve-retries,env:MIRROR_RESOLVE_RETRIES" default:"3" help:"Max amount of mirrors to attempt."`
-	ResolveLatestTag             bool               `arg:"--resolve-latest-tag,env:RESOLVE_LATEST_TAG" default:"true" help:"When true latest tags will be resolved to digests."`
+	Config                       string                          `arg:"--config,env:CONFIG" help:"Path to a YAML or TOML config file with keys matching the flag names. Flags and environment variables take precedence over the config file."`
+	PrintConfig                  bool                            `arg:"--print-config,env:PRINT_CONFIG" default:"false" help:"When true the effective configuration merged from the config file, environment variables, and flags is printed as YAML before exiting. Passwords in URLs are redacted."`
+	AdvertiseCIDR                *netip.Prefix                   `arg:"--advertise-cidr,env:ADVERTISE_CIDR" help:"Only advertise a host address within the CIDR to peers."`
+	BlobSpeed                    *throttle.Byterate              `arg:"--blob-speed,env:BLOB_SPEED" help:"Maximum write speed per request when serving blob layers. Should be an integer followed by unit Bps, KBps, MBps, GBps, or TBps."`
+	ContainerdRegistryConfigPath string                          `arg:"--containerd-registry-config-path,env:CONTAINERD_REGISTRY_CONFIG_PATH" default:"/etc/containerd/certs.d" help:"Directory where mirror configuration is written."`
+	MetricsAddr                  string                          `arg:"--metrics-addr,required,env:METRICS_ADDR" help:"address to serve metrics."`
+	PprofEnabled                 bool                            `arg:"--pprof-enabled,env:PPROF_ENABLED" default:"true" help:"When true the pprof profiling endpoints are served on the metrics address."`
+	DebugWeb                     bool                            `arg:"--debug-web,env:DEBUG_WEB" default:"false" help:"When true an overview page and the debug endpoints for advertised keys, network topology, and DHT provider lookups are served on the metrics address."`
+	LocalAddr                    string                          `arg:"--local-addr,required,env:LOCAL_ADDR" help:"Address that the local Spegel instance will be reached at."`
+	ContainerdSock               string                          `arg:"--containerd-sock,env:CONTAINERD_SOCK" default:"/run/containerd/containerd.sock" help:"Endpoint of containerd service. Containerd is not used when empty."`
+	ContainerdNamespace          string                          `arg:"--containerd-namespace,env:CONTAINERD_NAMESPACE" default:"k8s.io" help:"Containerd namespace to fetch images from."`
+	ContainerdContentPath        string                          `arg:"--containerd-content-path,env:CONTAINERD_CONTENT_PATH" help:"Path to Containerd content store. Detected from Containerd when empty, falling back to /var/lib/containerd/io.containerd.content.v1.content."`
+	OCILayoutPath                string                          `arg:"--oci-layout-path,env:OCI_LAYOUT_PATH" help:"Path to a read only OCI image layout directory which content is served from. Containerd is preferred when both are configured. Images in the layout index need to be annotated with their full name."`
+	Platform                     string                          `arg:"--platform,env:PLATFORM" help:"Only advertise manifests for the platform formatted as os/arch/variant. Local content for other platforms is still served when requested by digest. All platforms with local content are advertised when empty."`
+	AddressFamilyPreference      routing.AddressFamilyPreference `arg:"--address-family-preference,env:ADDRESS_FAMILY_PREFERENCE" default:"ipv6" help:"Address family advertised to peers when both IPv6 and IPv4 are available, one of ipv6, ipv4, or auto."`
+	IdentityKeyType              routing.IdentityKeyType         `arg:"--identity-key-type,env:IDENTITY_KEY_TYPE" default:"ed25519" help:"Type of key generated for the P2P host identity, one of ed25519 or ecdsa. A key persisted in the data directory is used regardless of type."`
+	ProtocolPrefix               string                          `arg:"--protocol-prefix,env:PROTOCOL_PREFIX" default:"/spegel" help:"Protocol prefix used by the DHT. Clusters sharing a network should use different prefixes to stay isolated."`
+	FederationProtocolPrefix     string                          `arg:"--federation-protocol-prefix,env:FEDERATION_PROTOCOL_PREFIX" default:"/spegel-federation" help:"Protocol prefix used by the federation DHT. Has to differ from the protocol prefix."`
+	AdminTokenPath               string                          `arg:"--admin-token-path,env:ADMIN_TOKEN_PATH" help:"Path to a file containing the bearer token required by the admin endpoints on the metrics address. Admin endpoints are disabled when empty."`
+	UpstreamCredentialsPath      string                          `arg:"--upstream-credentials-path,env:UPSTREAM_CREDENTIALS_PATH" help:"Path to a Docker config.json with credentials for upstream registries, used when upstream fallback requests from the local node are challenged for authentication. Only requests received on a Unix domain socket or from a loopback address are considered local. Challenges are passed to the client when empty."`
+	SwarmKeyPath                 string                          `arg:"--swarm-key-path,env:SWARM_KEY_PATH" help:"Path to a libp2p swarm key file. When set only peers with the same key can join the private network."`
+	RouterAddr                   string                          `arg:"--router-addr,env:ROUTER_ADDR,required" help:"address to serve router."`
+	RegistryAddr                 string                          `arg:"--registry-addr,env:REGISTRY_ADDR,required" help:"address to server image registry."`
+	BlobCacheDir                 string                          `arg:"--blob-cache-dir,env:BLOB_CACHE_DIR" help:"Directory on local storage to cache blobs read from a slow Containerd content path. Blobs are stored in a spegel-blobs subdirectory which is emptied on startup. The cache is disabled when empty."`
+	DataDir                      string                          `arg:"--data-dir,env:DATA_DIR" help:"Directory to persist the host identity and advertised keys across restarts. Nothing is persisted when empty."`
+	EventWebhookURL              string                          `arg:"--event-webhook-url,env:EVENT_WEBHOOK_URL" help:"URL which receives batches of mirror request events as JSON. Events are dropped when the webhook can not keep up. No events are sent when empty."`
+	PeerScheme                   string                          `arg:"--peer-scheme,env:PEER_SCHEME" help:"Scheme used for requests to peers, either http or https. When empty the scheme of the incoming request is used."`
+	UserAgent                    string                          `arg:"--user-agent,env:USER_AGENT" help:"User-Agent sent in requests to mirrors and upstream registries. Should contain spegel so that existing filters keep matching. The User-Agent of the client is forwarded when empty."`
+	LocalRegistryAddr            string                          `arg:"--local-registry-addr,env:LOCAL_REGISTRY_ADDR" help:"Additional address to serve image registry for local Containerd. Use unix:// prefix for a Unix domain socket."`
+	Registries                   []url.URL                       `arg:"--registries,env:REGISTRIES,required" help:"registries that are configured to be mirrored."`
+	RepositoryPrefixes           []oci.RepositoryPrefix          `arg:"--repository-prefixes,env:REPOSITORY_PREFIXES" help:"Repository prefixes formatted as registry/repository, such as ghcr.io/myorg. Registries with a prefix only mirror and advertise repositories within their prefixes, other registries are not limited."`
+	RegistryAliases              []string                        `arg:"--registry-aliases,env:REGISTRY_ALIASES" help:"Registry aliases formatted as alias=registry. Tags pulled through an alias are advertised and looked up with the registry. Docker Hub aliases are always included."`
+	AccessLogFields              []string                        `arg:"--access-log-fields,env:ACCESS_LOG_FIELDS" help:"Fields to include in the access log line written per registry request. Available fields are key, cache, peer, attempts, status, bytes, and duration. Access logging is disabled when empty."`
+	ForwardHeaders               []string                        `arg:"--forward-headers,env:FORWARD_HEADERS" help:"Allowlist of request headers forwarded to mirrors. All headers are forwarded when empty."`
+	FederationBootstrapPeers     []string                        `arg:"--federation-bootstrap-peers,env:FEDERATION_BOOTSTRAP_PEERS" help:"Multiaddresses including the peer ID of peers to bootstrap the federation DHT with. Keys are also advertised to and resolved from the federation DHT shared with other clusters. Federation is disabled when empty."`
+	PeerBlocklist                []netip.Addr                    `arg:"--peer-blocklist,env:PEER_BLOCKLIST" help:"IPs of peers which should never be used as mirrors. Can be updated at runtime through the /admin/blocklist endpoint when an admin token is configured."`
+	RateLimitExempt              []netip.Prefix                  `arg:"--rate-limit-exempt,env:RATE_LIMIT_EXEMPT" help:"CIDRs of clients which are never rate limited."`
+	MirrorResolveTimeout         time.Duration                   `arg:"--mirror-resolve-timeout,env:MIRROR_RESOLVE_TIMEOUT" default:"20ms" help:"Max duration spent finding a mirror."`
+	MirrorResolveRetries         int                             `arg:"--mirror-resolve-retries,env:MIRROR_RESOLVE_RETRIES" default:"3" help:"Max amount of mirrors to attempt."`
+	MaxMirrorResolveRetries      int                             `arg:"--max-mirror-resolve-retries,env:MAX_MIRROR_RESOLVE_RETRIES" default:"0" help:"Max amount of mirrors a request can ask to attempt with the X-Spegel-Resolve-Retries header. The header is ignored when zero."`
+	MaxManifestSize              int64                           `arg:"--max-manifest-size,env:MAX_MANIFEST_SIZE" default:"4194304" help:"Maximum size in bytes of manifests received from mirrors."`
+	MaxMirrorBlobSize            int64                           `arg:"--max-mirror-blob-size,env:MAX_MIRROR_BLOB_SIZE" default:"0" help:"Maximum size in bytes of blobs mirrored or served by this node. Larger blobs are pulled from upstream. No limit is applied when zero."`
+	BlobCacheSize                int64                           `arg:"--blob-cache-size,env:BLOB_CACHE_SIZE" default:"0" help:"Maximum total size in bytes of blobs from the Containerd content path cached in the blob cache directory. The cache is disabled when zero."`
+	ManifestCacheSize            int64                           `arg:"--manifest-cache-size,env:MANIFEST_CACHE_SIZE" default:"0" help:"Maximum total size in bytes of manifests cached in memory. The cache is disabled when zero."`
+	CopyBufferSize               int                             `arg:"--copy-buffer-size,env:COPY_BUFFER_SIZE" default:"32768" help:"Size in bytes of the buffers used when copying content to clients."`
+	MinAdvertisePeers            int                             `arg:"--min-advertise-peers,env:MIN_ADVERTISE_PEERS" default:"0" help:"Minimum amount of connected peers required before advertising images. Should be less than the amount of nodes, as advertising waits until it is reached."`
+	MinReadyPeers                int                             `arg:"--min-ready-peers,env:MIN_READY_PEERS" default:"0" help:"Minimum amount of connected peers required before reporting ready."`
+	SelfCheckSampleSize          int                             `arg:"--self-check-sample-size,env:SELF_CHECK_SAMPLE_SIZE" default:"0" help:"Amount of randomly sampled local content verified against its digest on startup. The self check is disabled when zero."`
+	SelfCheckMaxFailures         int                             `arg:"--self-check-max-failures,env:SELF_CHECK_MAX_FAILURES" default:"0" help:"Maximum amount of content failing the self check before startup is aborted."`
+	MirrorBreakerThreshold       int                             `arg:"--mirror-breaker-threshold,env:MIRROR_BREAKER_THRESHOLD" default:"0" help:"Consecutive failures after which a mirror is skipped. Circuit breaking is disabled when zero."`
+	MirrorRetryBudget            int                             `arg:"--mirror-retry-budget,env:MIRROR_RETRY_BUDGET" default:"0" help:"Maximum amount of mirror retries across all requests, refilled by one retry for every ten successful mirror requests. Retries are not limited when zero."`
+	MirrorBreakerCooldown        time.Duration                   `arg:"--mirror-breaker-cooldown,env:MIRROR_BREAKER_COOLDOWN" default:"30s" help:"Duration a mirror is skipped before a probe request is allowed through."`
+	MaxConcurrentRequests        int                             `arg:"--max-concurrent-requests,env:MAX_CONCURRENT_REQUESTS" default:"0" help:"Maximum amount of registry requests handled at the same time. No limit is applied when zero."`
+	RateLimit                    float64                         `arg:"--rate-limit,env:RATE_LIMIT" default:"0" help:"Maximum amount of registry requests per second for each client IP. No limit is applied when zero."`
+	RateLimitBurst               int                             `arg:"--rate-limit-burst,env:RATE_LIMIT_BURST" default:"100" help:"Amount of registry requests a client IP can make in a burst above the rate limit."`
+	ServerReadHeaderTimeout      time.Duration                   `arg:"--server-read-header-timeout,env:SERVER_READ_HEADER_TIMEOUT" default:"10s" help:"Max duration for reading request headers on the registry and metrics servers."`
+	ServerReadTimeout            time.Duration                   `arg:"--server-read-timeout,env:SERVER_READ_TIMEOUT" default:"1m" help:"Max duration for reading an entire request on the registry and metrics servers."`
+	ServerWriteTimeout           time.Duration                   `arg:"--server-write-timeout,env:SERVER_WRITE_TIMEOUT" default:"0s" help:"Max duration for writing a response on the registry and metrics servers. Has to cover the largest blob transfer, no timeout is applied when zero."`
+	ServerIdleTimeout            time.Duration                   `arg:"--server-idle-timeout,env:SERVER_IDLE_TIMEOUT" default:"2m" help:"Max duration idle keep-alive connections are kept open on the registry and metrics servers."`
+	MirrorRetryBackoff           time.Duration                   `arg:"--mirror-retry-backoff,env:MIRROR_RETRY_BACKOFF" default:"0s" help:"Base duration of the exponential backoff with jitter between mirror attempts."`
+	ReprovideInterval            time.Duration                   `arg:"--reprovide-interval,env:REPROVIDE_INTERVAL" default:"9m" help:"Interval at which all keys are advertised again. Has to be less than the key TTL of 10m."`
+	PeerHealthCheckInterval      time.Duration                   `arg:"--peer-health-check-interval,env:PEER_HEALTH_CHECK_INTERVAL" default:"0s" help:"Interval at which the registries of connected peers are probed. Peers failing the probe are not used as mirrors. Health checks are disabled when zero."`
+	PeerTagCacheTTL              time.Duration                   `arg:"--peer-tag-cache-ttl,env:PEER_TAG_CACHE_TTL" default:"0s" help:"Duration the digest a peer resolved a tag to is cached after mirroring the manifest by tag. Later requests for the tag are mirrored by the digest. The cache is disabled when zero."`
+	AdvertiseGrace               time.Duration                   `arg:"--advertise-grace,env:ADVERTISE_GRACE" default:"0s" help:"Duration to wait after the router is ready and connected to the minimum advertise peers before advertising images."`
+	StartupAdvertiseSpread       time.Duration                   `arg:"--startup-advertise-spread,env:STARTUP_ADVERTISE_SPREAD" default:"0s" help:"Initial advertisement of all images is delayed by a random duration within the spread, avoiding all nodes writing to the DHT at once after a rollout. Images are advertised immediately when zero."`
+	MaxImageAge                  time.Duration                   `arg:"--max-image-age,env:MAX_IMAGE_AGE" default:"0s" help:"Keys of images which have not been created, updated or served within the duration are no longer reprovided, letting their records expire after the key TTL. All images are reprovided when zero."`
+	ReconcileInterval            time.Duration                   `arg:"--reconcile-interval,env:RECONCILE_INTERVAL" default:"0s" help:"Interval at which advertised keys are reconciled with local content. Keys which no longer belong to a local image stop being reprovided, existing records remain in the DHT until they expire. Reconciliation is disabled when zero."`
+	UpstreamFallback             bool                            `arg:"--upstream-fallback,env:UPSTREAM_FALLBACK" default:"false" help:"When true content which can not be found on any peer is fetched from the original registry, if it is one of the mirrored registries."`
+	PeerH2C                      bool                            `arg:"--peer-h2c,env:PEER_H2C" default:"false" help:"When true requests between peers are multiplexed over a single HTTP/2 cleartext connection. Peers which do not support it are requested with HTTP/1.1."`
+	AdvertiseAnnotation          string                          `arg:"--advertise-annotation,env:ADVERTISE_ANNOTATION" help:"Only advertise images with the annotation on their manifest or index, formatted as key or key=value. All images are advertised when empty."`
+	BlockMutableTags             bool                            `arg:"--block-mutable-tags,env:BLOCK_MUTABLE_TAGS" default:"false" help:"When true manifests requested by tag are never mirrored, resolved for peers, or advertised, so that tags are always resolved by the registry. Requests by digest are not affected."`
+	ServeBlobs                   bool                            `arg:"--serve-blobs,env:SERVE_BLOBS" default:"true" help:"When false blobs will not be served or advertised to other peers, only manifests."`
+	ResolveLatestTag             bool                            `arg:"--resolve-latest-tag,env:RESOLVE_LATEST_TAG" default:"true" help:"When true latest tags will be resolved to digests."`
+	MediaTypeWarmup              bool                            `arg:"--media-type-warmup,env:MEDIA_TYPE_WARMUP" default:"true" help:"When true the media types of image indexes and manifests are cached while advertising images, so that manifests are served without a slower lookup in Containerd."`
 }
 
 type Arguments struct {
 	Configuration *ConfigurationCmd `arg:"subcommand:configuration"`
 	Registry      *RegistryCmd      `arg:"subcommand:registry"`
+	Verify        *VerifyCmd        `arg:"subcommand:verify"`
+	Benchmark     *BenchmarkCmd     `arg:"subcommand:benchmark"`
 	LogLevel      slog.Level        `arg:"--log-level,env:LOG_LEVEL" default:"INFO" help:"Minimum log level to output. Value should be DEBUG, INFO, WARN, or ERROR."`
+	LogFormat     string            `arg:"--log-format,env:LOG_FORMAT" default:"json" help:"Format of log output. Value should be json or text."`
 }
 
 func main() {
 	args := &Arguments{}
-	arg.MustParse(args)
+	p, err := arg.NewParser(arg.Config{}, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	cliArgs, err := configFileArgs(os.Args[1:])
+	if err != nil {
+		p.Fail(err.Error())
+	}
+	p.MustParse(cliArgs)
 
 	opts := slog.HandlerOptions{
 		AddSource: true,
 		Level:     args.LogLevel,
 	}
-	handler := slog.NewJSONHandler(os.Stderr, &opts)
+	var handler slog.Handler
+	switch args.LogFormat {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, &opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, &opts)
+	default:
+		p.Fail(fmt.Sprintf("unknown log format %s", args.LogFormat))
+	}
 	log := logr.FromSlogHandler(handler)
 	klog.SetLogger(log)
 	ctx := logr.NewContext(context.Background(), log)
 
-	err := run(ctx, args)
+	err = run(ctx, args)
 	if err != nil {
 		log.Error(err, "run exit with error")
 		os.Exit(1)
@@ -92,6 +207,169 @@ func main() {
 	log.Info("gracefully shutdown")
 }
 
+// configFileArgs appends flags for values in the registry config file which have not been set through flags or
+// environment variables. Config file values therefore only take precedence over defaults. Config keys are the
+// flag names of RegistryCmd, which means that new flags automatically become config keys.
+func configFileArgs(args []string) ([]string, error) {
+	if !slices.Contains(args, "registry") {
+		return args, nil
+	}
+	configPath := os.Getenv("CONFIG")
+	for i, a := range args {
+		if v, ok := strings.CutPrefix(a, "--config="); ok {
+			configPath = v
+		}
+		if a == "--config" && i+1 < len(args) {
+			configPath = args[i+1]
+		}
+	}
+	if configPath == "" {
+		return args, nil
+	}
+
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]any{}
+	switch filepath.Ext(configPath) {
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	default:
+		err = yaml.Unmarshal(b, &values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config file %s: %w", configPath, err)
+	}
+
+	envs := flagEnvs(reflect.TypeOf(RegistryCmd{}))
+	keys := []string{}
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		env, ok := envs[k]
+		if !ok || k == "config" || k == "print-config" {
+			return nil, fmt.Errorf("unknown config key %s", k)
+		}
+		if _, ok := os.LookupEnv(env); ok {
+			continue
+		}
+		if slices.ContainsFunc(args, func(a string) bool {
+			return a == "--"+k || strings.HasPrefix(a, "--"+k+"=")
+		}) {
+			continue
+		}
+		switch v := values[k].(type) {
+		case []any:
+			args = append(args, "--"+k)
+			for _, e := range v {
+				args = append(args, fmt.Sprint(e))
+			}
+		case map[string]any:
+			return nil, fmt.Errorf("config key %s cannot be a map", k)
+		default:
+			args = append(args, fmt.Sprintf("--%s=%v", k, v))
+		}
+	}
+	return args, nil
+}
+
+// flagEnvs returns the environment variable name for each long flag name in the argument struct.
+func flagEnvs(t reflect.Type) map[string]string {
+	envs := map[string]string{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous {
+			maps.Copy(envs, flagEnvs(field.Type))
+			continue
+		}
+		flag, env := flagTag(field)
+		if flag == "" {
+			continue
+		}
+		envs[flag] = env
+	}
+	return envs
+}
+
+// flagValues returns the value of each long flag name in the argument struct, formatted as config file values.
+func flagValues(v reflect.Value) map[string]any {
+	values := map[string]any{}
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous {
+			maps.Copy(values, flagValues(v.Field(i)))
+			continue
+		}
+		flag, _ := flagTag(field)
+		if flag == "" {
+			continue
+		}
+		values[flag] = configValue(v.Field(i))
+	}
+	return values
+}
+
+func configValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return configValue(v.Elem())
+	case reflect.Slice:
+		values := []any{}
+		for i := range v.Len() {
+			values = append(values, configValue(v.Index(i)))
+		}
+		return values
+	}
+	switch x := v.Interface().(type) {
+	case url.URL:
+		return x.Redacted()
+	case throttle.Byterate:
+		return fmt.Sprintf("%dBps", x)
+	case encoding.TextMarshaler:
+		b, err := x.MarshalText()
+		if err != nil {
+			return err.Error()
+		}
+		return string(b)
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return x
+	}
+}
+
+func flagTag(field reflect.StructField) (string, string) {
+	flag, env := "", ""
+	for _, opt := range strings.Split(field.Tag.Get("arg"), ",") {
+		if v, ok := strings.CutPrefix(opt, "--"); ok {
+			flag = v
+		}
+		if v, ok := strings.CutPrefix(opt, "env:"); ok {
+			env = v
+		}
+	}
+	return flag, env
+}
+
+func printConfig(w io.Writer, args *RegistryCmd) error {
+	values := flagValues(reflect.ValueOf(*args))
+	delete(values, "config")
+	delete(values, "print-config")
+	b, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
 func run(ctx context.Context, args *Arguments) error {
 	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGTERM)
 	defer cancel()
@@ -100,6 +378,10 @@ func run(ctx context.Context, args *Arguments) error {
 		return configurationCommand(ctx, args.Configuration)
 	case args.Registry != nil:
 		return registryCommand(ctx, args.Registry)
+	case args.Verify != nil:
+		return verifyCommand(ctx, args.Verify)
+	case args.Benchmark != nil:
+		return benchmarkCommand(ctx, args.Benchmark)
 	default:
 		return errors.New("unknown subcommand")
 	}
@@ -107,44 +389,161 @@ func run(ctx context.Context, args *Arguments) error {
 
 func configurationCommand(ctx context.Context, args *ConfigurationCmd) error {
 	fs := afero.NewOsFs()
-	err := oci.AddMirrorConfiguration(ctx, fs, args.ContainerdRegistryConfigPath, args.Registries, args.MirrorRegistries, args.ResolveTags, args.AppendMirrors)
+	mirrorCapabilities, err := oci.ParseMirrorCapabilities(args.MirrorCapabilities)
 	if err != nil {
 		return err
 	}
+	mirrorOpts := []oci.MirrorConfigurationOption{
+		oci.WithMirrorCapabilities(mirrorCapabilities),
+		oci.WithResolveTags(args.ResolveTags),
+		oci.WithAppendToBackup(args.AppendMirrors),
+		oci.WithPreserveUpstreamTLS(args.PreserveUpstreamTLS),
+		oci.WithDryRun(args.DryRun),
+	}
+	files, err := oci.AddMirrorConfiguration(ctx, fs, args.ContainerdRegistryConfigPath, args.Registries, args.MirrorRegistries, mirrorOpts...)
+	if err != nil {
+		return err
+	}
+	if !args.DryRun {
+		return nil
+	}
+	paths := []string{}
+	for p := range files {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	for _, p := range paths {
+		fmt.Fprintf(os.Stdout, "# %s\n%s\n", p, files[p])
+	}
+	return nil
+}
+
+func verifyCommand(ctx context.Context, args *VerifyCmd) error {
+	ociClient, err := oci.NewContainerd(args.ContainerdSock, args.ContainerdNamespace, args.ContainerdRegistryConfigPath, nil)
+	if err != nil {
+		return err
+	}
+	info, err := ociClient.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get Containerd info: %w", err)
+	}
+	fmt.Fprintf(os.Stdout, "Containerd version: %s (%s)\n", info.Version, info.Revision)
+	fmt.Fprintf(os.Stdout, "Containerd plugins: %s\n", strings.Join(info.Plugins, ", "))
+	err = ociClient.Verify(ctx)
+	if err != nil {
+		return fmt.Errorf("Containerd is not configured correctly: %w", err)
+	}
+	fmt.Fprintln(os.Stdout, "Containerd is configured correctly")
+	return nil
+}
+
+func benchmarkCommand(ctx context.Context, args *BenchmarkCmd) error {
+	result, err := benchmark.PeerBlob(ctx, http.DefaultClient, args.Peer, args.Name, args.Digest, args.Count)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(b))
 	return nil
 }
 
 func registryCommand(ctx context.Context, args *RegistryCmd) (err error) {
+	if args.PrintConfig {
+		return printConfig(os.Stdout, args)
+	}
 	log := logr.FromContextOrDiscard(ctx)
+	ctx, shutdown := context.WithCancel(ctx)
+	defer shutdown()
 	g, ctx := errgroup.WithContext(ctx)
 
-	// OCI Client
-	ociClient, err := oci.NewContainerd(args.ContainerdSock, args.ContainerdNamespace, args.ContainerdRegistryConfigPath, args.Registries, oci.WithContentPath(args.ContainerdContentPath))
+	// Fail fast on malformed registries instead of when the first request is mirrored.
+	err = oci.ValidateRegistries(args.Registries)
+	if err != nil {
+		return err
+	}
+	if args.PeerScheme != "" && args.PeerScheme != "http" && args.PeerScheme != "https" {
+		return fmt.Errorf("peer scheme %s has to be http or https", args.PeerScheme)
+	}
+	if args.RateLimit > 0 && args.RateLimitBurst < 1 {
+		return fmt.Errorf("rate limit burst %d has to be at least 1 when a rate limit is set", args.RateLimitBurst)
+	}
+
+	aliasPairs := map[string]string{}
+	for _, pair := range args.RegistryAliases {
+		alias, registry, ok := strings.Cut(pair, "=")
+		if !ok || alias == "" || registry == "" {
+			return fmt.Errorf("registry alias %s has to be formatted as alias=registry", pair)
+		}
+		aliasPairs[alias] = registry
+	}
+	registryAliases, err := oci.NewRegistryAliases(aliasPairs)
 	if err != nil {
 		return err
 	}
+
+	// OCI Client
+	// Clients are used in priority order, with Containerd before the OCI layout.
+	ociClients := []oci.Client{}
+	if args.ContainerdSock != "" {
+		containerdClient, err := oci.NewContainerd(args.ContainerdSock, args.ContainerdNamespace, args.ContainerdRegistryConfigPath, args.Registries, oci.WithContentPath(args.ContainerdContentPath), oci.WithBlobCache(args.BlobCacheDir, args.BlobCacheSize), oci.WithPlatform(args.Platform), oci.WithRepositoryPrefixes(args.RepositoryPrefixes), oci.WithMediaTypeWarmup(args.MediaTypeWarmup))
+		if err != nil {
+			return err
+		}
+		ociClients = append(ociClients, containerdClient)
+	}
+	if args.OCILayoutPath != "" {
+		ociClients = append(ociClients, oci.NewOCILayout(args.OCILayoutPath))
+	}
+	var ociClient oci.Client
+	switch len(ociClients) {
+	case 0:
+		return errors.New("either a Containerd socket or an OCI layout path has to be set")
+	case 1:
+		ociClient = ociClients[0]
+	default:
+		ociClient, err = oci.NewMultiClient(ociClients...)
+		if err != nil {
+			return err
+		}
+	}
 	err = ociClient.Verify(ctx)
 	if err != nil {
 		return err
 	}
+	if args.SelfCheckSampleSize > 0 {
+		mismatched, err := oci.VerifyContentSample(ctx, ociClient, args.SelfCheckSampleSize)
+		if err != nil {
+			return err
+		}
+		if len(mismatched) > args.SelfCheckMaxFailures {
+			return fmt.Errorf("content self check found %d digests with corrupt content exceeding max failures %d: %v", len(mismatched), args.SelfCheckMaxFailures, mismatched)
+		}
+		log.Info("content self check completed", "sampleSize", args.SelfCheckSampleSize, "failures", len(mismatched))
+	}
 
 	// Metrics
 	metrics.Register()
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.HandlerFor(metrics.DefaultGatherer, promhttp.HandlerOpts{}))
-	mux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
-	mux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
-	mux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
-	mux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
-	mux.Handle("/debug/pprof/heap", pprof.Handler("heap"))
-	mux.Handle("/debug/pprof/goroutine", pprof.Handler("goroutine"))
-	mux.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
-	mux.Handle("/debug/pprof/block", pprof.Handler("block"))
-	mux.Handle("/debug/pprof/mutex", pprof.Handler("mutex"))
+	if args.PprofEnabled {
+		mux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+		mux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+		mux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+		mux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+		mux.Handle("/debug/pprof/heap", pprof.Handler("heap"))
+		mux.Handle("/debug/pprof/goroutine", pprof.Handler("goroutine"))
+		mux.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
+		mux.Handle("/debug/pprof/block", pprof.Handler("block"))
+		mux.Handle("/debug/pprof/mutex", pprof.Handler("mutex"))
+	}
 	metricsSrv := &http.Server{
 		Addr:    args.MetricsAddr,
 		Handler: mux,
 	}
+	setServerTimeouts(metricsSrv, args)
 	g.Go(func() error {
 		if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return err
@@ -167,49 +566,209 @@ func registryCommand(ctx context.Context, args *RegistryCmd) (err error) {
 	if err != nil {
 		return err
 	}
-	router, err := routing.NewP2PRouter(ctx, args.RouterAddr, bootstrapper, registryPort)
+	blocklist := routing.NewBlocklist(args.PeerBlocklist...)
+	routerOpts := []routing.P2PRouterOption{
+		routing.WithPeerBlocklist(blocklist),
+		routing.WithAddressFamilyPreference(args.AddressFamilyPreference),
+		routing.WithReprovideInterval(args.ReprovideInterval),
+		routing.WithProtocolPrefix(args.ProtocolPrefix),
+		routing.WithIdentityKeyType(args.IdentityKeyType),
+		routing.WithPeerHealthCheck(args.PeerHealthCheckInterval),
+	}
+	if args.DataDir != "" {
+		routerOpts = append(routerOpts, routing.WithDataDir(args.DataDir))
+	}
+	if args.SwarmKeyPath != "" {
+		b, err := os.ReadFile(args.SwarmKeyPath)
+		if err != nil {
+			return err
+		}
+		psk, err := pnet.DecodeV1PSK(bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("could not decode swarm key: %w", err)
+		}
+		routerOpts = append(routerOpts, routing.WithSwarmKey(psk))
+	}
+	if args.AdvertiseCIDR != nil {
+		routerOpts = append(routerOpts, routing.WithAdvertiseCIDR(*args.AdvertiseCIDR))
+	}
+	if len(args.FederationBootstrapPeers) > 0 {
+		federationPeers := []peer.AddrInfo{}
+		for _, s := range args.FederationBootstrapPeers {
+			addrInfo, err := peer.AddrInfoFromString(s)
+			if err != nil {
+				return fmt.Errorf("could not parse federation bootstrap peer %s: %w", s, err)
+			}
+			federationPeers = append(federationPeers, *addrInfo)
+		}
+		routerOpts = append(routerOpts, routing.WithFederation(args.FederationProtocolPrefix, federationPeers))
+	}
+	router, err := routing.NewP2PRouter(ctx, args.RouterAddr, bootstrapper, registryPort, routerOpts...)
 	if err != nil {
 		return err
 	}
 	g.Go(func() error {
 		return router.Run(ctx)
 	})
-	g.Go(func() error {
-		<-ctx.Done()
-		return router.Close()
-	})
-
-	// State tracking
-	g.Go(func() error {
-		err := state.Track(ctx, ociClient, router, args.ResolveLatestTag)
+	if args.DebugWeb {
+		mux.Handle("/debug/web/", web.NewDebugHandler(router, metrics.DefaultGatherer, 5*time.Second))
+	}
+	if args.AdminTokenPath != "" {
+		b, err := os.ReadFile(args.AdminTokenPath)
 		if err != nil {
 			return err
 		}
-		return nil
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return errors.New("admin token cannot be empty")
+		}
+		mux.Handle("/admin/", web.NewAdminHandler(token, router.Bootstrap, blocklist))
+	}
+	g.Go(func() error {
+		<-ctx.Done()
+		return router.Close()
 	})
 
 	// Registry
 	registryOpts := []registry.Option{
 		registry.WithResolveLatestTag(args.ResolveLatestTag),
 		registry.WithResolveRetries(args.MirrorResolveRetries),
+		registry.WithMaxResolveRetries(args.MaxMirrorResolveRetries),
 		registry.WithResolveTimeout(args.MirrorResolveTimeout),
+		registry.WithRetryBackoff(args.MirrorRetryBackoff),
+		registry.WithMaxManifestSize(args.MaxManifestSize),
+		registry.WithMaxMirrorBlobSize(args.MaxMirrorBlobSize),
+		registry.WithManifestCacheSize(args.ManifestCacheSize),
+		registry.WithPeerTagCacheTTL(args.PeerTagCacheTTL),
+		registry.WithCopyBufferSize(args.CopyBufferSize),
+		registry.WithPeerBlocklist(blocklist),
+		registry.WithRepositoryPrefixes(args.RepositoryPrefixes),
+		registry.WithRegistryAliases(registryAliases),
+		registry.WithCircuitBreaker(args.MirrorBreakerThreshold, args.MirrorBreakerCooldown),
+		registry.WithRetryBudget(args.MirrorRetryBudget),
+		registry.WithMaxConcurrentRequests(args.MaxConcurrentRequests),
+		registry.WithRateLimit(args.RateLimit, args.RateLimitBurst, args.RateLimitExempt),
+		registry.WithMinReadyPeers(args.MinReadyPeers),
+		registry.WithServeBlobs(args.ServeBlobs),
+		registry.WithBlockMutableTags(args.BlockMutableTags),
+		registry.WithH2C(args.PeerH2C),
+		registry.WithAccessLogFields(args.AccessLogFields),
+		registry.WithForwardHeaders(args.ForwardHeaders),
+		registry.WithUserAgent(args.UserAgent),
+		registry.WithPeerScheme(args.PeerScheme),
 		registry.WithLocalAddress(args.LocalAddr),
 		registry.WithLogger(log),
 	}
 	if args.BlobSpeed != nil {
 		registryOpts = append(registryOpts, registry.WithBlobSpeed(*args.BlobSpeed))
 	}
+	if args.UpstreamFallback {
+		registryOpts = append(registryOpts, registry.WithUpstreamFallback(args.Registries))
+	}
+	if args.UpstreamCredentialsPath != "" {
+		creds, err := registry.LoadDockerConfig(args.UpstreamCredentialsPath)
+		if err != nil {
+			return fmt.Errorf("could not load upstream credentials: %w", err)
+		}
+		registryOpts = append(registryOpts, registry.WithUpstreamCredentials(creds))
+	}
+	if args.EventWebhookURL != "" {
+		sender := webhook.NewSender(args.EventWebhookURL)
+		g.Go(func() error {
+			return sender.Run(ctx)
+		})
+		registryOpts = append(registryOpts, registry.WithMirrorEventHandler(func(event registry.MirrorEvent) {
+			sender.Send(event)
+		}))
+	}
+	// Manifests served to peers count as use of the image when applying the max image age.
+	var usage *state.UsageTracker
+	if args.MaxImageAge > 0 {
+		usage = state.NewUsageTracker()
+		registryOpts = append(registryOpts, registry.WithLocalServeHandler(usage.Use))
+	}
 	reg := registry.NewRegistry(ociClient, router, registryOpts...)
+
+	// State tracking
+	stateCtx, stopState := context.WithCancel(ctx)
+	defer stopState()
+	g.Go(func() error {
+		deleteHandler := func(oci.Image) {
+			reg.PurgeManifestCache()
+		}
+		annotationKey, annotationValue, _ := strings.Cut(args.AdvertiseAnnotation, "=")
+		stateOpts := []state.Option{
+			state.WithReconcileInterval(args.ReconcileInterval),
+			state.WithReprovideInterval(args.ReprovideInterval),
+			state.WithMaxImageAge(args.MaxImageAge),
+			state.WithUsageTracker(usage),
+			state.WithStartupSpread(args.StartupAdvertiseSpread),
+			state.WithAdvertiseGrace(args.AdvertiseGrace, args.MinAdvertisePeers),
+			state.WithAdvertiseBlobs(args.ServeBlobs),
+			state.WithAdvertiseTags(!args.BlockMutableTags),
+			state.WithAdvertiseAnnotation(annotationKey, annotationValue),
+			state.WithDeleteHandler(deleteHandler),
+			state.WithRegistryAliases(registryAliases),
+		}
+		err := state.Track(stateCtx, ociClient, router, args.ResolveLatestTag, stateOpts...)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+
+	// Drain on the first SIGUSR1 by withdrawing all keys and reporting not ready, while serving in flight requests.
+	// A second SIGUSR1 shuts down gracefully. The signal is registered before starting the goroutine as the default
+	// action of an unhandled SIGUSR1 is to terminate the process.
+	drainCh := make(chan os.Signal, 1)
+	signal.Notify(drainCh, syscall.SIGUSR1)
+	g.Go(func() error {
+		defer signal.Stop(drainCh)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-drainCh:
+		}
+		// Provider records can not be removed from the DHT, so peers keep resolving the node until they expire.
+		log.Info("draining node, peers may still request content until advertised keys expire", "keyTTL", routing.KeyTTL.String())
+		stopState()
+		reg.Drain()
+		err := router.Withdraw(ctx, router.AdvertisedKeys())
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+		case <-drainCh:
+			log.Info("shutting down drained node")
+			shutdown()
+		}
+		return nil
+	})
+
 	regSrv, err := reg.Server(args.RegistryAddr)
 	if err != nil {
 		return err
 	}
+	setServerTimeouts(regSrv, args)
 	g.Go(func() error {
 		if err := regSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return err
 		}
 		return nil
 	})
+	if args.LocalRegistryAddr != "" {
+		localListener, err := registry.Listen(args.LocalRegistryAddr)
+		if err != nil {
+			return err
+		}
+		g.Go(func() error {
+			if err := regSrv.Serve(localListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		})
+	}
 	g.Go(func() error {
 		<-ctx.Done()
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -239,3 +798,10 @@ func getBootstrapper(cfg BootstrapConfig) (routing.Bootstrapper, error) { //noli
 		return nil, fmt.Errorf("unknown bootstrap kind %s", cfg.BootstrapKind)
 	}
 }
+
+func setServerTimeouts(srv *http.Server, args *RegistryCmd) {
+	srv.ReadHeaderTimeout = args.ServerReadHeaderTimeout
+	srv.ReadTimeout = args.ServerReadTimeout
+	srv.WriteTimeout = args.ServerWriteTimeout
+	srv.IdleTimeout = args.ServerIdleTimeout
+}
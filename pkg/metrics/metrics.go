@@ -19,10 +19,58 @@ var (
 		Name: "spegel_mirror_requests_total",
 		Help: "Total number of mirror requests.",
 	}, []string{"registry", "cache", "source"})
+	MirrorBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spegel_mirror_bytes_total",
+		Help: "Total number of bytes served by mirrors instead of the original registry.",
+	}, []string{"registry"})
+	EventWebhookDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "spegel_event_webhook_dropped_total",
+		Help: "Total number of events dropped because the webhook buffer was full or sending failed.",
+	})
+	ContentPathMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "spegel_content_path_misses_total",
+		Help: "Total number of blobs missing from the Containerd content path which were read from the content store instead.",
+	})
+	BlobCacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spegel_blob_cache_requests_total",
+		Help: "Total number of blob reads from the content path through the local blob cache.",
+	}, []string{"cache"})
+	BlobCacheSizeBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spegel_blob_cache_size_bytes",
+		Help: "Total size of blobs in the local blob cache.",
+	})
+	MirrorResolveCoalescedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "spegel_mirror_resolve_coalesced_total",
+		Help: "Total number of mirror requests which shared an in flight resolve instead of resolving peers.",
+	})
+	MirrorRetryBudgetExhaustedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "spegel_mirror_retry_budget_exhausted_total",
+		Help: "Total number of mirror requests which stopped retrying because the retry budget was exhausted.",
+	})
+	ImageEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spegel_image_events_total",
+		Help: "Total number of image events processed.",
+	}, []string{"type"})
+	ImageEventLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spegel_image_event_lag_seconds",
+		Help: "Duration between the last processed image event occurring and it being processed.",
+	})
+	PeerHealth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "spegel_peer_health",
+		Help: "Result of the last registry health check of a peer, 1 is healthy and 0 is unhealthy.",
+	}, []string{"peer"})
+	MirrorPeerBreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "spegel_mirror_peer_breaker_state",
+		Help: "State of the circuit breaker for a peer, 0 is closed, 1 is half open, and 2 is open.",
+	}, []string{"peer"})
 	ResolveDurHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name: "spegel_resolve_duration_seconds",
 		Help: "The duration for router to resolve a peer.",
 	}, []string{"router"})
+	MirrorFirstByteDurHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "spegel_mirror_first_byte_duration_seconds",
+		Help: "The duration from sending a request to a mirror until the response headers are received.",
+	}, []string{"kind"})
 	AdvertisedImages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "spegel_advertised_images",
 		Help: "Number of images advertised to be available.",
@@ -39,6 +87,27 @@ var (
 		Name: "spegel_advertised_keys",
 		Help: "Number of keys advertised to be available.",
 	}, []string{"registry"})
+	ServedBlobBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "spegel_served_blob_bytes",
+		Help:    "The amount of bytes served for blob requests.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 11),
+	}, []string{"source"})
+	RegistryRequestsInflight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spegel_registry_requests_inflight",
+		Help: "Number of registry requests counted against the max concurrent requests limit.",
+	})
+	AdvertiseTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "spegel_advertise_total",
+		Help: "Total number of keys advertised by the router.",
+	})
+	AdvertiseFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "spegel_advertise_failures_total",
+		Help: "Total number of keys which failed to be advertised by the router.",
+	})
+	RouterAdvertisedKeys = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spegel_router_advertised_keys",
+		Help: "Number of keys currently advertised by the router which have not expired.",
+	})
 	HttpRequestDurHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Subsystem: "http",
 		Name:      "request_duration_seconds",
@@ -58,11 +127,28 @@ var (
 
 func Register() {
 	DefaultRegisterer.MustRegister(MirrorRequestsTotal)
+	DefaultRegisterer.MustRegister(MirrorBytesTotal)
+	DefaultRegisterer.MustRegister(EventWebhookDroppedTotal)
+	DefaultRegisterer.MustRegister(ContentPathMissesTotal)
+	DefaultRegisterer.MustRegister(BlobCacheRequestsTotal)
+	DefaultRegisterer.MustRegister(BlobCacheSizeBytes)
+	DefaultRegisterer.MustRegister(MirrorResolveCoalescedTotal)
+	DefaultRegisterer.MustRegister(MirrorPeerBreakerState)
+	DefaultRegisterer.MustRegister(ImageEventsTotal)
+	DefaultRegisterer.MustRegister(ImageEventLag)
+	DefaultRegisterer.MustRegister(PeerHealth)
+	DefaultRegisterer.MustRegister(MirrorRetryBudgetExhaustedTotal)
 	DefaultRegisterer.MustRegister(ResolveDurHistogram)
+	DefaultRegisterer.MustRegister(MirrorFirstByteDurHistogram)
 	DefaultRegisterer.MustRegister(AdvertisedImages)
 	DefaultRegisterer.MustRegister(AdvertisedImageTags)
 	DefaultRegisterer.MustRegister(AdvertisedImageDigests)
 	DefaultRegisterer.MustRegister(AdvertisedKeys)
+	DefaultRegisterer.MustRegister(ServedBlobBytes)
+	DefaultRegisterer.MustRegister(RegistryRequestsInflight)
+	DefaultRegisterer.MustRegister(AdvertiseTotal)
+	DefaultRegisterer.MustRegister(AdvertiseFailuresTotal)
+	DefaultRegisterer.MustRegister(RouterAdvertisedKeys)
 	DefaultRegisterer.MustRegister(HttpRequestDurHistogram)
 	DefaultRegisterer.MustRegister(HttpResponseSizeHistogram)
 	DefaultRegisterer.MustRegister(HttpRequestsInflight)
@@ -0,0 +1,73 @@
+package oci
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultRegistryAliases are the hosts which are known to serve the same content as another registry.
+var DefaultRegistryAliases = map[string]string{
+	"registry-1.docker.io": "docker.io",
+	"index.docker.io":      "docker.io",
+}
+
+// RegistryAliases maps alias registry hosts to the canonical registry. Tags pulled through an alias
+// are advertised and looked up with the canonical registry, so that peers can find each other
+// independent of which alias was used.
+type RegistryAliases map[string]string
+
+// NewRegistryAliases returns the default aliases extended with the given alias to registry pairs.
+func NewRegistryAliases(aliases map[string]string) (RegistryAliases, error) {
+	ra := RegistryAliases{}
+	for alias, registry := range DefaultRegistryAliases {
+		ra[alias] = registry
+	}
+	for alias, registry := range aliases {
+		if alias == registry {
+			return nil, fmt.Errorf("registry %s can not be an alias of itself", alias)
+		}
+		_, isDefaultAlias := DefaultRegistryAliases[registry]
+		if _, ok := aliases[registry]; ok || isDefaultAlias {
+			return nil, fmt.Errorf("registry %s is an alias and can not be aliased by %s", registry, alias)
+		}
+		ra[alias] = registry
+	}
+	return ra, nil
+}
+
+// Canonical returns the canonical registry for the registry, which is the registry itself when it is not an alias.
+func (ra RegistryAliases) Canonical(registry string) string {
+	if canonical, ok := ra[registry]; ok {
+		return canonical
+	}
+	return registry
+}
+
+// CanonicalName returns the image name with the registry replaced by its canonical registry.
+func (ra RegistryAliases) CanonicalName(name string) string {
+	registry, rest, ok := strings.Cut(name, "/")
+	if !ok {
+		return name
+	}
+	return ra.Canonical(registry) + "/" + rest
+}
+
+// Registries returns all registries serving the same content as the registry. The registry itself is
+// returned first followed by the canonical registry and its other aliases.
+func (ra RegistryAliases) Registries(registry string) []string {
+	canonical := ra.Canonical(registry)
+	others := []string{}
+	if canonical != registry {
+		others = append(others, canonical)
+	}
+	aliases := []string{}
+	for alias, r := range ra {
+		if r != canonical || alias == registry {
+			continue
+		}
+		aliases = append(aliases, alias)
+	}
+	slices.Sort(aliases)
+	return slices.Concat([]string{registry}, others, aliases)
+}
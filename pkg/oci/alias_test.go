@@ -0,0 +1,32 @@
+package oci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryAliases(t *testing.T) {
+	t.Parallel()
+
+	ra, err := NewRegistryAliases(map[string]string{"mirror.example.com": "ghcr.io"})
+	require.NoError(t, err)
+
+	require.Equal(t, "docker.io", ra.Canonical("registry-1.docker.io"))
+	require.Equal(t, "docker.io", ra.Canonical("docker.io"))
+	require.Equal(t, "ghcr.io", ra.Canonical("mirror.example.com"))
+	require.Equal(t, "quay.io", ra.Canonical("quay.io"))
+
+	require.Equal(t, "docker.io/library/nginx:1.27", ra.CanonicalName("registry-1.docker.io/library/nginx:1.27"))
+	require.Equal(t, "ghcr.io/spegel-org/spegel:v0.0.1", ra.CanonicalName("ghcr.io/spegel-org/spegel:v0.0.1"))
+
+	require.Equal(t, []string{"docker.io", "index.docker.io", "registry-1.docker.io"}, ra.Registries("docker.io"))
+	require.Equal(t, []string{"registry-1.docker.io", "docker.io", "index.docker.io"}, ra.Registries("registry-1.docker.io"))
+	require.Equal(t, []string{"quay.io"}, ra.Registries("quay.io"))
+	require.Equal(t, []string{"quay.io"}, RegistryAliases(nil).Registries("quay.io"))
+
+	_, err = NewRegistryAliases(map[string]string{"ghcr.io": "ghcr.io"})
+	require.EqualError(t, err, "registry ghcr.io can not be an alias of itself")
+	_, err = NewRegistryAliases(map[string]string{"foo.example.com": "registry-1.docker.io"})
+	require.EqualError(t, err, "registry registry-1.docker.io is an alias and can not be aliased by foo.example.com")
+}
@@ -0,0 +1,148 @@
+package oci
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spegel-org/spegel/internal/lru"
+	"github.com/spegel-org/spegel/pkg/metrics"
+)
+
+// Name of the subdirectory owned by the blob cache, so that other files in the configured directory are left alone.
+const blobCacheSubdir = "spegel-blobs"
+
+// blobCache is a least recently used cache of blobs on local disk bounded by the total size of the cached blobs.
+// It speeds up repeated reads when the content path is on slow storage, such as a network filesystem.
+type blobCache struct {
+	cache   *lru.Cache[digest.Digest, int64]
+	filling map[digest.Digest]struct{}
+	dir     string
+	wg      sync.WaitGroup
+	mx      sync.Mutex
+	maxSize int64
+}
+
+// newBlobCache creates a blob cache in a subdirectory of the directory. The subdirectory is emptied as cached blobs
+// are only tracked in memory.
+func newBlobCache(dir string, maxSize int64) (*blobCache, error) {
+	dir = filepath.Join(dir, blobCacheSubdir)
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	metrics.BlobCacheSizeBytes.Set(0)
+	b := &blobCache{
+		filling: map[digest.Digest]struct{}{},
+		dir:     dir,
+		maxSize: maxSize,
+	}
+	sizeFn := func(size int64) int64 {
+		return size
+	}
+	// Readers which already opened an evicted blob can still read it after it has been removed.
+	onEvict := func(dgst digest.Digest, _ int64) {
+		//nolint:errcheck // The blob is no longer tracked, even if it could not be removed.
+		os.Remove(b.path(dgst))
+	}
+	b.cache = lru.New(maxSize, lru.WithSize[digest.Digest](sizeFn), lru.WithEvict(onEvict))
+	return b, nil
+}
+
+// open returns the cached blob. On a miss the source is returned while the blob is copied into the cache in the
+// background, so that the first read is not delayed by the copy. Blobs which do not fit in the cache are never copied.
+func (b *blobCache) open(dgst digest.Digest, srcPath string) (*os.File, error) {
+	if _, ok := b.cache.Get(dgst); ok {
+		// The blob may have been evicted after it was looked up, in which case it is read from the source.
+		file, err := os.Open(b.path(dgst))
+		if err == nil {
+			metrics.BlobCacheRequestsTotal.WithLabelValues("hit").Inc()
+			return file, nil
+		}
+		b.cache.Remove(dgst)
+		metrics.BlobCacheSizeBytes.Set(float64(b.cache.Size()))
+	}
+	metrics.BlobCacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := src.Stat()
+	if err != nil {
+		//nolint:errcheck // Error from stat is returned.
+		src.Close()
+		return nil, err
+	}
+	if fi.Size() > b.maxSize {
+		return src, nil
+	}
+	b.mx.Lock()
+	_, filling := b.filling[dgst]
+	if !filling {
+		b.filling[dgst] = struct{}{}
+	}
+	b.mx.Unlock()
+	// Only a single copy is made per blob, concurrent misses are served from the source.
+	if !filling {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.fill(dgst, srcPath)
+		}()
+	}
+	return src, nil
+}
+
+// fill copies the blob from the source path into the cache. Failures are ignored as the blob will be copied on
+// the next miss.
+func (b *blobCache) fill(dgst digest.Digest, srcPath string) {
+	defer func() {
+		b.mx.Lock()
+		delete(b.filling, dgst)
+		b.mx.Unlock()
+	}()
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	// Copy to a temporary file first so that a partially written blob is never read.
+	tmp, err := os.CreateTemp(b.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	size, err := io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		//nolint:errcheck // Error from copy is handled by removing the temporary file.
+		tmp.Close()
+	}
+	if err == nil && size > b.maxSize {
+		err = errors.New("blob does not fit in cache")
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), b.path(dgst))
+	}
+	if err != nil {
+		//nolint:errcheck // The blob is copied again on the next miss.
+		os.Remove(tmp.Name())
+		return
+	}
+	b.cache.Add(dgst, size)
+	metrics.BlobCacheSizeBytes.Set(float64(b.cache.Size()))
+}
+
+func (b *blobCache) path(dgst digest.Digest) string {
+	return filepath.Join(b.dir, dgst.Algorithm().String()+"-"+dgst.Encoded())
+}
@@ -0,0 +1,74 @@
+package oci
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobCache(t *testing.T) {
+	t.Parallel()
+
+	srcDir := t.TempDir()
+	blobs := map[string]digest.Digest{}
+	for _, v := range []string{"foo", "bar", "hello", "hello world"} {
+		dgst := digest.FromString(v)
+		err := os.WriteFile(filepath.Join(srcDir, dgst.Encoded()), []byte(v), 0o600)
+		require.NoError(t, err)
+		blobs[v] = dgst
+	}
+	cacheDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(cacheDir, blobCacheSubdir), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(cacheDir, blobCacheSubdir, "stale"), []byte("stale"), 0o600)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(cacheDir, "other"), []byte("other"), 0o600)
+	require.NoError(t, err)
+	b, err := newBlobCache(cacheDir, 10)
+	require.NoError(t, err)
+	// Blobs from a previous run are removed while other files in the directory are kept.
+	require.NoFileExists(t, filepath.Join(cacheDir, blobCacheSubdir, "stale"))
+	require.FileExists(t, filepath.Join(cacheDir, "other"))
+
+	read := func(v string) {
+		t.Helper()
+
+		file, err := b.open(blobs[v], filepath.Join(srcDir, blobs[v].Encoded()))
+		require.NoError(t, err)
+		defer file.Close()
+		content, err := io.ReadAll(file)
+		require.NoError(t, err)
+		require.Equal(t, v, string(content))
+		// Wait for the blob to be copied into the cache on a miss.
+		b.wg.Wait()
+	}
+
+	read("foo")
+	read("bar")
+	require.FileExists(t, b.path(blobs["foo"]))
+	require.FileExists(t, b.path(blobs["bar"]))
+	require.Equal(t, int64(6), b.cache.Size())
+
+	// Removing the source does not affect cached blobs.
+	err = os.Remove(filepath.Join(srcDir, blobs["foo"].Encoded()))
+	require.NoError(t, err)
+	read("foo")
+
+	// Least recently used blobs are evicted first.
+	read("hello")
+	require.NoFileExists(t, b.path(blobs["bar"]))
+	require.FileExists(t, b.path(blobs["foo"]))
+	require.Equal(t, int64(8), b.cache.Size())
+
+	// Blobs larger than the max size are read from the source.
+	read("hello world")
+	require.NoFileExists(t, b.path(blobs["hello world"]))
+	require.Equal(t, int64(8), b.cache.Size())
+
+	_, err = b.open(digest.FromString("missing"), filepath.Join(srcDir, "missing"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
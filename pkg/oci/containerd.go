@@ -6,16 +6,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	iofs "io/fs"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/containerd/containerd"
 	eventtypes "github.com/containerd/containerd/api/events"
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/plugin"
 	"github.com/containerd/typeurl/v2"
 	"github.com/go-logr/logr"
 	"github.com/opencontainers/go-digest"
@@ -25,44 +29,126 @@ import (
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 
 	"github.com/spegel-org/spegel/internal/channel"
+	"github.com/spegel-org/spegel/internal/lru"
+	"github.com/spegel-org/spegel/pkg/metrics"
 )
 
 const (
 	backupDir = "_backup"
+	// DefaultContentPath is the default location of the Containerd content store.
+	DefaultContentPath = "/var/lib/containerd/io.containerd.content.v1.content"
 )
 
 var _ Client = &Containerd{}
 
+const (
+	// Amount of digests for which the media type is cached to avoid the slow fallback lookup.
+	mediaTypeCacheSize = 4096
+	// Amount of image digests for which the parsed referrer is cached to avoid reading every image when listing referrers.
+	referrerCacheSize = 4096
+)
+
 type Containerd struct {
+	platformMatcher    platforms.Matcher
+	mediaTypeCache     *lru.Cache[digest.Digest, string]
+	referrerCache      *lru.Cache[digest.Digest, referrer]
+	blobCache          *blobCache
 	contentPath        string
+	platform           string
 	client             *containerd.Client
 	clientGetter       func() (*containerd.Client, error)
 	listFilter         string
 	eventFilter        string
 	registryConfigPath string
+	blobCacheDir       string
+	repoPrefixes       []RepositoryPrefix
+	blobCacheSize      int64
+	mediaTypeWarmup    bool
+	detectContent      bool
 }
 
 type Option func(*Containerd)
 
+type ContainerdInfo struct {
+	Version  string
+	Revision string
+	Plugins  []string
+}
+
+// WithContentPath sets the path to the Containerd content store. When empty the path is detected from Containerd,
+// falling back to the default path.
 func WithContentPath(path string) Option {
 	return func(c *Containerd) {
 		c.contentPath = path
 	}
 }
 
+// WithBlobCache caches blobs read from the content path in the directory, up to the total size in bytes. Useful when
+// the content path is on slow storage, such as a network filesystem, and the directory is on fast local storage.
+// Blobs are stored in a subdirectory which is emptied on startup. The cache is disabled when the directory is empty or the size is zero.
+func WithBlobCache(dir string, size int64) Option {
+	return func(c *Containerd) {
+		c.blobCacheDir = dir
+		c.blobCacheSize = size
+	}
+}
+
+// WithMediaTypeWarmup caches the media types of image indexes and manifests while walking images when they are
+// advertised, so that manifests are served without the slower fallback lookup in Containerd.
+func WithMediaTypeWarmup(enabled bool) Option {
+	return func(c *Containerd) {
+		c.mediaTypeWarmup = enabled
+	}
+}
+
+// WithPlatform only advertises manifests for the platform, instead of all platforms with local content. Content for
+// other platforms is still served when requested by digest.
+// The platform is formatted as os/arch/variant, for example linux/arm64.
+func WithPlatform(platform string) Option {
+	return func(c *Containerd) {
+		c.platform = platform
+	}
+}
+
+// WithRepositoryPrefixes only advertises images within the repository prefixes for registries which have a prefix.
+// Images from registries without a prefix are all advertised.
+func WithRepositoryPrefixes(prefixes []RepositoryPrefix) Option {
+	return func(c *Containerd) {
+		c.repoPrefixes = prefixes
+	}
+}
+
 func NewContainerd(sock, namespace, registryConfigPath string, registries []url.URL, opts ...Option) (*Containerd, error) {
-	listFilter, eventFilter := createFilters(registries)
 	c := &Containerd{
 		clientGetter: func() (*containerd.Client, error) {
 			return containerd.New(sock, containerd.WithDefaultNamespace(namespace))
 		},
-		listFilter:         listFilter,
-		eventFilter:        eventFilter,
 		registryConfigPath: registryConfigPath,
 	}
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.contentPath == "" {
+		c.contentPath = DefaultContentPath
+		c.detectContent = true
+	}
+	c.listFilter, c.eventFilter = createFilters(registries, c.repoPrefixes)
+	c.mediaTypeCache = lru.New[digest.Digest, string](mediaTypeCacheSize)
+	c.referrerCache = lru.New[digest.Digest, referrer](referrerCacheSize)
+	if c.blobCacheDir != "" && c.blobCacheSize > 0 {
+		blobCache, err := newBlobCache(c.blobCacheDir, c.blobCacheSize)
+		if err != nil {
+			return nil, fmt.Errorf("could not create blob cache: %w", err)
+		}
+		c.blobCache = blobCache
+	}
+	if c.platform != "" {
+		p, err := platforms.Parse(c.platform)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse platform %s: %w", c.platform, err)
+		}
+		c.platformMatcher = platforms.Only(p)
+	}
 	return c, nil
 }
 
@@ -98,9 +184,73 @@ func (c *Containerd) Verify(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if c.detectContent {
+		err = c.detectContentPath(ctx)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// detectContentPath replaces the content path with the root exported by the Containerd content plugin.
+// Distributions like k3s and RKE2 do not store content in the default location.
+func (c *Containerd) detectContentPath(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx)
+	client, err := c.Client()
+	if err != nil {
+		return err
+	}
+	resp, err := client.IntrospectionService().Plugins(ctx, []string{fmt.Sprintf("type==%s", plugin.ContentPlugin)})
+	if err != nil {
+		return err
+	}
+	for _, p := range resp.Plugins {
+		root, ok := p.Exports["root"]
+		if !ok || root == c.contentPath {
+			continue
+		}
+		// The content path has to be mounted for Spegel to be able to read from it.
+		if _, err := os.Stat(root); err != nil {
+			log.Info("detected Containerd content path is not accessible, using configured path", "detected", root, "path", c.contentPath)
+			return nil
+		}
+		c.contentPath = root
+		break
+	}
+	log.Info("using Containerd content path", "path", c.contentPath)
+	return nil
+}
+
+// Info returns the version of the Containerd service and the plugins which were successfully loaded.
+func (c *Containerd) Info(ctx context.Context) (ContainerdInfo, error) {
+	client, err := c.Client()
+	if err != nil {
+		return ContainerdInfo{}, err
+	}
+	version, err := client.Version(ctx)
+	if err != nil {
+		return ContainerdInfo{}, err
+	}
+	resp, err := client.IntrospectionService().Plugins(ctx, nil)
+	if err != nil {
+		return ContainerdInfo{}, err
+	}
+	plugins := []string{}
+	for _, plugin := range resp.Plugins {
+		if plugin.InitErr != nil {
+			continue
+		}
+		plugins = append(plugins, fmt.Sprintf("%s.%s", plugin.Type, plugin.ID))
+	}
+	info := ContainerdInfo{
+		Version:  version.Version,
+		Revision: version.Revision,
+		Plugins:  plugins,
+	}
+	return info, nil
+}
+
 func verifyStatusResponse(resp *runtimeapi.StatusResponse, configPath string) error {
 	str, ok := resp.Info["config"]
 	if !ok {
@@ -175,7 +325,7 @@ func (c *Containerd) Subscribe(ctx context.Context) (<-chan ImageEvent, <-chan e
 					continue
 				}
 			}
-			imgCh <- ImageEvent{Image: img, Type: eventType}
+			imgCh <- ImageEvent{Image: img, Type: eventType, Timestamp: envelope.Timestamp}
 		}
 	}()
 	return imgCh, channel.Merge(errCh, cErrCh), nil
@@ -202,6 +352,15 @@ func (c *Containerd) ListImages(ctx context.Context) ([]Image, error) {
 }
 
 func (c *Containerd) AllIdentifiers(ctx context.Context, img Image) ([]string, error) {
+	return c.identifiers(ctx, img, true)
+}
+
+// ManifestIdentifiers returns the digests of the image indexes and manifests, excluding config and layer blobs.
+func (c *Containerd) ManifestIdentifiers(ctx context.Context, img Image) ([]string, error) {
+	return c.identifiers(ctx, img, false)
+}
+
+func (c *Containerd) identifiers(ctx context.Context, img Image, includeBlobs bool) ([]string, error) {
 	client, err := c.Client()
 	if err != nil {
 		return nil, err
@@ -213,6 +372,9 @@ func (c *Containerd) AllIdentifiers(ctx context.Context, img Image) ([]string, e
 	keys := []string{}
 	err = images.Walk(ctx, images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
 		keys = append(keys, desc.Digest.String())
+		if c.mediaTypeWarmup {
+			c.cacheMediaType(desc.Digest, desc.MediaType)
+		}
 		switch desc.MediaType {
 		case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
 			var idx ocispec.Index
@@ -224,18 +386,30 @@ func (c *Containerd) AllIdentifiers(ctx context.Context, img Image) ([]string, e
 				return nil, err
 			}
 			var descs []ocispec.Descriptor
+			platformSkipped := false
 			for _, m := range idx.Manifests {
 				// Skip index layers that do not exist locally
 				if _, err := client.ContentStore().Info(ctx, m.Digest); err != nil {
 					continue
 				}
+				if c.platformMatcher != nil && m.Platform != nil && !c.platformMatcher.Match(*m.Platform) {
+					platformSkipped = true
+					continue
+				}
 				descs = append(descs, m)
 			}
+			// Only the index is advertised when the local content is for other platforms.
+			if len(descs) == 0 && platformSkipped {
+				return nil, nil
+			}
 			if len(descs) == 0 {
 				return nil, fmt.Errorf("could not find any platforms with local content in manifest list: %v", desc.Digest)
 			}
 			return descs, nil
 		case images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest:
+			if !includeBlobs {
+				return nil, nil
+			}
 			var manifest ocispec.Manifest
 			b, err := content.ReadBlob(ctx, client.ContentStore(), desc)
 			if err != nil {
@@ -320,11 +494,21 @@ func (c *Containerd) GetManifest(ctx context.Context, dgst digest.Digest) ([]byt
 func (c *Containerd) GetBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
 	if c.contentPath != "" {
 		path := filepath.Join(c.contentPath, "blobs", dgst.Algorithm().String(), dgst.Encoded())
-		file, err := os.Open(path)
-		if err != nil {
+		var file *os.File
+		var err error
+		if c.blobCache != nil {
+			file, err = c.blobCache.open(dgst, path)
+		} else {
+			file, err = os.Open(path)
+		}
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, iofs.ErrNotExist) {
 			return nil, err
 		}
-		return file, nil
+		// Blobs missing from the content path may still exist in the content store if the path is misconfigured.
+		metrics.ContentPathMissesTotal.Inc()
 	}
 	client, err := c.Client()
 	if err != nil {
@@ -343,10 +527,95 @@ func (c *Containerd) GetBlob(ctx context.Context, dgst digest.Digest) (io.ReadCl
 	}, nil
 }
 
+// ListReferrers returns descriptors for all local image manifests and indexes whose subject is the given digest.
+// Images which can not be read or parsed are skipped. Parsed images are cached by digest, as their content never
+// changes, so that only images added since the last call have to be read.
+func (c *Containerd) ListReferrers(ctx context.Context, dgst digest.Digest) ([]ocispec.Descriptor, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	client, err := c.Client()
+	if err != nil {
+		return nil, err
+	}
+	cImgs, err := client.ImageService().List(ctx, c.listFilter)
+	if err != nil {
+		return nil, err
+	}
+	descs := []ocispec.Descriptor{}
+	seen := map[digest.Digest]interface{}{}
+	for _, cImg := range cImgs {
+		if _, ok := seen[cImg.Target.Digest]; ok {
+			continue
+		}
+		seen[cImg.Target.Digest] = nil
+		if !isReferrerType(cImg.Target.MediaType) {
+			continue
+		}
+		ref, ok := c.cachedReferrer(cImg.Target.Digest)
+		if !ok {
+			b, err := content.ReadBlob(ctx, client.ContentStore(), cImg.Target)
+			if err != nil {
+				log.Error(err, "skipping image when listing referrers", "image", cImg.Name, "digest", cImg.Target.Digest.String())
+				continue
+			}
+			ref, err = parseReferrer(cImg.Target, b)
+			if err != nil {
+				log.Error(err, "skipping image when listing referrers", "image", cImg.Name, "digest", cImg.Target.Digest.String())
+				continue
+			}
+			c.cacheReferrer(cImg.Target.Digest, ref)
+		}
+		if ref.subject != dgst {
+			continue
+		}
+		descs = append(descs, ref.desc)
+	}
+	return descs, nil
+}
+
 // lookupMediaType will resolve the media type for a digest without looking at the content.
 // Only use this as a fallback method as it is a lot slower than reading it from the file.
-// TODO: A cache would be helpful to speed up lookups for the same digets.
 func (c *Containerd) lookupMediaType(ctx context.Context, dgst digest.Digest) (string, error) {
+	if mt, ok := c.cachedMediaType(dgst); ok {
+		return mt, nil
+	}
+	mt, err := c.resolveMediaType(ctx, dgst)
+	if err != nil {
+		return "", err
+	}
+	c.cacheMediaType(dgst, mt)
+	return mt, nil
+}
+
+func (c *Containerd) cachedMediaType(dgst digest.Digest) (string, bool) {
+	if c.mediaTypeCache == nil {
+		return "", false
+	}
+	return c.mediaTypeCache.Get(dgst)
+}
+
+// cacheMediaType caches the media type of image indexes and manifests, which are the only content served with the fallback lookup.
+func (c *Containerd) cacheMediaType(dgst digest.Digest, mediaType string) {
+	if c.mediaTypeCache == nil || !images.IsIndexType(mediaType) && !images.IsManifestType(mediaType) {
+		return
+	}
+	c.mediaTypeCache.Add(dgst, mediaType)
+}
+
+func (c *Containerd) cachedReferrer(dgst digest.Digest) (referrer, bool) {
+	if c.referrerCache == nil {
+		return referrer{}, false
+	}
+	return c.referrerCache.Get(dgst)
+}
+
+func (c *Containerd) cacheReferrer(dgst digest.Digest, ref referrer) {
+	if c.referrerCache == nil {
+		return
+	}
+	c.referrerCache.Add(dgst, ref)
+}
+
+func (c *Containerd) resolveMediaType(ctx context.Context, dgst digest.Digest) (string, error) {
 	logr.FromContextOrDiscard(ctx).Info("using Containerd fallback method to determine media type", "digest", dgst.String())
 	client, err := c.Client()
 	if err != nil {
@@ -442,12 +711,30 @@ func getEventImage(e typeurl.Any) (string, EventType, error) {
 	}
 }
 
-func createFilters(registries []url.URL) (string, string) {
+func createFilters(registries []url.URL, prefixes []RepositoryPrefix) (string, string) {
 	registryHosts := []string{}
+	repoNames := []string{}
 	for _, registry := range registries {
-		registryHosts = append(registryHosts, strings.ReplaceAll(registry.Host, `.`, `\\.`))
+		host := strings.ReplaceAll(registry.Host, `.`, `\\.`)
+		hasPrefix := false
+		for _, prefix := range prefixes {
+			if prefix.Registry != registry.Host {
+				continue
+			}
+			hasPrefix = true
+			repoNames = append(repoNames, host+"/"+strings.ReplaceAll(prefix.Repository, `.`, `\\.`))
+		}
+		if !hasPrefix {
+			registryHosts = append(registryHosts, host)
+		}
+	}
+	listFilter := fmt.Sprintf(`name~="^(%s)/"`, strings.Join(slices.Concat(registryHosts, repoNames), "|"))
+	if len(repoNames) > 0 {
+		// Images named after the prefix itself are followed by a tag or digest instead of a path separator.
+		listFilter = fmt.Sprintf(`name~="^(%s)/|^(%s)[:@]"`, strings.Join(slices.Concat(registryHosts, repoNames), "|"), strings.Join(repoNames, "|"))
 	}
-	listFilter := fmt.Sprintf(`name~="^(%s)/"`, strings.Join(registryHosts, "|"))
+	// Only image events are subscribed to. Content create events are intentionally not used
+	// as their volume can be very high, which means that discovery happens at image granularity.
 	eventFilter := fmt.Sprintf(`topic~="/images/create|/images/update|/images/delete",event.%s`, listFilter)
 	return listFilter, eventFilter
 }
@@ -467,6 +754,9 @@ func isImageConfig(ic ocispec.Image) bool {
 
 type hostFile struct {
 	HostConfigs map[string]hostConfig `toml:"host"`
+	CACert      interface{}           `toml:"ca"`
+	Client      interface{}           `toml:"client"`
+	SkipVerify  *bool                 `toml:"skip_verify"`
 	Server      string                `toml:"server"`
 }
 
@@ -479,64 +769,168 @@ type hostConfig struct {
 	Capabilities []string               `toml:"capabilities"`
 }
 
+type mirrorConfiguration struct {
+	mirrorCapabilities  map[string][]string
+	resolveTags         bool
+	appendToBackup      bool
+	preserveUpstreamTLS bool
+	dryRun              bool
+}
+
+type MirrorConfigurationOption func(*mirrorConfiguration)
+
+// WithMirrorCapabilities sets the capabilities of individual mirrors keyed by the mirror URL.
+// Mirrors without capabilities get pull, and resolve when tags are resolved.
+func WithMirrorCapabilities(mirrorCapabilities map[string][]string) MirrorConfigurationOption {
+	return func(c *mirrorConfiguration) {
+		c.mirrorCapabilities = mirrorCapabilities
+	}
+}
+
+// WithResolveTags adds the resolve capability to mirrors without explicit capabilities.
+func WithResolveTags(resolveTags bool) MirrorConfigurationOption {
+	return func(c *mirrorConfiguration) {
+		c.resolveTags = resolveTags
+	}
+}
+
+// WithAppendToBackup keeps the hosts from the existing configuration after the mirrors.
+func WithAppendToBackup(appendToBackup bool) MirrorConfigurationOption {
+	return func(c *mirrorConfiguration) {
+		c.appendToBackup = appendToBackup
+	}
+}
+
+// WithPreserveUpstreamTLS keeps the TLS settings for the upstream registry from the existing configuration.
+func WithPreserveUpstreamTLS(preserveUpstreamTLS bool) MirrorConfigurationOption {
+	return func(c *mirrorConfiguration) {
+		c.preserveUpstreamTLS = preserveUpstreamTLS
+	}
+}
+
+// WithDryRun renders the configuration without writing it.
+func WithDryRun(dryRun bool) MirrorConfigurationOption {
+	return func(c *mirrorConfiguration) {
+		c.dryRun = dryRun
+	}
+}
+
 // Refer to containerd registry configuration documentation for mor information about required configuration.
 // https://github.com/containerd/containerd/blob/main/docs/cri/config.md#registry-configuration
 // https://github.com/containerd/containerd/blob/main/docs/hosts.md#registry-configuration---examples
-func AddMirrorConfiguration(ctx context.Context, fs afero.Fs, configPath string, registryURLs, mirrorURLs []url.URL, resolveTags, appendToBackup bool) error {
+// The rendered host files are returned keyed by path. When dry run is enabled nothing is written.
+func AddMirrorConfiguration(ctx context.Context, fs afero.Fs, configPath string, registryURLs, mirrorURLs []url.URL, opts ...MirrorConfigurationOption) (map[string]string, error) {
+	cfg := mirrorConfiguration{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 	log := logr.FromContextOrDiscard(ctx)
-	err := validateRegistries(registryURLs)
+	err := ValidateRegistries(registryURLs)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if cfg.dryRun {
+		// Existing configuration is only moved to the backup directory if it does not already exist.
+		existingPath := path.Join(configPath, backupDir)
+		ok, err := afero.DirExists(fs, existingPath)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			existingPath = configPath
+		}
+		return renderMirrorConfiguration(log, fs, configPath, existingPath, registryURLs, mirrorURLs, cfg)
 	}
 	err = fs.MkdirAll(configPath, 0o755)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = backupConfig(log, fs, configPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = clearConfig(fs, configPath)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	files, err := renderMirrorConfiguration(log, fs, configPath, path.Join(configPath, backupDir), registryURLs, mirrorURLs, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for fp, data := range files {
+		err = fs.MkdirAll(path.Dir(fp), 0o755)
+		if err != nil {
+			return nil, err
+		}
+		err = afero.WriteFile(fs, fp, []byte(data), 0o644)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("added Containerd mirror configuration", "path", fp)
 	}
+	return files, nil
+}
 
-	// Write mirror configuration
+func renderMirrorConfiguration(log logr.Logger, fs afero.Fs, configPath, existingPath string, registryURLs, mirrorURLs []url.URL, cfg mirrorConfiguration) (map[string]string, error) {
 	capabilities := []string{"pull"}
-	if resolveTags {
+	if cfg.resolveTags {
 		capabilities = append(capabilities, "resolve")
 	}
+	files := map[string]string{}
 	for _, registryURL := range registryURLs {
-		hf, appending, err := getHostFile(fs, configPath, appendToBackup, registryURL)
+		hf, existing, err := getHostFile(fs, existingPath, cfg.appendToBackup, cfg.preserveUpstreamTLS, registryURL)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		for _, u := range mirrorURLs {
-			hf.HostConfigs[u.String()] = hostConfig{Capabilities: capabilities}
+			mirrorCaps, ok := cfg.mirrorCapabilities[u.String()]
+			if !ok {
+				mirrorCaps = capabilities
+			}
+			hf.HostConfigs[u.String()] = hostConfig{Capabilities: mirrorCaps}
 		}
 		b, err := toml.Marshal(&hf)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		fp := path.Join(configPath, registryURL.Host, "hosts.toml")
-		err = fs.MkdirAll(path.Dir(fp), 0o755)
-		if err != nil {
-			return err
+		switch {
+		case existing && cfg.appendToBackup:
+			log.Info("appending to existing Containerd mirror configuration", "registry", registryURL.String(), "path", fp)
+		case existing && cfg.preserveUpstreamTLS:
+			log.Info("preserving upstream TLS settings from existing Containerd mirror configuration", "registry", registryURL.String(), "path", fp)
 		}
-		err = afero.WriteFile(fs, fp, b, 0o644)
+		files[fp] = string(b)
+	}
+	return files, nil
+}
+
+// ParseMirrorCapabilities parses capabilities for individual mirrors formatted as url=capability,capability.
+// The returned capabilities are keyed by the mirror URL. Only the pull and resolve capabilities are accepted.
+func ParseMirrorCapabilities(values []string) (map[string][]string, error) {
+	mirrorCapabilities := map[string][]string{}
+	for _, v := range values {
+		i := strings.LastIndex(v, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("mirror capabilities %s has to be formatted as url=capability,capability", v)
+		}
+		u, err := url.Parse(v[:i])
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if appending {
-			log.Info("appending to existing Containerd mirror configuration", "registry", registryURL.String(), "path", fp)
-		} else {
-			log.Info("added Containerd mirror configuration", "registry", registryURL.String(), "path", fp)
+		capabilities := strings.Split(v[i+1:], ",")
+		for _, capability := range capabilities {
+			if capability != "pull" && capability != "resolve" {
+				return nil, fmt.Errorf("invalid mirror capability %q for %s, must be pull or resolve", capability, u.String())
+			}
 		}
+		mirrorCapabilities[u.String()] = capabilities
 	}
-	return nil
+	return mirrorCapabilities, nil
 }
 
-func validateRegistries(urls []url.URL) error {
+// ValidateRegistries checks that the registry URLs only contain a scheme and host.
+func ValidateRegistries(urls []url.URL) error {
 	errs := []error{}
 	for _, u := range urls {
 		if u.Scheme != "http" && u.Scheme != "https" {
@@ -605,9 +999,13 @@ func clearConfig(fs afero.Fs, configPath string) error {
 	return nil
 }
 
-func getHostFile(fs afero.Fs, configPath string, appendToBackup bool, registryURL url.URL) (hostFile, bool, error) {
-	if appendToBackup {
-		fp := path.Join(configPath, backupDir, registryURL.Host, "hosts.toml")
+func getHostFile(fs afero.Fs, existingPath string, appendToBackup, preserveUpstreamTLS bool, registryURL url.URL) (hostFile, bool, error) {
+	server := registryURL.String()
+	if registryURL.String() == "https://docker.io" {
+		server = "https://registry-1.docker.io"
+	}
+	if appendToBackup || preserveUpstreamTLS {
+		fp := path.Join(existingPath, registryURL.Host, "hosts.toml")
 		b, err := afero.ReadFile(fs, fp)
 		if err != nil && !errors.Is(err, afero.ErrFileNotFound) {
 			return hostFile{}, false, err
@@ -618,16 +1016,37 @@ func getHostFile(fs afero.Fs, configPath string, appendToBackup bool, registryUR
 			if err != nil {
 				return hostFile{}, false, err
 			}
-			return hf, true, nil
+			if hf.HostConfigs == nil {
+				hf.HostConfigs = map[string]hostConfig{}
+			}
+			if appendToBackup {
+				return hf, true, nil
+			}
+			return upstreamHostFile(hf, server), true, nil
 		}
 	}
-	server := registryURL.String()
-	if registryURL.String() == "https://docker.io" {
-		server = "https://registry-1.docker.io"
-	}
 	hf := hostFile{
 		Server:      server,
 		HostConfigs: map[string]hostConfig{},
 	}
 	return hf, false, nil
 }
+
+// upstreamHostFile keeps the upstream server and its TLS settings from an existing host file.
+// Host entries for other mirrors are dropped, as they would be when not appending.
+func upstreamHostFile(existing hostFile, server string) hostFile {
+	if existing.Server != "" {
+		server = existing.Server
+	}
+	hf := hostFile{
+		Server:      server,
+		CACert:      existing.CACert,
+		Client:      existing.Client,
+		SkipVerify:  existing.SkipVerify,
+		HostConfigs: map[string]hostConfig{},
+	}
+	if hc, ok := existing.HostConfigs[server]; ok {
+		hf.HostConfigs[server] = hc
+	}
+	return hf
+}
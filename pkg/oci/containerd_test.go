@@ -9,6 +9,7 @@ import (
 
 	eventtypes "github.com/containerd/containerd/api/events"
 	"github.com/containerd/typeurl/v2"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
@@ -20,13 +21,57 @@ func TestNewContainerd(t *testing.T) {
 
 	c, err := NewContainerd("socket", "namespace", "foo", nil)
 	require.NoError(t, err)
-	require.Empty(t, c.contentPath)
+	require.Equal(t, DefaultContentPath, c.contentPath)
+	require.True(t, c.detectContent)
 	require.Nil(t, c.client)
 	require.Equal(t, "foo", c.registryConfigPath)
 
 	c, err = NewContainerd("socket", "namespace", "foo", nil, WithContentPath("local"))
 	require.NoError(t, err)
 	require.Equal(t, "local", c.contentPath)
+	require.False(t, c.detectContent)
+
+	// The default path is used as is when set explicitly.
+	c, err = NewContainerd("socket", "namespace", "foo", nil, WithContentPath(DefaultContentPath))
+	require.NoError(t, err)
+	require.Equal(t, DefaultContentPath, c.contentPath)
+	require.False(t, c.detectContent)
+
+	c, err = NewContainerd("socket", "namespace", "foo", nil, WithPlatform("linux/arm64"))
+	require.NoError(t, err)
+	require.True(t, c.platformMatcher.Match(ocispec.Platform{OS: "linux", Architecture: "arm64"}))
+	require.False(t, c.platformMatcher.Match(ocispec.Platform{OS: "linux", Architecture: "amd64"}))
+
+	_, err = NewContainerd("socket", "namespace", "foo", nil, WithPlatform("linux/arm64/v8/foo"))
+	require.Error(t, err)
+}
+
+func TestMediaTypeCache(t *testing.T) {
+	t.Parallel()
+
+	dgst := digest.Digest("sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9")
+	c := &Containerd{}
+	c.cacheMediaType(dgst, ocispec.MediaTypeImageManifest)
+	_, ok := c.cachedMediaType(dgst)
+	require.False(t, ok)
+
+	c, err := NewContainerd("socket", "namespace", "foo", nil)
+	require.NoError(t, err)
+	_, ok = c.cachedMediaType(dgst)
+	require.False(t, ok)
+	c.cacheMediaType(dgst, "")
+	_, ok = c.cachedMediaType(dgst)
+	require.False(t, ok)
+	c.cacheMediaType(dgst, ocispec.MediaTypeImageLayerGzip)
+	_, ok = c.cachedMediaType(dgst)
+	require.False(t, ok)
+	c.cacheMediaType(dgst, ocispec.MediaTypeImageManifest)
+	mt, ok := c.cachedMediaType(dgst)
+	require.True(t, ok)
+	require.Equal(t, ocispec.MediaTypeImageManifest, mt)
+	mt, err = c.lookupMediaType(context.TODO(), dgst)
+	require.NoError(t, err)
+	require.Equal(t, ocispec.MediaTypeImageManifest, mt)
 }
 
 func TestVerifyStatusResponse(t *testing.T) {
@@ -100,6 +145,7 @@ func TestCreateFilter(t *testing.T) {
 		expectedListFilter  string
 		expectedEventFilter string
 		registries          []string
+		prefixes            []RepositoryPrefix
 	}{
 		{
 			name:                "only registries",
@@ -113,12 +159,19 @@ func TestCreateFilter(t *testing.T) {
 			expectedListFilter:  `name~="^(docker\\.io|gcr\\.io)/"`,
 			expectedEventFilter: `topic~="/images/create|/images/update|/images/delete",event.name~="^(docker\\.io|gcr\\.io)/"`,
 		},
+		{
+			name:                "repository prefixes",
+			registries:          []string{"https://docker.io", "https://ghcr.io"},
+			prefixes:            []RepositoryPrefix{{Registry: "ghcr.io", Repository: "myorg"}, {Registry: "ghcr.io", Repository: "other.org/app"}, {Registry: "quay.io", Repository: "foo"}},
+			expectedListFilter:  `name~="^(docker\\.io|ghcr\\.io/myorg|ghcr\\.io/other\\.org/app)/|^(ghcr\\.io/myorg|ghcr\\.io/other\\.org/app)[:@]"`,
+			expectedEventFilter: `topic~="/images/create|/images/update|/images/delete",event.name~="^(docker\\.io|ghcr\\.io/myorg|ghcr\\.io/other\\.org/app)/|^(ghcr\\.io/myorg|ghcr\\.io/other\\.org/app)[:@]"`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			listFilter, eventFilter := createFilters(stringListToUrlList(t, tt.registries))
+			listFilter, eventFilter := createFilters(stringListToUrlList(t, tt.registries), tt.prefixes)
 			require.Equal(t, tt.expectedListFilter, listFilter)
 			require.Equal(t, tt.expectedEventFilter, eventFilter)
 		})
@@ -239,12 +292,14 @@ func TestMirrorConfiguration(t *testing.T) {
 	tests := []struct {
 		existingFiles       map[string]string
 		expectedFiles       map[string]string
+		mirrorCapabilities  map[string][]string
 		name                string
 		registries          []url.URL
 		mirrors             []url.URL
 		resolveTags         bool
 		createConfigPathDir bool
 		appendToBackup      bool
+		preserveUpstreamTLS bool
 	}{
 		{
 			name:        "multiple mirros",
@@ -280,6 +335,24 @@ capabilities = ['pull']
 [host]
 [host.'http://127.0.0.1:5000']
 capabilities = ['pull']
+`,
+			},
+		},
+		{
+			name:               "per mirror capabilities",
+			resolveTags:        true,
+			registries:         stringListToUrlList(t, []string{"http://foo.bar:5000"}),
+			mirrors:            stringListToUrlList(t, []string{"http://127.0.0.1:5000", "http://127.0.0.1:5001"}),
+			mirrorCapabilities: map[string][]string{"http://127.0.0.1:5001": {"pull"}},
+			expectedFiles: map[string]string{
+				"/etc/containerd/certs.d/foo.bar:5000/hosts.toml": `server = 'http://foo.bar:5000'
+
+[host]
+[host.'http://127.0.0.1:5000']
+capabilities = ['pull', 'resolve']
+
+[host.'http://127.0.0.1:5001']
+capabilities = ['pull']
 `,
 			},
 		},
@@ -432,6 +505,54 @@ capabilities = ['pull', 'resolve']
 [host]
 [host.'http://127.0.0.1:5000']
 capabilities = ['pull', 'resolve']
+`,
+			},
+		},
+		{
+			name:                "preserve upstream TLS settings",
+			resolveTags:         true,
+			registries:          stringListToUrlList(t, []string{"https://docker.io"}),
+			mirrors:             stringListToUrlList(t, []string{"http://127.0.0.1:5000"}),
+			createConfigPathDir: true,
+			preserveUpstreamTLS: true,
+			existingFiles: map[string]string{
+				"/etc/containerd/certs.d/docker.io/hosts.toml": `server = 'https://registry-1.docker.io'
+ca = '/etc/certs/registry.crt'
+skip_verify = true
+
+[host]
+[host.'http://example.com:30020']
+capabilities = ['pull', 'resolve']
+
+[host.'https://registry-1.docker.io']
+ca = '/etc/certs/registry.crt'
+capabilities = ['pull', 'resolve']
+`,
+			},
+			expectedFiles: map[string]string{
+				"/etc/containerd/certs.d/_backup/docker.io/hosts.toml": `server = 'https://registry-1.docker.io'
+ca = '/etc/certs/registry.crt'
+skip_verify = true
+
+[host]
+[host.'http://example.com:30020']
+capabilities = ['pull', 'resolve']
+
+[host.'https://registry-1.docker.io']
+ca = '/etc/certs/registry.crt'
+capabilities = ['pull', 'resolve']
+`,
+				"/etc/containerd/certs.d/docker.io/hosts.toml": `ca = '/etc/certs/registry.crt'
+skip_verify = true
+server = 'https://registry-1.docker.io'
+
+[host]
+[host.'http://127.0.0.1:5000']
+capabilities = ['pull', 'resolve']
+
+[host.'https://registry-1.docker.io']
+ca = '/etc/certs/registry.crt'
+capabilities = ['pull', 'resolve']
 `,
 			},
 		},
@@ -449,8 +570,17 @@ capabilities = ['pull', 'resolve']
 				err := afero.WriteFile(fs, k, []byte(v), 0o644)
 				require.NoError(t, err)
 			}
-			err := AddMirrorConfiguration(context.TODO(), fs, registryConfigPath, tt.registries, tt.mirrors, tt.resolveTags, tt.appendToBackup)
+			opts := []MirrorConfigurationOption{
+				WithMirrorCapabilities(tt.mirrorCapabilities),
+				WithResolveTags(tt.resolveTags),
+				WithAppendToBackup(tt.appendToBackup),
+				WithPreserveUpstreamTLS(tt.preserveUpstreamTLS),
+			}
+			dryRunFiles, err := AddMirrorConfiguration(context.TODO(), fs, registryConfigPath, tt.registries, tt.mirrors, append(opts, WithDryRun(true))...)
 			require.NoError(t, err)
+			files, err := AddMirrorConfiguration(context.TODO(), fs, registryConfigPath, tt.registries, tt.mirrors, append(opts, WithDryRun(false))...)
+			require.NoError(t, err)
+			require.Equal(t, files, dryRunFiles)
 			if len(tt.existingFiles) == 0 {
 				ok, err := afero.DirExists(fs, "/etc/containerd/certs.d/_backup")
 				require.NoError(t, err)
@@ -472,6 +602,23 @@ capabilities = ['pull', 'resolve']
 	}
 }
 
+func TestParseMirrorCapabilities(t *testing.T) {
+	t.Parallel()
+
+	mirrorCapabilities, err := ParseMirrorCapabilities([]string{"http://127.0.0.1:5000=pull,resolve", "http://127.0.0.1:5001=pull"})
+	require.NoError(t, err)
+	expected := map[string][]string{
+		"http://127.0.0.1:5000": {"pull", "resolve"},
+		"http://127.0.0.1:5001": {"pull"},
+	}
+	require.Equal(t, expected, mirrorCapabilities)
+
+	_, err = ParseMirrorCapabilities([]string{"http://127.0.0.1:5000"})
+	require.EqualError(t, err, "mirror capabilities http://127.0.0.1:5000 has to be formatted as url=capability,capability")
+	_, err = ParseMirrorCapabilities([]string{"http://127.0.0.1:5000=pull,push"})
+	require.EqualError(t, err, `invalid mirror capability "push" for http://127.0.0.1:5000, must be pull or resolve`)
+}
+
 func TestMirrorConfigurationInvalidMirrorURL(t *testing.T) {
 	t.Parallel()
 
@@ -479,19 +626,19 @@ func TestMirrorConfigurationInvalidMirrorURL(t *testing.T) {
 	mirrors := stringListToUrlList(t, []string{"http://127.0.0.1:5000"})
 
 	registries := stringListToUrlList(t, []string{"ftp://docker.io"})
-	err := AddMirrorConfiguration(context.TODO(), fs, "/etc/containerd/certs.d", registries, mirrors, true, false)
+	_, err := AddMirrorConfiguration(context.TODO(), fs, "/etc/containerd/certs.d", registries, mirrors)
 	require.EqualError(t, err, "invalid registry url scheme must be http or https: ftp://docker.io")
 
 	registries = stringListToUrlList(t, []string{"https://docker.io/foo/bar"})
-	err = AddMirrorConfiguration(context.TODO(), fs, "/etc/containerd/certs.d", registries, mirrors, true, false)
+	_, err = AddMirrorConfiguration(context.TODO(), fs, "/etc/containerd/certs.d", registries, mirrors)
 	require.EqualError(t, err, "invalid registry url path has to be empty: https://docker.io/foo/bar")
 
 	registries = stringListToUrlList(t, []string{"https://docker.io?foo=bar"})
-	err = AddMirrorConfiguration(context.TODO(), fs, "/etc/containerd/certs.d", registries, mirrors, true, false)
+	_, err = AddMirrorConfiguration(context.TODO(), fs, "/etc/containerd/certs.d", registries, mirrors)
 	require.EqualError(t, err, "invalid registry url query has to be empty: https://docker.io?foo=bar")
 
 	registries = stringListToUrlList(t, []string{"https://foo@docker.io"})
-	err = AddMirrorConfiguration(context.TODO(), fs, "/etc/containerd/certs.d", registries, mirrors, true, false)
+	_, err = AddMirrorConfiguration(context.TODO(), fs, "/etc/containerd/certs.d", registries, mirrors)
 	require.EqualError(t, err, "invalid registry url user has to be empty: https://foo@docker.io")
 }
 
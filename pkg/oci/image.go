@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	digest "github.com/opencontainers/go-digest"
 )
@@ -28,7 +29,9 @@ const (
 
 type ImageEvent struct {
 	Image Image
-	Type  EventType
+	// Timestamp is the time at which the event occurred in the image store, if known.
+	Timestamp time.Time
+	Type      EventType
 }
 
 func NewImage(name, registry, repository, tag string, dgst digest.Digest) (Image, error) {
@@ -0,0 +1,70 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// VerifyContentSample hashes a random sample of the local content and returns the digests of content
+// which does not match its digest. All content is verified when the sample size exceeds the amount of content.
+func VerifyContentSample(ctx context.Context, client Client, sampleSize int) ([]digest.Digest, error) {
+	imgs, err := client.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	dgsts := []digest.Digest{}
+	for _, img := range imgs {
+		keys, err := client.AllIdentifiers(ctx, img)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			dgst, err := digest.Parse(key)
+			if err != nil {
+				return nil, err
+			}
+			dgsts = append(dgsts, dgst)
+		}
+	}
+	rand.Shuffle(len(dgsts), func(i, j int) {
+		dgsts[i], dgsts[j] = dgsts[j], dgsts[i]
+	})
+	if len(dgsts) > sampleSize {
+		dgsts = dgsts[:sampleSize]
+	}
+
+	mismatched := []digest.Digest{}
+	for _, dgst := range dgsts {
+		ok, err := verifyContent(ctx, client, dgst)
+		if err != nil {
+			return nil, fmt.Errorf("could not verify content %s: %w", dgst.String(), err)
+		}
+		if !ok {
+			mismatched = append(mismatched, dgst)
+		}
+	}
+	return mismatched, nil
+}
+
+func verifyContent(ctx context.Context, client Client, dgst digest.Digest) (bool, error) {
+	rc, err := client.GetBlob(ctx, dgst)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	verifier := dgst.Verifier()
+	_, err = io.Copy(verifier, rc)
+	if err != nil {
+		return false, err
+	}
+	return verifier.Verified(), nil
+}
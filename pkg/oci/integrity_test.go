@@ -0,0 +1,46 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+type contentClient struct {
+	*MockClient
+	content map[digest.Digest][]byte
+}
+
+func (c *contentClient) GetBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(c.content[dgst])), nil
+}
+
+func TestVerifyContentSample(t *testing.T) {
+	t.Parallel()
+
+	valid := []byte("valid")
+	fooImg, err := Parse("docker.io/library/foo:latest", digest.FromBytes(valid))
+	require.NoError(t, err)
+	corrupt := digest.FromString("corrupt")
+	barImg, err := Parse("docker.io/library/bar:latest", corrupt)
+	require.NoError(t, err)
+	client := &contentClient{
+		MockClient: NewMockClient([]Image{fooImg, barImg}),
+		content: map[digest.Digest][]byte{
+			fooImg.Digest: valid,
+			corrupt:       []byte("changed"),
+		},
+	}
+
+	mismatched, err := VerifyContentSample(context.TODO(), client, 10)
+	require.NoError(t, err)
+	require.Equal(t, []digest.Digest{corrupt}, mismatched)
+
+	mismatched, err = VerifyContentSample(context.TODO(), client, 0)
+	require.NoError(t, err)
+	require.Empty(t, mismatched)
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 
 	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 var _ Client = &MockClient{}
@@ -39,6 +40,10 @@ func (m *MockClient) AllIdentifiers(ctx context.Context, img Image) ([]string, e
 	return []string{img.Digest.String()}, nil
 }
 
+func (m *MockClient) ManifestIdentifiers(ctx context.Context, img Image) ([]string, error) {
+	return []string{img.Digest.String()}, nil
+}
+
 func (m *MockClient) Resolve(ctx context.Context, ref string) (digest.Digest, error) {
 	return "", nil
 }
@@ -54,3 +59,7 @@ func (m *MockClient) GetManifest(ctx context.Context, dgst digest.Digest) ([]byt
 func (m *MockClient) GetBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
 	return nil, nil
 }
+
+func (m *MockClient) ListReferrers(ctx context.Context, dgst digest.Digest) ([]ocispec.Descriptor, error) {
+	return nil, nil
+}
@@ -0,0 +1,179 @@
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/internal/channel"
+)
+
+var _ Client = &MultiClient{}
+
+// MultiClient serves content from multiple clients. Content is read from the first client in priority order
+// which has it, while images are the union of all clients.
+type MultiClient struct {
+	clients []Client
+}
+
+func NewMultiClient(clients ...Client) (*MultiClient, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("multi client requires at least one client")
+	}
+	return &MultiClient{
+		clients: clients,
+	}, nil
+}
+
+func (m *MultiClient) Name() string {
+	names := []string{}
+	for _, client := range m.clients {
+		names = append(names, client.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (m *MultiClient) Verify(ctx context.Context) error {
+	errs := []error{}
+	for _, client := range m.clients {
+		err := client.Verify(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not verify %s: %w", client.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MultiClient) Subscribe(ctx context.Context) (<-chan ImageEvent, <-chan error, error) {
+	eventChs := []<-chan ImageEvent{}
+	errChs := []<-chan error{}
+	for _, client := range m.clients {
+		eventCh, errCh, err := client.Subscribe(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if eventCh != nil {
+			eventChs = append(eventChs, eventCh)
+		}
+		if errCh != nil {
+			errChs = append(errChs, errCh)
+		}
+	}
+	return channel.Merge(eventChs...), channel.Merge(errChs...), nil
+}
+
+func (m *MultiClient) ListImages(ctx context.Context) ([]Image, error) {
+	imgs := []Image{}
+	seen := map[string]struct{}{}
+	for _, client := range m.clients {
+		clientImgs, err := client.ListImages(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range clientImgs {
+			key := img.Name + "@" + img.Digest.String()
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			imgs = append(imgs, img)
+		}
+	}
+	return imgs, nil
+}
+
+func (m *MultiClient) AllIdentifiers(ctx context.Context, img Image) ([]string, error) {
+	var dgsts []string
+	err := m.first(func(client Client) error {
+		var err error
+		dgsts, err = client.AllIdentifiers(ctx, img)
+		return err
+	})
+	return dgsts, err
+}
+
+func (m *MultiClient) ManifestIdentifiers(ctx context.Context, img Image) ([]string, error) {
+	var dgsts []string
+	err := m.first(func(client Client) error {
+		var err error
+		dgsts, err = client.ManifestIdentifiers(ctx, img)
+		return err
+	})
+	return dgsts, err
+}
+
+func (m *MultiClient) Resolve(ctx context.Context, ref string) (digest.Digest, error) {
+	var dgst digest.Digest
+	err := m.first(func(client Client) error {
+		var err error
+		dgst, err = client.Resolve(ctx, ref)
+		return err
+	})
+	return dgst, err
+}
+
+func (m *MultiClient) Size(ctx context.Context, dgst digest.Digest) (int64, error) {
+	var size int64
+	err := m.first(func(client Client) error {
+		var err error
+		size, err = client.Size(ctx, dgst)
+		return err
+	})
+	return size, err
+}
+
+func (m *MultiClient) GetManifest(ctx context.Context, dgst digest.Digest) ([]byte, string, error) {
+	var b []byte
+	var mediaType string
+	err := m.first(func(client Client) error {
+		var err error
+		b, mediaType, err = client.GetManifest(ctx, dgst)
+		return err
+	})
+	return b, mediaType, err
+}
+
+func (m *MultiClient) GetBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := m.first(func(client Client) error {
+		var err error
+		rc, err = client.GetBlob(ctx, dgst)
+		return err
+	})
+	return rc, err
+}
+
+func (m *MultiClient) ListReferrers(ctx context.Context, dgst digest.Digest) ([]ocispec.Descriptor, error) {
+	errs := []error{}
+	for _, client := range m.clients {
+		descs, err := client.ListReferrers(ctx, dgst)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(descs) == 0 {
+			continue
+		}
+		return descs, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// first calls the function with each client in priority order until it does not return an error.
+func (m *MultiClient) first(fn func(Client) error) error {
+	errs := []error{}
+	for _, client := range m.clients {
+		err := fn(client)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,54 @@
+package oci
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+type notFoundClient struct {
+	*MockClient
+}
+
+func (n *notFoundClient) Size(ctx context.Context, dgst digest.Digest) (int64, error) {
+	return 0, errors.New("not found")
+}
+
+func (n *notFoundClient) GetManifest(ctx context.Context, dgst digest.Digest) ([]byte, string, error) {
+	return nil, "", errors.New("not found")
+}
+
+func TestMultiClient(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewMultiClient()
+	require.EqualError(t, err, "multi client requires at least one client")
+
+	fooImg, err := Parse("docker.io/library/foo:latest", digest.Digest("sha256:01d4f4c4bb5c1d6b0fbe4e2e2b3d1a3cd3a2f3f8ad5c6d8c2d4e91a4cd9b1b2c"))
+	require.NoError(t, err)
+	barImg, err := Parse("docker.io/library/bar:latest", digest.Digest("sha256:d4fd8d6d3bb1b0e9cf43e6e6e8c6ea5b2d1d64d4e5f3f4f36be4fa6d6a3b5f8e"))
+	require.NoError(t, err)
+
+	first := &notFoundClient{MockClient: NewMockClient([]Image{fooImg})}
+	second := NewMockClient([]Image{fooImg, barImg})
+	m, err := NewMultiClient(first, second)
+	require.NoError(t, err)
+	require.Equal(t, "mock,mock", m.Name())
+
+	imgs, err := m.ListImages(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, []Image{fooImg, barImg}, imgs)
+
+	_, err = m.Size(context.TODO(), fooImg.Digest)
+	require.NoError(t, err)
+	_, _, err = m.GetManifest(context.TODO(), fooImg.Digest)
+	require.NoError(t, err)
+
+	m, err = NewMultiClient(first)
+	require.NoError(t, err)
+	_, err = m.Size(context.TODO(), fooImg.Digest)
+	require.EqualError(t, err, "not found")
+}
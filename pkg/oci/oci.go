@@ -5,6 +5,7 @@ import (
 	"io"
 
 	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type UnknownDocument struct {
@@ -17,8 +18,10 @@ type Client interface {
 	Subscribe(ctx context.Context) (<-chan ImageEvent, <-chan error, error)
 	ListImages(ctx context.Context) ([]Image, error)
 	AllIdentifiers(ctx context.Context, img Image) ([]string, error)
+	ManifestIdentifiers(ctx context.Context, img Image) ([]string, error)
 	Resolve(ctx context.Context, ref string) (digest.Digest, error)
 	Size(ctx context.Context, dgst digest.Digest) (int64, error)
 	GetManifest(ctx context.Context, dgst digest.Digest) ([]byte, string, error)
 	GetBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error)
+	ListReferrers(ctx context.Context, dgst digest.Digest) ([]ocispec.Descriptor, error)
 }
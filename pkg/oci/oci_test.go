@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
 
 	"github.com/containerd/containerd"
@@ -15,6 +16,7 @@ import (
 	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/metadata"
 	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/require"
@@ -83,19 +85,54 @@ func TestOCIClient(t *testing.T) {
 		contentPath: contentPath,
 		client:      containerdClient,
 	}
+	// Blobs missing from a misconfigured content path are read from the content store.
+	misconfiguredContainerd := &Containerd{
+		contentPath: t.TempDir(),
+		client:      containerdClient,
+	}
 
-	for _, ociClient := range []Client{remoteContainerd, localContainerd} {
+	// The same content is served from an OCI layout with the image names as annotations.
+	layoutPath := t.TempDir()
+	blobsPath, err := filepath.Abs("./testdata/blobs")
+	require.NoError(t, err)
+	err = os.Symlink(blobsPath, filepath.Join(layoutPath, "blobs"))
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(layoutPath, ocispec.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644)
+	require.NoError(t, err)
+	layoutIdx := ocispec.Index{}
+	for _, img := range imgs {
+		dgst, err := digest.Parse(img["digest"])
+		require.NoError(t, err)
+		layoutIdx.Manifests = append(layoutIdx.Manifests, ocispec.Descriptor{
+			MediaType:   img["mediaType"],
+			Digest:      dgst,
+			Size:        int64(len(blobs[dgst])),
+			Annotations: map[string]string{images.AnnotationImageName: img["name"]},
+		})
+	}
+	b, err = json.Marshal(layoutIdx)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(layoutPath, "index.json"), b, 0o644)
+	require.NoError(t, err)
+	ociLayout := NewOCILayout(layoutPath)
+	require.NoError(t, ociLayout.Verify(ctx))
+
+	for _, ociClient := range []Client{remoteContainerd, localContainerd, misconfiguredContainerd, ociLayout} {
 		t.Run(ociClient.Name(), func(t *testing.T) {
 			t.Parallel()
 
 			imgs, err := ociClient.ListImages(ctx)
 			require.NoError(t, err)
-			require.Len(t, imgs, 5)
+			require.Len(t, imgs, 6)
 			for _, img := range imgs {
 				_, err := ociClient.Resolve(ctx, img.Name)
 				require.NoError(t, err)
 			}
 
+			referrers, err := ociClient.ListReferrers(ctx, digest.Digest("sha256:9430beb291fa7b96997711fc486bc46133c719631aefdbeebe58dd3489217bfe"))
+			require.NoError(t, err)
+			require.Empty(t, referrers)
+
 			noPlatformName := "example.com/org/no-platform:test"
 			dgst, err := ociClient.Resolve(ctx, noPlatformName)
 			require.NoError(t, err)
@@ -121,6 +158,11 @@ func TestOCIClient(t *testing.T) {
 					dgst:      digest.Digest("sha256:aec8273a5e5aca369fcaa8cecef7bf6c7959d482f5c8cfa2236a6a16e46bbdcf"),
 					size:      476,
 				},
+				{
+					mediaType: ocispec.MediaTypeImageManifest,
+					dgst:      digest.Digest("sha256:43b8da9171806faadac75a40f457b4682446c358f0965351ee6d7493ed8063cf"),
+					size:      482,
+				},
 				{
 					mediaType: ocispec.MediaTypeImageConfig,
 					dgst:      digest.Digest("sha256:68b8a989a3e08ddbdb3a0077d35c0d0e59c9ecf23d0634584def8bdbb7d6824f"),
@@ -206,6 +248,15 @@ func TestOCIClient(t *testing.T) {
 						"sha256:4f4fb700ef54461cfa02571ae0db9a0dc1e0cdb5577484a6d75e68dc38e8acc1",
 					},
 				},
+				{
+					imageName:   "ghcr.io/spegel-org/helm-charts/spegel:v0.0.8",
+					imageDigest: "sha256:43b8da9171806faadac75a40f457b4682446c358f0965351ee6d7493ed8063cf",
+					expectedKeys: []string{
+						"sha256:43b8da9171806faadac75a40f457b4682446c358f0965351ee6d7493ed8063cf",
+						"sha256:2fdfa82e9217b10eccfa11b9a72be6ae20e1ec3340c4e2f967c0b711dd78b9c8",
+						"sha256:c0d2f6d6b1b62c80696bb72b13aa0f73582c05930a5ea2bcf166a06b0574423f",
+					},
+				},
 				{
 					imageName:   "ghcr.io/spegel-org/spegel:v0.0.8-without-media-type",
 					imageDigest: "sha256:d8df04365d06181f037251de953aca85cc16457581a8fc168f4957c978e1008b",
@@ -262,8 +313,46 @@ func TestOCIClient(t *testing.T) {
 					keys, err := ociClient.AllIdentifiers(ctx, img)
 					require.NoError(t, err)
 					require.Equal(t, tt.expectedKeys, keys)
+
+					manifestKeys, err := ociClient.ManifestIdentifiers(ctx, img)
+					require.NoError(t, err)
+					require.NotEmpty(t, manifestKeys)
+					require.Subset(t, keys, manifestKeys)
+					require.Equal(t, tt.imageDigest, manifestKeys[0])
 				})
 			}
 		})
 	}
+
+	// Images without local content for the platform only advertise the index.
+	platformContainerd := &Containerd{
+		client:          containerdClient,
+		platformMatcher: platforms.Only(ocispec.Platform{OS: "linux", Architecture: "arm64"}),
+	}
+	platformTests := []struct {
+		imageName    string
+		imageDigest  string
+		expectedKeys []string
+	}{
+		{
+			imageName:    "example.com/org/scratch:latest",
+			imageDigest:  "sha256:9430beb291fa7b96997711fc486bc46133c719631aefdbeebe58dd3489217bfe",
+			expectedKeys: []string{"sha256:9430beb291fa7b96997711fc486bc46133c719631aefdbeebe58dd3489217bfe"},
+		},
+		{
+			imageName:   "ghcr.io/spegel-org/spegel:v0.0.8",
+			imageDigest: "sha256:9506c8e7a2d0a098d43cadfd7ecdc3c91697e8188d3a1245943b669f717747b4",
+			expectedKeys: []string{
+				"sha256:9506c8e7a2d0a098d43cadfd7ecdc3c91697e8188d3a1245943b669f717747b4",
+				"sha256:dce623533c59af554b85f859e91fc1cbb7f574e873c82f36b9ea05a09feb0b53",
+			},
+		},
+	}
+	for _, tt := range platformTests {
+		img, err := Parse(tt.imageName, digest.Digest(tt.imageDigest))
+		require.NoError(t, err)
+		keys, err := platformContainerd.ManifestIdentifiers(ctx, img)
+		require.NoError(t, err, tt.imageName)
+		require.Equal(t, tt.expectedKeys, keys, tt.imageName)
+	}
 }
@@ -0,0 +1,386 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	"github.com/go-logr/logr"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var _ Client = &OCILayout{}
+
+// Interval at which the index of the layout is read to detect added and removed images.
+const ociLayoutPollInterval = 10 * time.Second
+
+// OCILayout is a read only client for content stored in an OCI image layout directory.
+// Images are listed from the index, which requires every manifest in the index to be annotated
+// with the full image name. Both the containerd and the OCI ref name annotations are accepted.
+// https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+type OCILayout struct {
+	path         string
+	pollInterval time.Duration
+}
+
+func NewOCILayout(path string) *OCILayout {
+	return &OCILayout{
+		path:         path,
+		pollInterval: ociLayoutPollInterval,
+	}
+}
+
+func (o *OCILayout) Name() string {
+	return "oci-layout"
+}
+
+func (o *OCILayout) Verify(ctx context.Context) error {
+	b, err := os.ReadFile(filepath.Join(o.path, ocispec.ImageLayoutFile))
+	if err != nil {
+		return fmt.Errorf("could not read OCI layout file: %w", err)
+	}
+	var layout ocispec.ImageLayout
+	if err := json.Unmarshal(b, &layout); err != nil {
+		return err
+	}
+	if layout.Version != ocispec.ImageLayoutVersion {
+		return fmt.Errorf("OCI layout version %s is not supported", layout.Version)
+	}
+	_, err = o.ListImages(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Subscribe polls the index of the layout, as content is only expected to change when the layout is seeded again.
+func (o *OCILayout) Subscribe(ctx context.Context) (<-chan ImageEvent, <-chan error, error) {
+	imgCh := make(chan ImageEvent)
+	errCh := make(chan error)
+	imgs, err := o.ListImages(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	current := map[string]Image{}
+	for _, img := range imgs {
+		current[img.Name] = img
+	}
+	go func() {
+		defer func() {
+			close(imgCh)
+			close(errCh)
+		}()
+		ticker := time.NewTicker(o.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			polled := time.Now()
+			imgs, err := o.ListImages(ctx)
+			if err != nil {
+				select {
+				case errCh <- err:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			events := []ImageEvent{}
+			next := map[string]Image{}
+			for _, img := range imgs {
+				next[img.Name] = img
+				prev, ok := current[img.Name]
+				if !ok {
+					events = append(events, ImageEvent{Image: img, Type: CreateEvent, Timestamp: polled})
+					continue
+				}
+				if prev.Digest != img.Digest {
+					events = append(events, ImageEvent{Image: img, Type: UpdateEvent, Timestamp: polled})
+				}
+			}
+			for name, img := range current {
+				if _, ok := next[name]; !ok {
+					events = append(events, ImageEvent{Image: img, Type: DeleteEvent, Timestamp: polled})
+				}
+			}
+			current = next
+			for _, event := range events {
+				select {
+				case imgCh <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return imgCh, errCh, nil
+}
+
+func (o *OCILayout) ListImages(ctx context.Context) ([]Image, error) {
+	idx, err := o.index()
+	if err != nil {
+		return nil, err
+	}
+	imgs := []Image{}
+	for _, desc := range idx.Manifests {
+		name, ok := desc.Annotations[images.AnnotationImageName]
+		if !ok {
+			name, ok = desc.Annotations[ocispec.AnnotationRefName]
+		}
+		if !ok {
+			continue
+		}
+		img, err := Parse(name, desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse image name of %s: %w", desc.Digest.String(), err)
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
+
+func (o *OCILayout) AllIdentifiers(ctx context.Context, img Image) ([]string, error) {
+	return o.identifiers(img, true)
+}
+
+// ManifestIdentifiers returns the digests of the image indexes and manifests, excluding config and layer blobs.
+func (o *OCILayout) ManifestIdentifiers(ctx context.Context, img Image) ([]string, error) {
+	return o.identifiers(img, false)
+}
+
+func (o *OCILayout) identifiers(img Image, includeBlobs bool) ([]string, error) {
+	idx, err := o.index()
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(idx.Manifests, func(desc ocispec.Descriptor) bool {
+		return desc.Digest == img.Digest
+	})
+	if i == -1 {
+		return nil, fmt.Errorf("image %s not found in OCI layout", img.Digest.String())
+	}
+	desc := idx.Manifests[i]
+	keys := []string{}
+	err = o.walk(desc, func(desc ocispec.Descriptor, b []byte) error {
+		keys = append(keys, desc.Digest.String())
+		if !includeBlobs {
+			return nil
+		}
+		switch desc.MediaType {
+		case images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest:
+			var manifest ocispec.Manifest
+			if err := json.Unmarshal(b, &manifest); err != nil {
+				return err
+			}
+			keys = append(keys, manifest.Config.Digest.String())
+			for _, layer := range manifest.Layers {
+				keys = append(keys, layer.Digest.String())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk image manifests: %w", err)
+	}
+	return keys, nil
+}
+
+func (o *OCILayout) Resolve(ctx context.Context, ref string) (digest.Digest, error) {
+	imgs, err := o.ListImages(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, img := range imgs {
+		if img.Name == ref {
+			return img.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("image %s not found in OCI layout", ref)
+}
+
+func (o *OCILayout) Size(ctx context.Context, dgst digest.Digest) (int64, error) {
+	path, err := o.blobPath(dgst)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (o *OCILayout) GetManifest(ctx context.Context, dgst digest.Digest) ([]byte, string, error) {
+	b, err := o.readBlob(dgst)
+	if err != nil {
+		return nil, "", err
+	}
+	var ud UnknownDocument
+	if err := json.Unmarshal(b, &ud); err != nil {
+		return nil, "", err
+	}
+	if ud.MediaType != "" {
+		return b, ud.MediaType, nil
+	}
+	var ic ocispec.Image
+	if err := json.Unmarshal(b, &ic); err != nil {
+		return nil, "", err
+	}
+	if isImageConfig(ic) {
+		return b, ocispec.MediaTypeImageConfig, nil
+	}
+	// Media type is not a required field, fall back to the descriptor referencing the content.
+	desc, err := o.descriptor(dgst)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not get media type for %s: %w", dgst.String(), err)
+	}
+	return b, desc.MediaType, nil
+}
+
+func (o *OCILayout) GetBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	path, err := o.blobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// ListReferrers returns descriptors for all manifests and indexes in the layout index whose subject is the given digest.
+// Manifests which can not be read or parsed are skipped.
+func (o *OCILayout) ListReferrers(ctx context.Context, dgst digest.Digest) ([]ocispec.Descriptor, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	idx, err := o.index()
+	if err != nil {
+		return nil, err
+	}
+	descs := []ocispec.Descriptor{}
+	seen := map[digest.Digest]interface{}{}
+	for _, target := range idx.Manifests {
+		if _, ok := seen[target.Digest]; ok {
+			continue
+		}
+		seen[target.Digest] = nil
+		if !isReferrerType(target.MediaType) {
+			continue
+		}
+		b, err := o.readBlob(target.Digest)
+		if err != nil {
+			log.Error(err, "skipping manifest when listing referrers", "digest", target.Digest.String())
+			continue
+		}
+		ref, err := parseReferrer(target, b)
+		if err != nil {
+			log.Error(err, "skipping manifest when listing referrers", "digest", target.Digest.String())
+			continue
+		}
+		if ref.subject != dgst {
+			continue
+		}
+		descs = append(descs, ref.desc)
+	}
+	return descs, nil
+}
+
+func (o *OCILayout) index() (ocispec.Index, error) {
+	b, err := os.ReadFile(filepath.Join(o.path, "index.json"))
+	if err != nil {
+		return ocispec.Index{}, fmt.Errorf("could not read OCI layout index: %w", err)
+	}
+	var idx ocispec.Index
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return ocispec.Index{}, err
+	}
+	return idx, nil
+}
+
+// descriptor returns the descriptor for the digest by walking all images in the index.
+func (o *OCILayout) descriptor(dgst digest.Digest) (ocispec.Descriptor, error) {
+	idx, err := o.index()
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	errFound := errors.New("found")
+	var found ocispec.Descriptor
+	for _, desc := range idx.Manifests {
+		err := o.walk(desc, func(desc ocispec.Descriptor, _ []byte) error {
+			if desc.Digest != dgst {
+				return nil
+			}
+			found = desc
+			return errFound
+		})
+		// Images which can not be walked may not contain the digest, so the remaining images are still searched.
+		if errors.Is(err, errFound) {
+			return found, nil
+		}
+	}
+	return ocispec.Descriptor{}, fmt.Errorf("descriptor for %s not found in OCI layout", dgst.String())
+}
+
+// walk calls the function with the content of every index and manifest reachable from the descriptor.
+// Manifests in an index which do not exist in the layout are skipped.
+func (o *OCILayout) walk(desc ocispec.Descriptor, fn func(ocispec.Descriptor, []byte) error) error {
+	b, err := o.readBlob(desc.Digest)
+	if err != nil {
+		return err
+	}
+	if err := fn(desc, b); err != nil {
+		return err
+	}
+	switch desc.MediaType {
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+		var idx ocispec.Index
+		if err := json.Unmarshal(b, &idx); err != nil {
+			return err
+		}
+		walked := 0
+		for _, m := range idx.Manifests {
+			path, err := o.blobPath(m.Digest)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			if err := o.walk(m, fn); err != nil {
+				return err
+			}
+			walked++
+		}
+		if walked == 0 {
+			return fmt.Errorf("could not find any platforms with local content in manifest list: %v", desc.Digest)
+		}
+		return nil
+	case images.MediaTypeDockerSchema2Manifest, ocispec.MediaTypeImageManifest:
+		return nil
+	default:
+		return fmt.Errorf("unexpected media type %v for digest: %v", desc.MediaType, desc.Digest)
+	}
+}
+
+func (o *OCILayout) readBlob(dgst digest.Digest) ([]byte, error) {
+	path, err := o.blobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// blobPath validates the digest before joining it to the path, as digests from requests are not validated.
+func (o *OCILayout) blobPath(dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	return filepath.Join(o.path, "blobs", dgst.Algorithm().String(), dgst.Encoded()), nil
+}
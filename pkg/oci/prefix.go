@@ -0,0 +1,68 @@
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RepositoryPrefix limits the repositories within a registry which are mirrored and advertised.
+// The prefix matches the repository and all repositories below it, so ghcr.io/myorg matches
+// ghcr.io/myorg/app but not ghcr.io/myorganization/app.
+type RepositoryPrefix struct {
+	Registry   string
+	Repository string
+}
+
+// ParseRepositoryPrefix parses a prefix formatted as registry/repository, for example ghcr.io/myorg.
+func ParseRepositoryPrefix(s string) (RepositoryPrefix, error) {
+	registry, repository, ok := strings.Cut(strings.TrimSuffix(s, "/"), "/")
+	if !ok || registry == "" || repository == "" {
+		return RepositoryPrefix{}, fmt.Errorf("repository prefix %q has to be formatted as registry/repository", s)
+	}
+	if strings.ContainsAny(repository, ":@") {
+		return RepositoryPrefix{}, errors.New("repository prefix can not contain a tag or digest")
+	}
+	return RepositoryPrefix{Registry: registry, Repository: repository}, nil
+}
+
+func (p *RepositoryPrefix) UnmarshalText(text []byte) error {
+	prefix, err := ParseRepositoryPrefix(string(text))
+	if err != nil {
+		return err
+	}
+	*p = prefix
+	return nil
+}
+
+func (p RepositoryPrefix) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p RepositoryPrefix) String() string {
+	return p.Registry + "/" + p.Repository
+}
+
+// Matches returns true if the repository within the registry is at or below the prefix.
+func (p RepositoryPrefix) Matches(registry, repository string) bool {
+	if p.Registry != registry {
+		return false
+	}
+	return repository == p.Repository || strings.HasPrefix(repository, p.Repository+"/")
+}
+
+// MatchRepositoryPrefixes returns true if the repository is allowed by the prefixes. Registries
+// without any configured prefix are matched at host granularity, allowing all repositories.
+func MatchRepositoryPrefixes(prefixes []RepositoryPrefix, registry, repository string) bool {
+	hasPrefix := false
+	for _, prefix := range prefixes {
+		if prefix.Registry != registry {
+			continue
+		}
+		if prefix.Matches(registry, repository) {
+			return true
+		}
+		hasPrefix = true
+	}
+	return !hasPrefix
+}
@@ -0,0 +1,50 @@
+package oci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRepositoryPrefix(t *testing.T) {
+	t.Parallel()
+
+	prefix, err := ParseRepositoryPrefix("ghcr.io/myorg/")
+	require.NoError(t, err)
+	require.Equal(t, RepositoryPrefix{Registry: "ghcr.io", Repository: "myorg"}, prefix)
+	require.Equal(t, "ghcr.io/myorg", prefix.String())
+
+	_, err = ParseRepositoryPrefix("ghcr.io")
+	require.EqualError(t, err, `repository prefix "ghcr.io" has to be formatted as registry/repository`)
+	_, err = ParseRepositoryPrefix("ghcr.io/myorg/app:latest")
+	require.EqualError(t, err, "repository prefix can not contain a tag or digest")
+}
+
+func TestMatchRepositoryPrefixes(t *testing.T) {
+	t.Parallel()
+
+	prefixes := []RepositoryPrefix{
+		{Registry: "ghcr.io", Repository: "myorg"},
+		{Registry: "ghcr.io", Repository: "other/app"},
+	}
+
+	tests := []struct {
+		registry   string
+		repository string
+		expected   bool
+	}{
+		{registry: "ghcr.io", repository: "myorg", expected: true},
+		{registry: "ghcr.io", repository: "myorg/app", expected: true},
+		{registry: "ghcr.io", repository: "other/app", expected: true},
+		{registry: "ghcr.io", repository: "myorganization/app", expected: false},
+		{registry: "ghcr.io", repository: "other/foo", expected: false},
+		{registry: "docker.io", repository: "library/nginx", expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.registry+"/"+tt.repository, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expected, MatchRepositoryPrefixes(prefixes, tt.registry, tt.repository))
+		})
+	}
+}
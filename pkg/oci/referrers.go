@@ -0,0 +1,46 @@
+package oci
+
+import (
+	"encoding/json"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// referrer is the referrer descriptor of a manifest or index together with the digest of its subject.
+// The subject is empty when the manifest or index does not refer to another manifest.
+type referrer struct {
+	subject digest.Digest
+	desc    ocispec.Descriptor
+}
+
+// isReferrerType returns true for media types which can have a subject.
+func isReferrerType(mediaType string) bool {
+	return mediaType == ocispec.MediaTypeImageManifest || mediaType == ocispec.MediaTypeImageIndex
+}
+
+// parseReferrer creates the referrer descriptor for the target from its content.
+func parseReferrer(target ocispec.Descriptor, b []byte) (referrer, error) {
+	// Manifests and indexes share the fields required to create a referrer descriptor.
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return referrer{}, err
+	}
+	if manifest.Subject == nil {
+		return referrer{}, nil
+	}
+	artifactType := manifest.ArtifactType
+	if artifactType == "" {
+		artifactType = manifest.Config.MediaType
+	}
+	return referrer{
+		subject: manifest.Subject.Digest,
+		desc: ocispec.Descriptor{
+			MediaType:    target.MediaType,
+			ArtifactType: artifactType,
+			Digest:       target.Digest,
+			Size:         int64(len(b)),
+			Annotations:  manifest.Annotations,
+		},
+	}, nil
+}
@@ -0,0 +1,131 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/spegel-org/spegel/internal/lru"
+)
+
+func TestListReferrers(t *testing.T) {
+	t.Parallel()
+
+	subject := digest.Digest("sha256:9430beb291fa7b96997711fc486bc46133c719631aefdbeebe58dd3489217bfe")
+	referrerB := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","artifactType":"application/vnd.example.sbom","config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[],"subject":{"mediaType":"application/vnd.oci.image.index.v1+json","digest":"sha256:9430beb291fa7b96997711fc486bc46133c719631aefdbeebe58dd3489217bfe","size":374},"annotations":{"foo":"bar"}}`)
+	otherB := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.example.config","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[]}`)
+	invalidB := []byte(`not a manifest`)
+	blobs := map[digest.Digest][]byte{
+		digest.FromBytes(referrerB): referrerB,
+		digest.FromBytes(otherB):    otherB,
+		digest.FromBytes(invalidB):  invalidB,
+	}
+	// Images which can not be read or parsed are skipped.
+	targets := map[string]ocispec.Descriptor{
+		"example.com/org/referrer:latest": {MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(referrerB), Size: int64(len(referrerB))},
+		"example.com/org/other:latest":    {MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(otherB), Size: int64(len(otherB))},
+		"example.com/org/invalid:latest":  {MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(invalidB), Size: int64(len(invalidB))},
+		"example.com/org/missing:latest":  {MediaType: ocispec.MediaTypeImageIndex, Digest: digest.FromString("missing"), Size: 7},
+	}
+
+	contentStore, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+	boltDB, err := bolt.Open(path.Join(t.TempDir(), "bolt.db"), 0o644, nil)
+	require.NoError(t, err)
+	db := metadata.NewDB(boltDB, contentStore, nil)
+	imageStore := metadata.NewImageStore(db)
+	ctx := namespaces.WithNamespace(context.TODO(), "k8s.io")
+	for name, target := range targets {
+		_, err := imageStore.Create(ctx, images.Image{Name: name, Target: target})
+		require.NoError(t, err)
+	}
+	for k, v := range blobs {
+		writer, err := contentStore.Writer(ctx, content.WithRef(k.String()))
+		require.NoError(t, err)
+		_, err = writer.Write(v)
+		require.NoError(t, err)
+		err = writer.Commit(ctx, int64(len(v)), k)
+		require.NoError(t, err)
+		writer.Close()
+	}
+	containerdClient, err := containerd.New("", containerd.WithServices(containerd.WithImageStore(imageStore), containerd.WithContentStore(contentStore)))
+	require.NoError(t, err)
+	containerdClientWithCache := &Containerd{
+		client:        containerdClient,
+		referrerCache: lru.New[digest.Digest, referrer](referrerCacheSize),
+	}
+
+	layoutPath := t.TempDir()
+	err = os.MkdirAll(filepath.Join(layoutPath, "blobs", "sha256"), 0o755)
+	require.NoError(t, err)
+	for k, v := range blobs {
+		err := os.WriteFile(filepath.Join(layoutPath, "blobs", "sha256", k.Encoded()), v, 0o644)
+		require.NoError(t, err)
+	}
+	layoutIdx := ocispec.Index{}
+	for name, target := range targets {
+		target.Annotations = map[string]string{images.AnnotationImageName: name}
+		layoutIdx.Manifests = append(layoutIdx.Manifests, target)
+	}
+	b, err := json.Marshal(layoutIdx)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(layoutPath, "index.json"), b, 0o644)
+	require.NoError(t, err)
+
+	expected := []ocispec.Descriptor{
+		{
+			MediaType:    ocispec.MediaTypeImageManifest,
+			ArtifactType: "application/vnd.example.sbom",
+			Digest:       digest.FromBytes(referrerB),
+			Size:         int64(len(referrerB)),
+			Annotations:  map[string]string{"foo": "bar"},
+		},
+	}
+	tests := []struct {
+		name      string
+		ociClient Client
+	}{
+		{
+			name:      "containerd",
+			ociClient: &Containerd{client: containerdClient},
+		},
+		{
+			name:      "containerd with cache",
+			ociClient: containerdClientWithCache,
+		},
+		{
+			name:      "oci-layout",
+			ociClient: NewOCILayout(layoutPath),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Listing twice returns the same result when parsed images are cached.
+			for range 2 {
+				descs, err := tt.ociClient.ListReferrers(ctx, subject)
+				require.NoError(t, err)
+				require.Equal(t, expected, descs)
+			}
+
+			descs, err := tt.ociClient.ListReferrers(ctx, digest.FromString("unknown"))
+			require.NoError(t, err)
+			require.Empty(t, descs)
+		})
+	}
+}
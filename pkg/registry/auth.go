@@ -0,0 +1,271 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ http.RoundTripper = &upstreamAuthTransport{}
+
+var (
+	authParamRegex  = regexp.MustCompile(`([a-zA-Z]+)="([^"]*)"`)
+	repositoryRegex = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs|referrers)/`)
+)
+
+// Token lifetime used when the token response does not include one, as defined by the token specification.
+const defaultTokenTTL = 60 * time.Second
+
+// Credentials are the basic auth credentials used to authenticate with an upstream registry.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// LoadDockerConfig reads the registry credentials from a Docker config.json file, as used by image pull secrets.
+// Credentials are keyed by the registry host, with Docker Hub keys normalized to the host serving its content.
+func LoadDockerConfig(path string) (map[string]Credentials, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse Docker config: %w", err)
+	}
+	creds := map[string]Credentials{}
+	for key, auth := range cfg.Auths {
+		c := Credentials{
+			Username: auth.Username,
+			Password: auth.Password,
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("could not decode auth for %s: %w", key, err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("auth for %s is not formatted as username:password", key)
+			}
+			c = Credentials{
+				Username: username,
+				Password: password,
+			}
+		}
+		creds[credentialsHost(key)] = c
+	}
+	return creds, nil
+}
+
+// credentialsHost returns the host of a Docker config key, which may be a URL.
+func credentialsHost(key string) string {
+	host := key
+	if u, err := url.Parse(key); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "docker.io", "index.docker.io":
+		return "registry-1.docker.io"
+	default:
+		return host
+	}
+}
+
+type cachedAuthorization struct {
+	expires       time.Time
+	authorization string
+}
+
+// upstreamAuthTransport answers authentication challenges from upstream registries with the configured credentials.
+// Requests which already contain credentials from the client are sent as is. Authorizations are cached per repository,
+// so that following requests are sent with credentials without first being challenged.
+type upstreamAuthTransport struct {
+	base        http.RoundTripper
+	credentials map[string]Credentials
+	cache       map[string]cachedAuthorization
+	mx          sync.Mutex
+}
+
+func newUpstreamAuthTransport(base http.RoundTripper, credentials map[string]Credentials) *upstreamAuthTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &upstreamAuthTransport{
+		base:        base,
+		credentials: credentials,
+		cache:       map[string]cachedAuthorization{},
+	}
+}
+
+func (u *upstreamAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds, ok := u.credentials[req.URL.Host]
+	if !ok || req.Header.Get("Authorization") != "" {
+		return u.base.RoundTrip(req)
+	}
+	key := authCacheKey(req)
+	firstReq := req
+	if authorization, ok := u.cachedAuthorization(key); ok {
+		firstReq = req.Clone(req.Context())
+		firstReq.Header.Set("Authorization", authorization)
+	}
+	resp, err := u.base.RoundTrip(firstReq)
+	if err != nil {
+		return nil, err
+	}
+	// Requests with a body can not be retried as it may already have been consumed.
+	if resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.Body != http.NoBody) {
+		return resp, nil
+	}
+	//nolint:errcheck // Body of the challenge response is not used.
+	resp.Body.Close()
+	authorization, ttl, err := u.authorize(req, resp.Header.Get("WWW-Authenticate"), creds)
+	if err != nil {
+		return nil, err
+	}
+	u.cacheAuthorization(key, authorization, ttl)
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set("Authorization", authorization)
+	return u.base.RoundTrip(authReq)
+}
+
+// authCacheKey returns the key authorizations for the request are cached with. Tokens are scoped to a repository,
+// so the key is the host and repository. An empty key is returned for requests which are not for a repository.
+func authCacheKey(req *http.Request) string {
+	match := repositoryRegex.FindStringSubmatch(req.URL.Path)
+	if match == nil {
+		return ""
+	}
+	return req.URL.Host + "/" + match[1]
+}
+
+func (u *upstreamAuthTransport) cachedAuthorization(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	cached, ok := u.cache[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(cached.expires) {
+		delete(u.cache, key)
+		return "", false
+	}
+	return cached.authorization, true
+}
+
+func (u *upstreamAuthTransport) cacheAuthorization(key, authorization string, ttl time.Duration) {
+	if key == "" {
+		return
+	}
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	u.cache[key] = cachedAuthorization{
+		authorization: authorization,
+		expires:       time.Now().Add(ttl),
+	}
+}
+
+// authorize returns the Authorization header value which answers the challenge, and how long it is valid for.
+func (u *upstreamAuthTransport) authorize(req *http.Request, challenge string, creds Credentials) (string, time.Duration, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.Username+":"+creds.Password)), defaultTokenTTL, nil
+	case "bearer":
+		token, ttl, err := u.fetchToken(req, params, creds)
+		if err != nil {
+			return "", 0, err
+		}
+		return "Bearer " + token, ttl, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported authentication challenge %q from %s", scheme, req.URL.Host)
+	}
+}
+
+// fetchToken requests a bearer token from the realm of the challenge using basic auth, returning the token and its lifetime.
+// Realms which are not served over https are refused, as the credentials would be sent in cleartext.
+// https://distribution.github.io/distribution/spec/auth/token/
+func (u *upstreamAuthTransport) fetchToken(req *http.Request, params string, creds Credentials) (string, time.Duration, error) {
+	challenge := map[string]string{}
+	for _, match := range authParamRegex.FindAllStringSubmatch(params, -1) {
+		challenge[strings.ToLower(match[1])] = match[2]
+	}
+	realm, ok := challenge["realm"]
+	if !ok {
+		return "", 0, errors.New("bearer challenge is missing realm")
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", 0, err
+	}
+	if tokenURL.Scheme != "https" {
+		return "", 0, fmt.Errorf("refusing to send credentials to token realm %s which does not use https", realm)
+	}
+	query := tokenURL.Query()
+	for _, k := range []string{"service", "scope"} {
+		if v, ok := challenge[k]; ok {
+			query.Set(k, v)
+		}
+	}
+	tokenURL.RawQuery = query.Encode()
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	tokenReq.SetBasicAuth(creds.Username, creds.Password)
+	if ua := req.Header.Get("User-Agent"); ua != "" {
+		tokenReq.Header.Set("User-Agent", ua)
+	}
+	resp, err := u.base.RoundTrip(tokenReq)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token request to %s failed with status %s", tokenURL.Host, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.Unmarshal(b, &tokenResp)
+	if err != nil {
+		return "", 0, err
+	}
+	ttl := defaultTokenTTL
+	if tokenResp.ExpiresIn > 0 {
+		ttl = time.Duration(tokenResp.ExpiresIn) * time.Second
+	}
+	if tokenResp.Token != "" {
+		return tokenResp.Token, ttl, nil
+	}
+	if tokenResp.AccessToken != "" {
+		return tokenResp.AccessToken, ttl, nil
+	}
+	return "", 0, errors.New("token response does not contain a token")
+}
@@ -0,0 +1,237 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spegel-org/spegel/internal/mux"
+	"github.com/spegel-org/spegel/pkg/routing"
+)
+
+func TestLoadDockerConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg := `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNz"},
+    "ghcr.io": {"username": "foo", "password": "bar"},
+    "example.com:5000": {"auth": "Zm9vOmJhcjpiYXo="}
+  }
+}`
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(cfg), 0o600)
+	require.NoError(t, err)
+	creds, err := LoadDockerConfig(path)
+	require.NoError(t, err)
+	expected := map[string]Credentials{
+		"registry-1.docker.io": {Username: "user", Password: "pass"},
+		"ghcr.io":              {Username: "foo", Password: "bar"},
+		"example.com:5000":     {Username: "foo", Password: "bar:baz"},
+	}
+	require.Equal(t, expected, creds)
+
+	err = os.WriteFile(path, []byte(`{"auths":{"ghcr.io":{"auth":"Zm9v"}}}`), 0o600)
+	require.NoError(t, err)
+	_, err = LoadDockerConfig(path)
+	require.EqualError(t, err, "auth for ghcr.io is not formatted as username:password")
+}
+
+func TestUpstreamAuthTransport(t *testing.T) {
+	t.Parallel()
+
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			username, password, ok := r.BasicAuth()
+			if !ok || username != "user" || password != "pass" || r.URL.Query().Get("scope") != "repository:foo:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			//nolint:errcheck // ignore
+			w.Write([]byte(`{"token":"secret","expires_in":300}`))
+		case strings.HasPrefix(r.URL.Path, "/basic"):
+			username, password, ok := r.BasicAuth()
+			if !ok || username != "user" || password != "pass" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		default:
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:foo:pull"`, srv.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name           string
+		path           string
+		host           string
+		expectedStatus int
+	}{
+		{
+			name:           "bearer challenge",
+			path:           "/v2/foo/manifests/latest",
+			host:           srv.Listener.Addr().String(),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "basic challenge",
+			path:           "/basic",
+			host:           srv.Listener.Addr().String(),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "no credentials for host",
+			path:           "/v2/foo/manifests/latest",
+			host:           "example.com",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			transport := newUpstreamAuthTransport(srv.Client().Transport, map[string]Credentials{tt.host: {Username: "user", Password: "pass"}})
+			req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			require.NoError(t, err)
+			resp, err := transport.RoundTrip(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestUpstreamAuthTransportCache(t *testing.T) {
+	t.Parallel()
+
+	var srv *httptest.Server
+	challenges := atomic.Int32{}
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			//nolint:errcheck // ignore
+			w.Write([]byte(`{"token":"secret"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			challenges.Add(1)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:foo:pull"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	transport := newUpstreamAuthTransport(srv.Client().Transport, map[string]Credentials{srv.Listener.Addr().String(): {Username: "user", Password: "pass"}})
+	for _, p := range []string{"/v2/foo/manifests/latest", "/v2/foo/blobs/sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+p, nil)
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	// The token is reused for the same repository without being challenged again.
+	require.Equal(t, int32(1), challenges.Load())
+}
+
+func TestUpstreamAuthTransportInsecureRealm(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://example.com/token",service="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(srv.Close)
+
+	transport := newUpstreamAuthTransport(nil, map[string]Credentials{srv.Listener.Addr().String(): {Username: "user", Password: "pass"}})
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v2/foo/manifests/latest", nil)
+	require.NoError(t, err)
+	//nolint:bodyclose // No response is returned on error.
+	_, err = transport.RoundTrip(req)
+	require.EqualError(t, err, "refusing to send credentials to token realm http://example.com/token which does not use https")
+}
+
+func TestUpstreamCredentialsLocalOnly(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user" || password != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	upstreamHost := upstream.Listener.Addr().String()
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{})
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		unixSocket     bool
+		expectedStatus int
+	}{
+		{
+			name:           "loopback request",
+			remoteAddr:     "127.0.0.1:51234",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unix socket request",
+			remoteAddr:     "@",
+			unixSocket:     true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "spoofed host from external address",
+			remoteAddr:     "10.0.0.1:51234",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reg := NewRegistry(
+				nil,
+				router,
+				WithTransport(upstream.Client().Transport),
+				WithLocalAddress("example.com"),
+				WithUpstreamFallback([]url.URL{{Scheme: "https", Host: upstreamHost}}),
+				WithUpstreamCredentials(map[string]Credentials{upstreamHost: {Username: "user", Password: "pass"}}),
+			)
+			m, err := mux.NewServeMux(reg.handle)
+			require.NoError(t, err)
+			rw := httptest.NewRecorder()
+			// The Host header matches the local address for all requests.
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/blobs/sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9?ns="+upstreamHost, nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.unixSocket {
+				req = req.WithContext(context.WithValue(req.Context(), localConnKey{}, true))
+			}
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+}
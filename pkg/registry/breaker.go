@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/spegel-org/spegel/pkg/metrics"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+type peerBreaker struct {
+	openedAt time.Time
+	failedAt time.Time
+	failures int
+	state    breakerState
+}
+
+// circuitBreaker tracks consecutive failures per peer. A peer is skipped once the failure threshold
+// is reached until the cooldown has passed, after which a single probe request is allowed through.
+// Peers are forgotten when they succeed or have not failed within the cooldown, so that peers which
+// leave the cluster are not tracked forever.
+type circuitBreaker struct {
+	pruned    time.Time
+	now       func() time.Time
+	peers     map[netip.AddrPort]*peerBreaker
+	threshold int
+	cooldown  time.Duration
+	mx        sync.Mutex
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		now:       time.Now,
+		peers:     map[netip.AddrPort]*peerBreaker{},
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow returns true if a request should be sent to the peer.
+func (c *circuitBreaker) allow(peer netip.AddrPort) bool {
+	if c == nil {
+		return true
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	pb, ok := c.peers[peer]
+	if !ok {
+		return true
+	}
+	if pb.state == breakerClosed {
+		return true
+	}
+	// Only a single probe request is allowed per cooldown. Probes which never report a
+	// result will be retried once the cooldown has passed again.
+	if c.now().Sub(pb.openedAt) < c.cooldown {
+		return false
+	}
+	pb.openedAt = c.now()
+	c.setState(peer, pb, breakerHalfOpen)
+	return true
+}
+
+func (c *circuitBreaker) success(peer netip.AddrPort) {
+	if c == nil {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if _, ok := c.peers[peer]; !ok {
+		return
+	}
+	c.forget(peer)
+}
+
+func (c *circuitBreaker) failure(peer netip.AddrPort) {
+	if c == nil {
+		return
+	}
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.prune()
+	pb, ok := c.peers[peer]
+	if !ok {
+		pb = &peerBreaker{}
+		c.peers[peer] = pb
+	}
+	pb.failedAt = c.now()
+	pb.failures++
+	if pb.state == breakerHalfOpen || pb.failures >= c.threshold {
+		pb.openedAt = c.now()
+		c.setState(peer, pb, breakerOpen)
+	}
+}
+
+// prune forgets peers which have not failed or been probed within the cooldown. Peers are scanned at most
+// once per cooldown. The lock has to be held by the caller.
+func (c *circuitBreaker) prune() {
+	now := c.now()
+	if now.Sub(c.pruned) < c.cooldown {
+		return
+	}
+	c.pruned = now
+	for peer, pb := range c.peers {
+		if now.Sub(pb.failedAt) < c.cooldown || now.Sub(pb.openedAt) < c.cooldown {
+			continue
+		}
+		c.forget(peer)
+	}
+}
+
+// forget stops tracking the peer and removes its state metric. The lock has to be held by the caller.
+func (c *circuitBreaker) forget(peer netip.AddrPort) {
+	delete(c.peers, peer)
+	metrics.MirrorPeerBreakerState.DeleteLabelValues(peer.String())
+}
+
+func (c *circuitBreaker) setState(peer netip.AddrPort, pb *peerBreaker, state breakerState) {
+	pb.state = state
+	metrics.MirrorPeerBreakerState.WithLabelValues(peer.String()).Set(float64(state))
+}
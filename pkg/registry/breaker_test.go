@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	c := newCircuitBreaker(2, time.Minute)
+	c.now = func() time.Time {
+		return now
+	}
+	peer := netip.MustParseAddrPort("127.0.0.1:5000")
+	other := netip.MustParseAddrPort("127.0.0.1:5001")
+
+	require.True(t, c.allow(peer))
+	c.failure(peer)
+	require.True(t, c.allow(peer))
+	c.failure(peer)
+	require.False(t, c.allow(peer))
+	require.True(t, c.allow(other))
+
+	// Single probe is allowed after cooldown.
+	now = now.Add(time.Minute)
+	require.True(t, c.allow(peer))
+	require.False(t, c.allow(peer))
+
+	// Failed probe opens the breaker again.
+	c.failure(peer)
+	require.False(t, c.allow(peer))
+
+	// Successful probe closes the breaker.
+	now = now.Add(time.Minute)
+	require.True(t, c.allow(peer))
+	c.success(peer)
+	require.True(t, c.allow(peer))
+	require.True(t, c.allow(peer))
+	require.NotContains(t, c.peers, peer)
+
+	// Peers which have not failed within the cooldown are forgotten.
+	c.failure(other)
+	c.failure(other)
+	require.False(t, c.allow(other))
+	now = now.Add(2 * time.Minute)
+	c.failure(peer)
+	require.NotContains(t, c.peers, other)
+	require.Contains(t, c.peers, peer)
+
+	var nilBreaker *circuitBreaker
+	require.True(t, nilBreaker.allow(peer))
+}
@@ -0,0 +1,47 @@
+package registry
+
+import (
+	"sync"
+)
+
+// Amount of tokens deposited for each successful mirror request, allowing one retry per ten successes.
+const retryBudgetRatio = 0.1
+
+// retryBudget limits the amount of mirror retries across all requests. Retries withdraw a token while
+// successful requests deposit a fraction of a token. When success rates collapse, for example when no
+// node has the content, the budget is exhausted and requests stop retrying peers.
+type retryBudget struct {
+	tokens    float64
+	maxTokens float64
+	mx        sync.Mutex
+}
+
+func newRetryBudget(maxTokens int) *retryBudget {
+	return &retryBudget{
+		tokens:    float64(maxTokens),
+		maxTokens: float64(maxTokens),
+	}
+}
+
+// withdraw returns true if a retry is allowed and consumes a token.
+func (b *retryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *retryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.tokens = min(b.tokens+retryBudgetRatio, b.maxTokens)
+}
@@ -0,0 +1,35 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryBudget(t *testing.T) {
+	t.Parallel()
+
+	b := newRetryBudget(2)
+	require.True(t, b.withdraw())
+	require.True(t, b.withdraw())
+	require.False(t, b.withdraw())
+
+	// Ten successes fund a single retry.
+	for range 10 {
+		b.deposit()
+	}
+	require.True(t, b.withdraw())
+	require.False(t, b.withdraw())
+
+	// Tokens never exceed the budget size.
+	for range 100 {
+		b.deposit()
+	}
+	require.True(t, b.withdraw())
+	require.True(t, b.withdraw())
+	require.False(t, b.withdraw())
+
+	var nilBudget *retryBudget
+	require.True(t, nilBudget.withdraw())
+	nilBudget.deposit()
+}
@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"net/http/httputil"
+	"sync"
+)
+
+var _ httputil.BufferPool = &bufferPool{}
+
+// bufferPool reuses fixed size buffers when copying response bodies.
+type bufferPool struct {
+	pool *sync.Pool
+	size int
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{
+		pool: &sync.Pool{
+			New: func() any {
+				b := make([]byte, size)
+				return &b
+			},
+		},
+		size: size,
+	}
+}
+
+func (b *bufferPool) Get() []byte {
+	buf, ok := b.pool.Get().(*[]byte)
+	if !ok {
+		return make([]byte, b.size)
+	}
+	return *buf
+}
+
+func (b *bufferPool) Put(buf []byte) {
+	// Buffers created with a different size should not be reused.
+	if len(buf) != b.size {
+		return
+	}
+	b.pool.Put(&buf)
+}
@@ -0,0 +1,18 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferPool(t *testing.T) {
+	t.Parallel()
+
+	pool := newBufferPool(1024)
+	buf := pool.Get()
+	require.Len(t, buf, 1024)
+	pool.Put(buf)
+	pool.Put(make([]byte, 10))
+	require.Len(t, pool.Get(), 1024)
+}
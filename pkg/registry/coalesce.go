@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+type resolveFunc func(ctx context.Context) (<-chan netip.AddrPort, error)
+
+type resolveCall struct {
+	peers []netip.AddrPort
+	subs  []chan netip.AddrPort
+}
+
+// resolveGroup coalesces concurrent resolves for the same key so that only a single lookup is done
+// in the router. Peers are fanned out to all subscribers, including peers received before subscribing.
+type resolveGroup struct {
+	calls map[string]*resolveCall
+	mx    sync.Mutex
+}
+
+func newResolveGroup() *resolveGroup {
+	return &resolveGroup{
+		calls: map[string]*resolveCall{},
+	}
+}
+
+// resolve returns a channel of peers for the key, and true if the resolve was shared with an in flight call.
+// The resolve is not cancelled with the context as it may outlive the request that started it, instead it
+// is limited by the timeout.
+func (g *resolveGroup) resolve(ctx context.Context, key string, timeout time.Duration, bufferSize int, fn resolveFunc) (<-chan netip.AddrPort, bool, error) {
+	sub := make(chan netip.AddrPort, bufferSize)
+
+	g.mx.Lock()
+	if call, ok := g.calls[key]; ok {
+		for _, peer := range call.peers {
+			// Don't block while holding the lock if the buffer of the subscriber is smaller than the replayed peers.
+			select {
+			case sub <- peer:
+			default:
+			}
+		}
+		call.subs = append(call.subs, sub)
+		g.mx.Unlock()
+		return sub, true, nil
+	}
+	callCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	peerCh, err := fn(callCtx)
+	if err != nil {
+		cancel()
+		g.mx.Unlock()
+		return nil, false, err
+	}
+	call := &resolveCall{
+		subs: []chan netip.AddrPort{sub},
+	}
+	g.calls[key] = call
+	g.mx.Unlock()
+
+	go func() {
+		defer cancel()
+		for peer := range peerCh {
+			g.mx.Lock()
+			// Subscribers can not receive more peers than fit in their buffer.
+			if len(call.peers) < bufferSize {
+				call.peers = append(call.peers, peer)
+			}
+			for _, s := range call.subs {
+				// Don't block if the subscriber is not reading from the channel.
+				select {
+				case s <- peer:
+				default:
+				}
+			}
+			g.mx.Unlock()
+		}
+		g.mx.Lock()
+		delete(g.calls, key)
+		for _, s := range call.subs {
+			close(s)
+		}
+		g.mx.Unlock()
+	}()
+	return sub, false, nil
+}
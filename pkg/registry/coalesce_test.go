@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveGroup(t *testing.T) {
+	t.Parallel()
+
+	g := newResolveGroup()
+	calls := 0
+	peerCh := make(chan netip.AddrPort)
+	fn := func(ctx context.Context) (<-chan netip.AddrPort, error) {
+		calls++
+		return peerCh, nil
+	}
+
+	first, shared, err := g.resolve(context.Background(), "foo", time.Second, 3, fn)
+	require.NoError(t, err)
+	require.False(t, shared)
+	peerCh <- netip.MustParseAddrPort("127.0.0.1:5000")
+
+	second, shared, err := g.resolve(context.Background(), "foo", time.Second, 3, fn)
+	require.NoError(t, err)
+	require.True(t, shared)
+	peerCh <- netip.MustParseAddrPort("127.0.0.1:5001")
+	close(peerCh)
+
+	expected := []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:5000"), netip.MustParseAddrPort("127.0.0.1:5001")}
+	for _, ch := range []<-chan netip.AddrPort{first, second} {
+		peers := []netip.AddrPort{}
+		for peer := range ch {
+			peers = append(peers, peer)
+		}
+		require.Equal(t, expected, peers)
+	}
+	require.Equal(t, 1, calls)
+
+	require.Eventually(t, func() bool {
+		g.mx.Lock()
+		defer g.mx.Unlock()
+		return len(g.calls) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestResolveGroupLateSubscriber(t *testing.T) {
+	t.Parallel()
+
+	g := newResolveGroup()
+	peerCh := make(chan netip.AddrPort)
+	fn := func(ctx context.Context) (<-chan netip.AddrPort, error) {
+		return peerCh, nil
+	}
+
+	first, _, err := g.resolve(context.Background(), "foo", time.Second, 2, fn)
+	require.NoError(t, err)
+	for i := range 5 {
+		peerCh <- netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(5000+i))
+	}
+
+	// Joining after more peers than the buffer size have arrived must not block.
+	done := make(chan struct{})
+	var second <-chan netip.AddrPort
+	go func() {
+		defer close(done)
+		second, _, err = g.resolve(context.Background(), "foo", time.Second, 2, fn)
+	}()
+	require.Eventually(t, func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, err)
+	close(peerCh)
+
+	expected := []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:5000"), netip.MustParseAddrPort("127.0.0.1:5001")}
+	for _, ch := range []<-chan netip.AddrPort{first, second} {
+		peers := []netip.AddrPort{}
+		for peer := range ch {
+			peers = append(peers, peer)
+		}
+		require.Equal(t, expected, peers)
+	}
+}
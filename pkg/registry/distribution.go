@@ -1,28 +1,65 @@
 package registry
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/opencontainers/go-digest"
+
+	"github.com/spegel-org/spegel/internal/mux"
 )
 
 type referenceKind string
 
 const (
-	referenceKindManifest = "Manifest"
-	referenceKindBlob     = "Blob"
+	referenceKindManifest  = "Manifest"
+	referenceKindBlob      = "Blob"
+	referenceKindReferrers = "Referrers"
 )
 
 type reference struct {
 	kind             referenceKind
 	name             string
+	repository       string
 	dgst             digest.Digest
 	originalRegistry string
 }
 
+// key returns the routing key for the reference, which is the digest when set and otherwise the name.
+func (r reference) key() string {
+	if r.dgst != "" {
+		return r.dgst.String()
+	}
+	return r.name
+}
+
+// registryLabel returns the original registry for use as a metric label. Requests without the registry
+// parameter are labeled as unknown, as the registry can not be derived from the path.
+func (r reference) registryLabel() string {
+	if r.originalRegistry == "" {
+		return "unknown"
+	}
+	return r.originalRegistry
+}
+
+// unknownErrorCode returns the error code used when the referenced content can not be found.
+func (r reference) unknownErrorCode() distributionErrorCode {
+	if r.kind == referenceKindBlob {
+		return errCodeBlobUnknown
+	}
+	return errCodeManifestUnknown
+}
+
+// hasTag returns true for manifests requested by tag instead of digest.
+func (r reference) hasTag() bool {
+	return r.kind == referenceKindManifest && r.name != "" && r.dgst == ""
+}
+
 func (r reference) hasLatestTag() bool {
 	if r.name == "" {
 		return false
@@ -35,6 +72,7 @@ func (r reference) hasLatestTag() bool {
 // https://github.com/opencontainers/distribution-spec/blob/main/spec.md
 // /v2/<name>/manifests/<reference>
 // /v2/<name>/blobs/<reference>
+// /v2/<name>/referrers/<digest>
 
 var (
 	nameRegex           = regexp.MustCompile(`([a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*)`)
@@ -42,6 +80,7 @@ var (
 	manifestRegexTag    = regexp.MustCompile(`/v2/` + nameRegex.String() + `/manifests/` + tagRegex.String() + `$`)
 	manifestRegexDigest = regexp.MustCompile(`/v2/` + nameRegex.String() + `/manifests/(.*)`)
 	blobsRegexDigest    = regexp.MustCompile(`/v2/` + nameRegex.String() + `/blobs/(.*)`)
+	referrersRegex      = regexp.MustCompile(`/v2/` + nameRegex.String() + `/referrers/(.*)`)
 )
 
 func parsePathComponents(originalRegistry, path string) (reference, error) {
@@ -54,6 +93,7 @@ func parsePathComponents(originalRegistry, path string) (reference, error) {
 		ref := reference{
 			kind:             referenceKindManifest,
 			name:             name,
+			repository:       comps[1],
 			originalRegistry: originalRegistry,
 		}
 		return ref, nil
@@ -63,6 +103,7 @@ func parsePathComponents(originalRegistry, path string) (reference, error) {
 		ref := reference{
 			kind:             referenceKindManifest,
 			dgst:             digest.Digest(comps[5]),
+			repository:       comps[1],
 			originalRegistry: originalRegistry,
 		}
 		return ref, nil
@@ -72,9 +113,62 @@ func parsePathComponents(originalRegistry, path string) (reference, error) {
 		ref := reference{
 			kind:             referenceKindBlob,
 			dgst:             digest.Digest(comps[5]),
+			repository:       comps[1],
+			originalRegistry: originalRegistry,
+		}
+		return ref, nil
+	}
+	comps = referrersRegex.FindStringSubmatch(path)
+	if len(comps) == 6 {
+		ref := reference{
+			kind:             referenceKindReferrers,
+			dgst:             digest.Digest(comps[5]),
+			repository:       comps[1],
 			originalRegistry: originalRegistry,
 		}
 		return ref, nil
 	}
 	return reference{}, errors.New("distribution path could not be parsed")
 }
+
+// Error codes defined by the OCI distribution spec, with the addition of UNKNOWN for server errors.
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#error-codes
+type distributionErrorCode string
+
+const (
+	errCodeBlobUnknown     distributionErrorCode = "BLOB_UNKNOWN"
+	errCodeManifestUnknown distributionErrorCode = "MANIFEST_UNKNOWN"
+	errCodeNameUnknown     distributionErrorCode = "NAME_UNKNOWN"
+	errCodeUnsupported     distributionErrorCode = "UNSUPPORTED"
+	errCodeTooManyRequests distributionErrorCode = "TOOMANYREQUESTS"
+	errCodeUnknown         distributionErrorCode = "UNKNOWN"
+)
+
+type distributionError struct {
+	Code    distributionErrorCode `json:"code"`
+	Message string                `json:"message"`
+}
+
+type distributionErrors struct {
+	Errors []distributionError `json:"errors"`
+}
+
+// writeDistributionError responds with the status code and an error body in the format of the distribution spec.
+// Headers describing content which would have been served are removed. HEAD responses do not include the body.
+func writeDistributionError(rw mux.ResponseWriter, req *http.Request, statusCode int, code distributionErrorCode, err error) {
+	b, mErr := json.Marshal(distributionErrors{Errors: []distributionError{{Code: code, Message: err.Error()}}})
+	if mErr != nil {
+		rw.WriteError(http.StatusInternalServerError, errors.Join(err, mErr))
+		return
+	}
+	rw.Header().Del("Content-Encoding")
+	rw.Header().Del("Docker-Content-Digest")
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Length", strconv.FormatInt(int64(len(b)), 10))
+	rw.WriteError(statusCode, err)
+	if req.Method == http.MethodHead {
+		return
+	}
+	//nolint:errcheck // Nothing can be done if the error body can not be written.
+	rw.Write(b)
+}
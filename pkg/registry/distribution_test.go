@@ -1,10 +1,16 @@
 package registry
 
 import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/require"
+
+	"github.com/spegel-org/spegel/internal/mux"
 )
 
 func TestParsePathComponents(t *testing.T) {
@@ -15,6 +21,7 @@ func TestParsePathComponents(t *testing.T) {
 		registry        string
 		path            string
 		expectedName    string
+		expectedRepo    string
 		expectedDgst    digest.Digest
 		expectedRefKind referenceKind
 	}{
@@ -23,6 +30,7 @@ func TestParsePathComponents(t *testing.T) {
 			registry:        "example.com",
 			path:            "/v2/foo/bar/manifests/hello-world",
 			expectedName:    "example.com/foo/bar:hello-world",
+			expectedRepo:    "foo/bar",
 			expectedDgst:    "",
 			expectedRefKind: referenceKindManifest,
 		},
@@ -31,9 +39,19 @@ func TestParsePathComponents(t *testing.T) {
 			registry:        "docker.io",
 			path:            "/v2/library/nginx/blobs/sha256:295c7be079025306c4f1d65997fcf7adb411c88f139ad1d34b537164aa060369",
 			expectedName:    "",
+			expectedRepo:    "library/nginx",
 			expectedDgst:    digest.Digest("sha256:295c7be079025306c4f1d65997fcf7adb411c88f139ad1d34b537164aa060369"),
 			expectedRefKind: referenceKindBlob,
 		},
+		{
+			name:            "valid referrers digest",
+			registry:        "ghcr.io",
+			path:            "/v2/spegel-org/spegel/referrers/sha256:295c7be079025306c4f1d65997fcf7adb411c88f139ad1d34b537164aa060369",
+			expectedName:    "",
+			expectedRepo:    "spegel-org/spegel",
+			expectedDgst:    digest.Digest("sha256:295c7be079025306c4f1d65997fcf7adb411c88f139ad1d34b537164aa060369"),
+			expectedRefKind: referenceKindReferrers,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -42,6 +60,7 @@ func TestParsePathComponents(t *testing.T) {
 			ref, err := parsePathComponents(tt.registry, tt.path)
 			require.NoError(t, err)
 			require.Equal(t, tt.expectedName, ref.name)
+			require.Equal(t, tt.expectedRepo, ref.repository)
 			require.Equal(t, tt.expectedDgst, ref.dgst)
 			require.Equal(t, tt.expectedRefKind, ref.kind)
 		})
@@ -61,3 +80,44 @@ func TestParsePathComponentsMissingRegistry(t *testing.T) {
 	_, err := parsePathComponents("", "/v2/spegel-org/spegel/manifests/v0.0.1")
 	require.EqualError(t, err, "registry parameter needs to be set for tag references")
 }
+
+func TestReferenceRegistryLabel(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "docker.io", reference{originalRegistry: "docker.io"}.registryLabel())
+	require.Equal(t, "unknown", reference{}.registryLabel())
+}
+
+func TestWriteDistributionError(t *testing.T) {
+	t.Parallel()
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		t.Run(method, func(t *testing.T) {
+			t.Parallel()
+
+			m, err := mux.NewServeMux(func(rw mux.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Length", "1024")
+				rw.Header().Set("Docker-Content-Digest", "sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9")
+				writeDistributionError(rw, req, http.StatusNotFound, errCodeBlobUnknown, errors.New("blob not found"))
+			})
+			require.NoError(t, err)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(method, "http://example.com/v2/foo/bar/blobs/sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", nil)
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusNotFound, resp.StatusCode)
+			require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			require.Equal(t, "63", resp.Header.Get("Content-Length"))
+			require.Empty(t, resp.Header.Get("Docker-Content-Digest"))
+			if method == http.MethodHead {
+				require.Empty(t, b)
+				return
+			}
+			require.JSONEq(t, `{"errors":[{"code":"BLOB_UNKNOWN","message":"blob not found"}]}`, string(b))
+		})
+	}
+}
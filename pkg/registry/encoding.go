@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spegel-org/spegel/internal/lru"
+)
+
+const (
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
+)
+
+type encodingCache struct {
+	cache *lru.Cache[string, []byte]
+}
+
+func newEncodingCache(size int) *encodingCache {
+	return &encodingCache{cache: lru.New[string, []byte](int64(size))}
+}
+
+// encode returns the content compressed with the encoding. Compressed content is cached by digest and encoding.
+func (e *encodingCache) encode(dgst digest.Digest, b []byte, encoding string) ([]byte, error) {
+	key := fmt.Sprintf("%s+%s", dgst.String(), encoding)
+	if cached, ok := e.cache.Get(key); ok {
+		return cached, nil
+	}
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch encoding {
+	case encodingGzip:
+		w = gzip.NewWriter(buf)
+	case encodingZstd:
+		zw, err := zstd.NewWriter(buf)
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", encoding)
+	}
+	_, err := w.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	e.cache.Add(key, buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// negotiateEncoding returns the preferred supported encoding from the Accept-Encoding header.
+// Zstd is preferred over gzip, an empty string is returned if neither is accepted.
+func negotiateEncoding(acceptEncoding string) string {
+	encoding := ""
+	for _, v := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(v, ";")
+		if !acceptable(params) {
+			continue
+		}
+		switch strings.TrimSpace(name) {
+		case encodingZstd:
+			return encodingZstd
+		case encodingGzip:
+			encoding = encodingGzip
+		}
+	}
+	return encoding
+}
+
+// acceptable returns false if the parameters of an Accept-Encoding value have a quality value of zero.
+func acceptable(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		k, v, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(k), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return false
+		}
+		return q > 0
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodingCache(t *testing.T) {
+	t.Parallel()
+
+	b := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	dgst := digest.FromBytes(b)
+	e := newEncodingCache(10)
+
+	gzipB, err := e.encode(dgst, b, encodingGzip)
+	require.NoError(t, err)
+	gr, err := gzip.NewReader(bytes.NewReader(gzipB))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	require.Equal(t, b, decoded)
+
+	zstdB, err := e.encode(dgst, b, encodingZstd)
+	require.NoError(t, err)
+	zr, err := zstd.NewReader(bytes.NewReader(zstdB))
+	require.NoError(t, err)
+	defer zr.Close()
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, b, decoded)
+
+	cachedB, err := e.encode(dgst, b, encodingGzip)
+	require.NoError(t, err)
+	require.Equal(t, gzipB, cachedB)
+	require.Equal(t, int64(2), e.cache.Size())
+
+	_, err = e.encode(dgst, b, "br")
+	require.EqualError(t, err, "unsupported encoding br")
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{
+			acceptEncoding: "",
+			expected:       "",
+		},
+		{
+			acceptEncoding: "gzip",
+			expected:       "gzip",
+		},
+		{
+			acceptEncoding: "gzip, zstd",
+			expected:       "zstd",
+		},
+		{
+			acceptEncoding: "deflate, br",
+			expected:       "",
+		},
+		{
+			acceptEncoding: "zstd;q=0, gzip;q=0.5",
+			expected:       "gzip",
+		},
+		{
+			acceptEncoding: "zstd; q=0.000, gzip",
+			expected:       "gzip",
+		},
+		{
+			acceptEncoding: "gzip;Q=0.0",
+			expected:       "",
+		},
+		{
+			acceptEncoding: "gzip;q=invalid",
+			expected:       "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			t.Parallel()
+
+			encoding := negotiateEncoding(tt.acceptEncoding)
+			require.Equal(t, tt.expected, encoding)
+		})
+	}
+}
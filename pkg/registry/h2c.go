@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Peers which failed to speak h2c are only retried after the duration to avoid paying for a failed attempt per request.
+const h2cFallbackDuration = 5 * time.Minute
+
+var _ http.RoundTripper = &h2cTransport{}
+
+// h2cTransport multiplexes requests to peers over a single HTTP/2 cleartext connection per peer.
+// Requests fall back to HTTP/1.1 for peers which do not support h2c.
+type h2cTransport struct {
+	h2          *http2.Transport
+	fallback    http.RoundTripper
+	unsupported map[string]time.Time
+	mx          sync.Mutex
+}
+
+func newH2CTransport(fallback http.RoundTripper) *h2cTransport {
+	if fallback == nil {
+		fallback = http.DefaultTransport
+	}
+	return &h2cTransport{
+		h2: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+		fallback:    fallback,
+		unsupported: map[string]time.Time{},
+	}
+}
+
+func (h *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" || !h.supported(req.URL.Host) {
+		return h.fallback.RoundTrip(req)
+	}
+	resp, err := h.h2.RoundTrip(req)
+	if err == nil {
+		return resp, nil
+	}
+	// Requests with a body can not be retried as it may already have been consumed.
+	if req.Body != nil && req.Body != http.NoBody {
+		return nil, err
+	}
+	// Dial and timeout errors are not caused by the peer lacking h2c support and would fail over HTTP/1.1 as well.
+	if !isH2CProtocolError(err) {
+		return nil, err
+	}
+	h.mx.Lock()
+	h.unsupported[req.URL.Host] = time.Now()
+	h.mx.Unlock()
+	return h.fallback.RoundTrip(req)
+}
+
+func (h *h2cTransport) supported(host string) bool {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	failedAt, ok := h.unsupported[host]
+	if !ok {
+		return true
+	}
+	if time.Since(failedAt) < h2cFallbackDuration {
+		return false
+	}
+	delete(h.unsupported, host)
+	return true
+}
+
+// isH2CProtocolError returns true when the error is caused by the peer not speaking HTTP/2. Peers which only speak
+// HTTP/1.x respond to the connection preface with an HTTP/1.x response, which is read as an oversized frame.
+func isH2CProtocolError(err error) bool {
+	var connErr http2.ConnectionError
+	if errors.As(err, &connErr) {
+		return true
+	}
+	if errors.Is(err, http2.ErrFrameTooLarge) {
+		return true
+	}
+	return strings.Contains(err.Error(), "HTTP/1.")
+}
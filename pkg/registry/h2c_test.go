@@ -0,0 +1,112 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestH2CTransport(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Proto", req.Proto)
+	})
+	h2cSrv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	t.Cleanup(h2cSrv.Close)
+	h1Srv := httptest.NewServer(handler)
+	t.Cleanup(h1Srv.Close)
+
+	transport := newH2CTransport(nil)
+	tests := []struct {
+		name          string
+		url           string
+		expectedProto string
+	}{
+		{
+			name:          "h2c peer",
+			url:           h2cSrv.URL,
+			expectedProto: "HTTP/2.0",
+		},
+		{
+			name:          "http/1.1 peer",
+			url:           h1Srv.URL,
+			expectedProto: "HTTP/1.1",
+		},
+		{
+			name:          "http/1.1 peer after fallback",
+			url:           h1Srv.URL,
+			expectedProto: "HTTP/1.1",
+		},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err, tt.name)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, tt.expectedProto, resp.Header.Get("X-Proto"), tt.name)
+	}
+	require.False(t, transport.supported(h1Srv.Listener.Addr().String()))
+	require.True(t, transport.supported(h2cSrv.Listener.Addr().String()))
+
+	// Dial errors are returned without falling back to HTTP/1.1.
+	closedSrv := httptest.NewServer(handler)
+	closedSrv.Close()
+	req, err := http.NewRequest(http.MethodGet, closedSrv.URL, nil)
+	require.NoError(t, err)
+	//nolint:bodyclose // No response is returned on error.
+	_, err = transport.RoundTrip(req)
+	require.Error(t, err)
+	require.True(t, transport.supported(closedSrv.Listener.Addr().String()))
+}
+
+func TestIsH2CProtocolError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "connection error",
+			err:      http2.ConnectionError(http2.ErrCodeProtocol),
+			expected: true,
+		},
+		{
+			name:     "frame too large",
+			err:      http2.ErrFrameTooLarge,
+			expected: true,
+		},
+		{
+			name:     "http/1.1 response",
+			err:      errors.New("http2: failed reading the frame payload: <nil>, note that the frame header looked like an HTTP/1.1 header"),
+			expected: true,
+		},
+		{
+			name:     "dial error",
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			expected: false,
+		},
+		{
+			name:     "timeout",
+			err:      context.DeadlineExceeded,
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expected, isH2CProtocolError(tt.err))
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spegel-org/spegel/internal/lru"
+)
+
+type manifestCacheEntry struct {
+	mediaType string
+	b         []byte
+}
+
+// manifestCache is a least recently used cache of manifest content bounded by the total size of the cached content.
+type manifestCache struct {
+	cache *lru.Cache[digest.Digest, manifestCacheEntry]
+}
+
+func newManifestCache(maxSize int64) *manifestCache {
+	sizeFn := func(entry manifestCacheEntry) int64 {
+		return int64(len(entry.b))
+	}
+	return &manifestCache{
+		cache: lru.New(maxSize, lru.WithSize[digest.Digest](sizeFn)),
+	}
+}
+
+func (m *manifestCache) get(dgst digest.Digest) ([]byte, string, bool) {
+	if m == nil {
+		return nil, "", false
+	}
+	entry, ok := m.cache.Get(dgst)
+	if !ok {
+		return nil, "", false
+	}
+	return entry.b, entry.mediaType, true
+}
+
+// add caches the manifest content, evicting the least recently used content until it fits.
+// Content larger than the max size is never cached.
+func (m *manifestCache) add(dgst digest.Digest, b []byte, mediaType string) {
+	if m == nil {
+		return
+	}
+	m.cache.Add(dgst, manifestCacheEntry{b: b, mediaType: mediaType})
+}
+
+func (m *manifestCache) purge() {
+	if m == nil {
+		return
+	}
+	m.cache.Purge()
+}
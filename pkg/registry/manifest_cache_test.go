@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestCache(t *testing.T) {
+	t.Parallel()
+
+	m := newManifestCache(10)
+
+	_, _, ok := m.get(digest.FromString("foo"))
+	require.False(t, ok)
+
+	m.add(digest.FromString("foo"), []byte("foo"), "foo-type")
+	m.add(digest.FromString("bar"), []byte("bar"), "bar-type")
+	b, mediaType, ok := m.get(digest.FromString("foo"))
+	require.True(t, ok)
+	require.Equal(t, []byte("foo"), b)
+	require.Equal(t, "foo-type", mediaType)
+	require.Equal(t, int64(6), m.cache.Size())
+
+	// Least recently used content is evicted first.
+	m.add(digest.FromString("hello"), []byte("hello"), "hello-type")
+	_, _, ok = m.get(digest.FromString("bar"))
+	require.False(t, ok)
+	_, _, ok = m.get(digest.FromString("foo"))
+	require.True(t, ok)
+	require.Equal(t, int64(8), m.cache.Size())
+
+	// Content larger than the max size is not cached.
+	m.add(digest.FromString("too large"), []byte("too large!!"), "large-type")
+	_, _, ok = m.get(digest.FromString("too large"))
+	require.False(t, ok)
+
+	m.purge()
+	_, _, ok = m.get(digest.FromString("foo"))
+	require.False(t, ok)
+	require.Zero(t, m.cache.Size())
+
+	var nilCache *manifestCache
+	nilCache.add(digest.FromString("foo"), []byte("foo"), "foo-type")
+	_, _, ok = nilCache.get(digest.FromString("foo"))
+	require.False(t, ok)
+	nilCache.purge()
+}
@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"math"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiters which have not been used within the idle duration are removed to bound memory usage.
+const rateLimiterIdle = 5 * time.Minute
+
+type clientLimiter struct {
+	lastSeen time.Time
+	limiter  *rate.Limiter
+}
+
+// clientRateLimiter applies a token bucket rate limit per client IP.
+type clientRateLimiter struct {
+	lastPrune time.Time
+	limiters  map[netip.Addr]*clientLimiter
+	exempt    []netip.Prefix
+	mx        sync.Mutex
+	limit     rate.Limit
+	burst     int
+}
+
+func newClientRateLimiter(perSecond float64, burst int, exempt []netip.Prefix) *clientRateLimiter {
+	return &clientRateLimiter{
+		lastPrune: time.Now(),
+		limiters:  map[netip.Addr]*clientLimiter{},
+		exempt:    exempt,
+		limit:     rate.Limit(perSecond),
+		burst:     burst,
+	}
+}
+
+// allow reports if a request from the client IP is allowed. Exempted clients are always allowed. Rejected
+// requests return the delay until the client is allowed to make a request again.
+func (c *clientRateLimiter) allow(addr netip.Addr) (bool, time.Duration) {
+	// Requests without a client IP, such as on a Unix domain socket, can only come from the local host.
+	if c == nil || !addr.IsValid() {
+		return true, 0
+	}
+	addr = addr.Unmap()
+	for _, prefix := range c.exempt {
+		if prefix.Contains(addr) {
+			return true, 0
+		}
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastPrune) > rateLimiterIdle {
+		for k, v := range c.limiters {
+			if now.Sub(v.lastSeen) > rateLimiterIdle {
+				delete(c.limiters, k)
+			}
+		}
+		c.lastPrune = now
+	}
+	cl, ok := c.limiters[addr]
+	if !ok {
+		cl = &clientLimiter{
+			limiter: rate.NewLimiter(c.limit, c.burst),
+		}
+		c.limiters[addr] = cl
+	}
+	cl.lastSeen = now
+	res := cl.limiter.ReserveN(now, 1)
+	if !res.OK() {
+		return false, rateLimiterIdle
+	}
+	delay := res.DelayFrom(now)
+	if delay == 0 {
+		return true, 0
+	}
+	// The token is only reserved to calculate the delay, the request is rejected.
+	res.CancelAt(now)
+	return false, delay
+}
+
+// retryAfterSeconds formats the delay as whole seconds for the Retry-After header, rounding up to at least a second.
+func retryAfterSeconds(delay time.Duration) string {
+	return strconv.FormatInt(max(1, int64(math.Ceil(delay.Seconds()))), 10)
+}
@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	c := newClientRateLimiter(0.001, 2, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+
+	allow := func(addr netip.Addr) bool {
+		t.Helper()
+
+		ok, delay := c.allow(addr)
+		if ok {
+			require.Zero(t, delay)
+		}
+		return ok
+	}
+
+	limited := netip.MustParseAddr("192.168.1.1")
+	require.True(t, allow(limited))
+	require.True(t, allow(limited))
+	require.False(t, allow(limited))
+	require.False(t, allow(netip.MustParseAddr("::ffff:192.168.1.1")))
+
+	// The delay is the time until the next token is available, which is unaffected by rejected requests.
+	_, delay := c.allow(limited)
+	require.InDelta(t, 1000*time.Second, delay, float64(time.Second))
+
+	require.True(t, allow(netip.MustParseAddr("192.168.1.2")))
+
+	exempt := netip.MustParseAddr("10.1.2.3")
+	for range 5 {
+		require.True(t, allow(exempt))
+	}
+	require.True(t, allow(netip.Addr{}))
+
+	var nilLimiter *clientRateLimiter
+	ok, _ := nilLimiter.allow(limited)
+	require.True(t, ok)
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "1", retryAfterSeconds(0))
+	require.Equal(t, "1", retryAfterSeconds(100*time.Millisecond))
+	require.Equal(t, "2", retryAfterSeconds(1500*time.Millisecond))
+	require.Equal(t, "1000", retryAfterSeconds(1000*time.Second))
+}
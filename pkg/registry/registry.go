@@ -2,19 +2,30 @@ package registry
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
+	"os"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"github.com/spegel-org/spegel/internal/mux"
 	"github.com/spegel-org/spegel/pkg/metrics"
@@ -25,18 +36,67 @@ import (
 
 const (
 	MirroredHeaderKey = "X-Spegel-Mirrored"
+	// ResolveRetriesHeaderKey overrides the amount of mirrors attempted for a single request.
+	ResolveRetriesHeaderKey = "X-Spegel-Resolve-Retries"
 )
 
+// Headers which are always forwarded to mirrors as they are required to serve the request.
+var requiredForwardHeaders = []string{"Accept", "Accept-Encoding", "Range", "If-None-Match", "User-Agent", MirroredHeaderKey}
+
+// Hop-by-hop headers are only valid for a single connection and should never be forwarded.
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// MirrorEvent describes the outcome of a single mirror request.
+type MirrorEvent struct {
+	Name     string `json:"name,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+	Registry string `json:"registry"`
+	Peer     string `json:"peer,omitempty"`
+	Outcome  string `json:"outcome"`
+	Bytes    int64  `json:"bytes"`
+}
+
 type Registry struct {
 	log              logr.Logger
 	throttler        *throttle.Throttler
+	blocklist        *routing.Blocklist
+	encodingCache    *encodingCache
+	manifestCache    *manifestCache
+	tagCache         *tagCache
+	bufferPool       *bufferPool
+	rateLimiter      *clientRateLimiter
+	resolveGroup     *resolveGroup
+	breaker          *circuitBreaker
+	retryBudget      *retryBudget
+	requestSem       chan struct{}
+	registryAliases  oci.RegistryAliases
 	ociClient        oci.Client
 	router           routing.Router
 	transport        http.RoundTripper
+	mirrorEvents     func(MirrorEvent)
+	localServes      func(string)
+	mirrorTransport  http.RoundTripper
+	authTransport    http.RoundTripper
+	upstreamCreds    map[string]Credentials
+	upstreamHosts    map[string]struct{}
 	localAddr        string
+	accessLogFields  []string
+	forwardHeaders   []string
+	repoPrefixes     []oci.RepositoryPrefix
+	userAgent        string
+	peerScheme       string
 	resolveRetries   int
+	maxHeaderRetries int
+	minReadyPeers    int
+	maxManifestSize  int64
+	maxBlobSize      int64
 	resolveTimeout   time.Duration
+	retryBackoff     time.Duration
+	draining         atomic.Bool
 	resolveLatestTag bool
+	skipBlobs        bool
+	blockMutableTags bool
+	h2c              bool
 }
 
 type Option func(*Registry)
@@ -47,6 +107,14 @@ func WithResolveRetries(resolveRetries int) Option {
 	}
 }
 
+// WithMaxResolveRetries allows requests to override the resolve retries with the X-Spegel-Resolve-Retries header,
+// clamped to the maximum. The header is ignored when the maximum is zero.
+func WithMaxResolveRetries(maxResolveRetries int) Option {
+	return func(r *Registry) {
+		r.maxHeaderRetries = maxResolveRetries
+	}
+}
+
 func WithResolveLatestTag(resolveLatestTag bool) Option {
 	return func(r *Registry) {
 		r.resolveLatestTag = resolveLatestTag
@@ -59,12 +127,43 @@ func WithResolveTimeout(resolveTimeout time.Duration) Option {
 	}
 }
 
+func WithRetryBackoff(base time.Duration) Option {
+	return func(r *Registry) {
+		r.retryBackoff = base
+	}
+}
+
+func WithMaxManifestSize(maxManifestSize int64) Option {
+	return func(r *Registry) {
+		r.maxManifestSize = maxManifestSize
+	}
+}
+
 func WithTransport(transport http.RoundTripper) Option {
 	return func(r *Registry) {
 		r.transport = transport
 	}
 }
 
+// WithH2C enables HTTP/2 cleartext between peers, multiplexing requests to a peer over a single connection.
+// Requests to peers which do not support h2c fall back to HTTP/1.1.
+func WithH2C(enabled bool) Option {
+	return func(r *Registry) {
+		r.h2c = enabled
+	}
+}
+
+// WithCopyBufferSize sets the size in bytes of the buffers used when copying content to clients.
+// Larger buffers reduce the amount of syscalls when transferring large blobs.
+func WithCopyBufferSize(size int) Option {
+	return func(r *Registry) {
+		if size <= 0 {
+			return
+		}
+		r.bufferPool = newBufferPool(size)
+	}
+}
+
 func WithLocalAddress(localAddr string) Option {
 	return func(r *Registry) {
 		r.localAddr = localAddr
@@ -77,6 +176,217 @@ func WithBlobSpeed(blobSpeed throttle.Byterate) Option {
 	}
 }
 
+// WithMaxMirrorBlobSize sets the largest blob which will be mirrored or served from this node. Requests for
+// larger blobs respond with not found so that they are pulled from upstream. As the limit is a per node policy
+// nodes with different limits may be mirrored blobs which are larger than their own limit. No limit is applied when zero.
+func WithMaxMirrorBlobSize(maxBlobSize int64) Option {
+	return func(r *Registry) {
+		r.maxBlobSize = maxBlobSize
+	}
+}
+
+// WithManifestCacheSize caches manifest content read from the store in memory up to the total size in bytes.
+// The cache is disabled when zero.
+func WithManifestCacheSize(size int64) Option {
+	return func(r *Registry) {
+		if size <= 0 {
+			r.manifestCache = nil
+			return
+		}
+		r.manifestCache = newManifestCache(size)
+	}
+}
+
+// WithPeerTagCacheTTL caches the digest a peer resolved a tag to when mirroring the manifest by tag.
+// Later requests for the tag are mirrored by the digest until the TTL expires. The cache is disabled when zero.
+func WithPeerTagCacheTTL(ttl time.Duration) Option {
+	return func(r *Registry) {
+		if ttl <= 0 {
+			r.tagCache = nil
+			return
+		}
+		r.tagCache = newTagCache(ttl)
+	}
+}
+
+// WithMinReadyPeers requires the router to be connected to at least the given amount of peers before reporting ready.
+func WithMinReadyPeers(n int) Option {
+	return func(r *Registry) {
+		r.minReadyPeers = n
+	}
+}
+
+// WithServeBlobs controls if blobs are served to other peers. When false only manifests are served,
+// while blobs are still mirrored from other peers.
+func WithServeBlobs(serveBlobs bool) Option {
+	return func(r *Registry) {
+		r.skipBlobs = !serveBlobs
+	}
+}
+
+// WithBlockMutableTags stops manifests requested by tag from being mirrored or resolved for peers, as a tag resolved
+// by another node may point to an older digest than the registry. Clients fall back to the registry for every tag,
+// trading protection against registry outages for always receiving the current digest. Requests by digest are not affected.
+func WithBlockMutableTags(blockMutableTags bool) Option {
+	return func(r *Registry) {
+		r.blockMutableTags = blockMutableTags
+	}
+}
+
+// WithUpstreamFallback enables fetching content from the original registry when no peer is able to serve it.
+// Only the given registries are fetched from, as the original registry is supplied by the client. Authentication
+// challenges from the registry are passed through to the client, which will retry with credentials, unless upstream
+// credentials are configured for the registry. Fallback is disabled when no registries are given.
+func WithUpstreamFallback(registries []url.URL) Option {
+	return func(r *Registry) {
+		r.upstreamHosts = map[string]struct{}{}
+		for _, registry := range registries {
+			r.upstreamHosts[registry.Host] = struct{}{}
+		}
+	}
+}
+
+// WithUpstreamCredentials sets the credentials used to answer authentication challenges from upstream registries,
+// keyed by registry host. Credentials are only used for upstream fallback requests from the local node without client
+// credentials, which are requests received on a Unix domain socket or from a loopback address.
+func WithUpstreamCredentials(creds map[string]Credentials) Option {
+	return func(r *Registry) {
+		r.upstreamCreds = creds
+	}
+}
+
+// WithPeerScheme sets the scheme used for requests to mirrors. When empty the scheme is https if the
+// request was received with TLS and http otherwise.
+func WithPeerScheme(scheme string) Option {
+	return func(r *Registry) {
+		r.peerScheme = scheme
+	}
+}
+
+// WithUserAgent overrides the User-Agent header of requests sent to mirrors and upstream registries.
+// The User-Agent of the client is forwarded when empty.
+func WithUserAgent(userAgent string) Option {
+	return func(r *Registry) {
+		r.userAgent = userAgent
+	}
+}
+
+// WithMirrorEventHandler calls the handler with the outcome of every mirror request. The handler is called on the
+// request path and must not block.
+func WithMirrorEventHandler(handler func(MirrorEvent)) Option {
+	return func(r *Registry) {
+		r.mirrorEvents = handler
+	}
+}
+
+// WithLocalServeHandler calls the handler with the tag and digest of every manifest served from local content. The
+// handler is called on the request path and must not block.
+func WithLocalServeHandler(handler func(key string)) Option {
+	return func(r *Registry) {
+		r.localServes = handler
+	}
+}
+
+// WithCircuitBreaker skips peers for the cooldown duration after the threshold of consecutive failures has been reached.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(r *Registry) {
+		if threshold <= 0 {
+			r.breaker = nil
+			return
+		}
+		r.breaker = newCircuitBreaker(threshold, cooldown)
+	}
+}
+
+// WithRetryBudget limits the amount of mirror retries across all requests to the budget size. Successful mirror
+// requests refill the budget, so retries stop when most mirror requests are failing.
+func WithRetryBudget(size int) Option {
+	return func(r *Registry) {
+		if size <= 0 {
+			r.retryBudget = nil
+			return
+		}
+		r.retryBudget = newRetryBudget(size)
+	}
+}
+
+// WithMaxConcurrentRequests limits the amount of registry requests handled at the same time.
+// Requests exceeding the limit are rejected with 429 Too Many Requests. No limit is applied when zero.
+func WithMaxConcurrentRequests(n int) Option {
+	return func(r *Registry) {
+		if n <= 0 {
+			r.requestSem = nil
+			return
+		}
+		r.requestSem = make(chan struct{}, n)
+	}
+}
+
+// WithRateLimit limits the rate of registry requests per client IP using a token bucket. Requests exceeding
+// the limit are rejected with 429 Too Many Requests. Clients within the exempt prefixes are never limited.
+// No limit is applied when zero.
+func WithRateLimit(perSecond float64, burst int, exempt []netip.Prefix) Option {
+	return func(r *Registry) {
+		if perSecond <= 0 {
+			r.rateLimiter = nil
+			return
+		}
+		r.rateLimiter = newClientRateLimiter(perSecond, burst, exempt)
+	}
+}
+
+// WithAccessLogFields enables a single access log line per registry request containing only the given fields.
+// Available fields are key, cache, peer, attempts, status, bytes, and duration.
+func WithAccessLogFields(fields []string) Option {
+	return func(r *Registry) {
+		r.accessLogFields = fields
+	}
+}
+
+// WithForwardHeaders restricts the incoming request headers forwarded to mirrors to the allowlist.
+// Headers required to serve the request are always forwarded, while hop-by-hop headers never are.
+// All headers are forwarded when the allowlist is empty.
+func WithForwardHeaders(headers []string) Option {
+	return func(r *Registry) {
+		r.forwardHeaders = nil
+		if len(headers) == 0 {
+			return
+		}
+		forwardHeaders := slices.Clone(requiredForwardHeaders)
+		for _, header := range headers {
+			header = http.CanonicalHeaderKey(header)
+			if slices.Contains(hopByHopHeaders, header) || slices.Contains(forwardHeaders, header) {
+				continue
+			}
+			forwardHeaders = append(forwardHeaders, header)
+		}
+		r.forwardHeaders = forwardHeaders
+	}
+}
+
+// WithRepositoryPrefixes only mirrors repositories within the prefixes for registries which have a prefix.
+// Requests for other repositories in these registries are not resolved and return not found.
+func WithRepositoryPrefixes(prefixes []oci.RepositoryPrefix) Option {
+	return func(r *Registry) {
+		r.repoPrefixes = prefixes
+	}
+}
+
+// WithRegistryAliases looks up tags requested through a registry alias with the canonical registry.
+// Local tags are resolved with every alias of the registry.
+func WithRegistryAliases(aliases oci.RegistryAliases) Option {
+	return func(r *Registry) {
+		r.registryAliases = aliases
+	}
+}
+
+// WithPeerBlocklist enables the endpoint used to list the peer blocklist. Updates are made through the admin endpoints.
+func WithPeerBlocklist(blocklist *routing.Blocklist) Option {
+	return func(r *Registry) {
+		r.blocklist = blocklist
+	}
+}
+
 func WithLogger(log logr.Logger) Option {
 	return func(r *Registry) {
 		r.log = log
@@ -87,28 +397,89 @@ func NewRegistry(ociClient oci.Client, router routing.Router, opts ...Option) *R
 	r := &Registry{
 		ociClient:        ociClient,
 		router:           router,
+		encodingCache:    newEncodingCache(128),
+		resolveGroup:     newResolveGroup(),
+		bufferPool:       newBufferPool(32 * 1024),
 		resolveRetries:   3,
+		maxManifestSize:  4 * 1024 * 1024,
 		resolveTimeout:   20 * time.Millisecond,
 		resolveLatestTag: true,
 	}
 	for _, opt := range opts {
 		opt(r)
 	}
+	r.mirrorTransport = r.transport
+	if r.h2c {
+		r.mirrorTransport = newH2CTransport(r.transport)
+	}
+	r.authTransport = r.transport
+	if len(r.upstreamCreds) > 0 {
+		r.authTransport = newUpstreamAuthTransport(r.transport, r.upstreamCreds)
+	}
 	return r
 }
 
+// PurgeManifestCache removes all cached manifest content. It should be called when images are deleted so that
+// content which has been removed from the store is no longer served.
+func (r *Registry) PurgeManifestCache() {
+	r.manifestCache.purge()
+}
+
 func (r *Registry) Server(addr string) (*http.Server, error) {
 	m, err := mux.NewServeMux(r.handle)
 	if err != nil {
 		return nil, err
 	}
+	var handler http.Handler = m
+	if r.h2c {
+		handler = h2c.NewHandler(m, &http2.Server{})
+	}
 	srv := &http.Server{
 		Addr:    addr,
-		Handler: m,
+		Handler: handler,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			// Connections over Unix domain sockets can only be made by clients on the node.
+			if c.LocalAddr().Network() == "unix" {
+				return context.WithValue(ctx, localConnKey{}, true)
+			}
+			return ctx
+		},
 	}
 	return srv, nil
 }
 
+// Listen creates a listener for the address. Addresses with the unix:// prefix will listen on a Unix domain socket
+// which is only accessible by the owner, otherwise a TCP listener is created.
+func Listen(addr string) (net.Listener, error) {
+	socketPath, ok := strings.CutPrefix(addr, "unix://")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	// Remove socket left behind by a previous process that did not shutdown gracefully.
+	fi, err := os.Lstat(socketPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case fi.Mode()&os.ModeSocket == 0:
+		return nil, fmt.Errorf("refusing to remove %s which is not a socket", socketPath)
+	default:
+		err = os.Remove(socketPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(socketPath, 0o600)
+	if err != nil {
+		return nil, errors.Join(err, l.Close())
+	}
+	return l, nil
+}
+
 func (r *Registry) handle(rw mux.ResponseWriter, req *http.Request) {
 	start := time.Now()
 	handler := ""
@@ -129,6 +500,10 @@ func (r *Registry) handle(rw mux.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		if len(r.accessLogFields) > 0 && len(rw.Attrs()) > 0 {
+			r.log.Info("access", r.accessLogKVs(rw, latency)...)
+		}
+
 		kvs := []interface{}{
 			"path", req.URL.Path,
 			"status", rw.Status(),
@@ -150,14 +525,138 @@ func (r *Registry) handle(rw mux.ResponseWriter, req *http.Request) {
 		handler = "ready"
 		return
 	}
+	if req.URL.Path == "/v2/_spegel/status" && req.Method == http.MethodGet {
+		r.statusHandler(rw, req)
+		handler = "status"
+		return
+	}
+	if req.URL.Path == "/v2/_spegel/blocklist" && r.blocklist != nil {
+		r.blocklistHandler(rw, req)
+		handler = "blocklist"
+		return
+	}
 	if strings.HasPrefix(req.URL.Path, "/v2") && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+		if ok, delay := r.rateLimiter.allow(remoteAddr(req)); !ok {
+			rw.Header().Set("Retry-After", retryAfterSeconds(delay))
+			writeDistributionError(rw, req, http.StatusTooManyRequests, errCodeTooManyRequests, errors.New("client rate limit exceeded"))
+			handler = "throttled"
+			return
+		}
+		if r.requestSem != nil {
+			select {
+			case r.requestSem <- struct{}{}:
+				metrics.RegistryRequestsInflight.Inc()
+				defer func() {
+					<-r.requestSem
+					metrics.RegistryRequestsInflight.Dec()
+				}()
+			default:
+				rw.Header().Set("Retry-After", "1")
+				writeDistributionError(rw, req, http.StatusTooManyRequests, errCodeTooManyRequests, errors.New("max concurrent registry requests reached"))
+				handler = "throttled"
+				return
+			}
+		}
 		handler = r.registryHandler(rw, req)
 		return
 	}
-	rw.WriteHeader(http.StatusNotFound)
+	writeDistributionError(rw, req, http.StatusNotFound, errCodeUnsupported, fmt.Errorf("%s %s is not supported", req.Method, req.URL.Path))
+}
+
+// allowUpstream returns true if the original registry of the reference is one of the upstream fallback registries.
+func (r *Registry) allowUpstream(ref reference) bool {
+	if ref.originalRegistry == "" {
+		return false
+	}
+	_, ok := r.upstreamHosts[ref.originalRegistry]
+	return ok
+}
+
+// handleUpstream proxies the request to the original registry.
+func (r *Registry) handleUpstream(rw mux.ResponseWriter, req *http.Request, ref reference) {
+	host := ref.originalRegistry
+	// Docker Hub is referred to as docker.io but is served from a different host.
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	u := &url.URL{
+		Scheme: "https",
+		Host:   host,
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	// Upstream credentials are only used for the local node, so that other clients can not pull with them.
+	proxy.Transport = r.transport
+	if isLocalClient(req) {
+		proxy.Transport = r.authTransport
+	}
+	proxy.BufferPool = r.bufferPool
+	director := proxy.Director
+	proxy.Director = func(outReq *http.Request) {
+		director(outReq)
+		outReq.Host = host
+		outReq.URL.RawQuery = ""
+		outReq.Header.Del(MirroredHeaderKey)
+		outReq.Header.Del(ResolveRetriesHeaderKey)
+		if r.userAgent != "" {
+			outReq.Header.Set("User-Agent", r.userAgent)
+		}
+	}
+	proxy.ErrorHandler = func(_ http.ResponseWriter, _ *http.Request, err error) {
+		writeDistributionError(rw, req, http.StatusBadGateway, errCodeUnknown, fmt.Errorf("request to upstream registry %s failed: %w", host, err))
+	}
+	rw.SetAttrs("upstream", host)
+	proxy.ServeHTTP(rw, req)
+}
+
+// filterHeaders returns a copy of the header only containing the allowed header keys.
+func filterHeaders(header http.Header, allowed []string) http.Header {
+	filtered := http.Header{}
+	for _, k := range allowed {
+		v, ok := header[k]
+		if !ok {
+			continue
+		}
+		filtered[k] = v
+	}
+	return filtered
+}
+
+// accessLogKVs returns the configured access log fields from the request attributes.
+func (r *Registry) accessLogKVs(rw mux.ResponseWriter, latency time.Duration) []any {
+	values := map[string]any{
+		"status":   rw.Status(),
+		"bytes":    rw.Size(),
+		"duration": latency.String(),
+	}
+	attrs := rw.Attrs()
+	for i := 0; i+1 < len(attrs); i += 2 {
+		k, ok := attrs[i].(string)
+		if !ok {
+			continue
+		}
+		values[k] = attrs[i+1]
+	}
+	kvs := []any{}
+	for _, field := range r.accessLogFields {
+		v, ok := values[field]
+		if !ok {
+			continue
+		}
+		kvs = append(kvs, field, v)
+	}
+	return kvs
+}
+
+// Drain makes the registry report as not ready while continuing to serve requests, so that in flight pulls complete.
+func (r *Registry) Drain() {
+	r.draining.Store(true)
 }
 
 func (r *Registry) readyHandler(rw mux.ResponseWriter, req *http.Request) {
+	if r.draining.Load() {
+		rw.WriteError(http.StatusServiceUnavailable, errors.New("registry is draining"))
+		return
+	}
 	ok, err := r.router.Ready(req.Context())
 	if err != nil {
 		rw.WriteError(http.StatusInternalServerError, fmt.Errorf("could not determine router readiness: %w", err))
@@ -167,6 +666,57 @@ func (r *Registry) readyHandler(rw mux.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if r.minReadyPeers <= 0 {
+		return
+	}
+	status, err := r.router.Status(req.Context())
+	if err != nil {
+		rw.WriteError(http.StatusInternalServerError, fmt.Errorf("could not get router status: %w", err))
+		return
+	}
+	if status.Peers < r.minReadyPeers {
+		rw.WriteError(http.StatusInternalServerError, fmt.Errorf("connected to %d peers but requires at least %d peers to be ready", status.Peers, r.minReadyPeers))
+		return
+	}
+}
+
+func (r *Registry) statusHandler(rw mux.ResponseWriter, req *http.Request) {
+	status, err := r.router.Status(req.Context())
+	if err != nil {
+		rw.WriteError(http.StatusInternalServerError, fmt.Errorf("could not get router status: %w", err))
+		return
+	}
+	b, err := json.Marshal(status)
+	if err != nil {
+		rw.WriteError(http.StatusInternalServerError, fmt.Errorf("could not marshal router status: %w", err))
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Length", strconv.FormatInt(int64(len(b)), 10))
+	_, err = rw.Write(b)
+	if err != nil {
+		r.log.Error(err, "error occurred when writing status")
+		return
+	}
+}
+
+func (r *Registry) blocklistHandler(rw mux.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := json.Marshal(r.blocklist.List())
+	if err != nil {
+		rw.WriteError(http.StatusInternalServerError, fmt.Errorf("could not marshal blocklist: %w", err))
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Length", strconv.FormatInt(int64(len(b)), 10))
+	_, err = rw.Write(b)
+	if err != nil {
+		r.log.Error(err, "error occurred when writing blocklist")
+		return
+	}
 }
 
 func (r *Registry) registryHandler(rw mux.ResponseWriter, req *http.Request) string {
@@ -180,12 +730,35 @@ func (r *Registry) registryHandler(rw mux.ResponseWriter, req *http.Request) str
 	originalRegistry := req.URL.Query().Get("ns")
 	ref, err := parsePathComponents(originalRegistry, req.URL.Path)
 	if err != nil {
-		rw.WriteError(http.StatusNotFound, fmt.Errorf("could not parse path according to OCI distribution spec: %w", err))
+		writeDistributionError(rw, req, http.StatusNotFound, errCodeUnsupported, fmt.Errorf("could not parse path according to OCI distribution spec: %w", err))
+		return "registry"
+	}
+
+	// Tags pulled through a registry alias share the routing key of the canonical registry.
+	if ref.name != "" {
+		ref.name = r.registryAliases.CanonicalName(ref.name)
+	}
+
+	rw.SetAttrs("key", ref.key())
+
+	// Requests without the registry parameter can not be matched and are allowed.
+	if ref.originalRegistry != "" && !oci.MatchRepositoryPrefixes(r.repoPrefixes, ref.originalRegistry, ref.repository) {
+		writeDistributionError(rw, req, http.StatusNotFound, errCodeNameUnknown, fmt.Errorf("repository %s is not within the mirrored repository prefixes", ref.repository))
 		return "registry"
 	}
 
 	// Request with mirror header are proxied.
 	if req.Header.Get(MirroredHeaderKey) != "true" {
+		// Referrers that exist locally do not have to be mirrored.
+		if ref.kind == referenceKindReferrers {
+			descs, err := r.ociClient.ListReferrers(req.Context(), ref.dgst)
+			descs = filterReferrers(descs, req.URL.Query().Get("artifactType"))
+			if err == nil && len(descs) > 0 {
+				rw.SetAttrs("cache", "local")
+				r.writeReferrers(rw, req, descs)
+				return "referrers"
+			}
+		}
 		// Set mirrored header in request to stop infinite loops
 		req.Header.Set(MirroredHeaderKey, "true")
 		r.handleMirror(rw, req, ref)
@@ -193,25 +766,39 @@ func (r *Registry) registryHandler(rw mux.ResponseWriter, req *http.Request) str
 	}
 
 	// Serve registry endpoints.
+	rw.SetAttrs("cache", "local")
 	switch ref.kind {
 	case referenceKindManifest:
 		r.handleManifest(rw, req, ref)
 		return "manifest"
 	case referenceKindBlob:
+		if r.skipBlobs {
+			writeDistributionError(rw, req, http.StatusNotFound, errCodeBlobUnknown, errors.New("serving blobs is disabled"))
+			return "blob"
+		}
 		r.handleBlob(rw, req, ref)
 		return "blob"
+	case referenceKindReferrers:
+		r.handleReferrers(rw, req, ref)
+		return "referrers"
 	default:
-		rw.WriteError(http.StatusNotFound, fmt.Errorf("unknown reference kind %s", ref.kind))
+		writeDistributionError(rw, req, http.StatusNotFound, errCodeUnsupported, fmt.Errorf("unknown reference kind %s", ref.kind))
 		return "registry"
 	}
 }
 
 func (r *Registry) handleMirror(rw mux.ResponseWriter, req *http.Request, ref reference) {
-	key := ref.dgst.String()
-	if key == "" {
-		key = ref.name
+	// Tags which a peer resolved before are mirrored by digest, so that any peer with the content can serve it.
+	if ref.hasTag() {
+		if dgst, ok := r.tagCache.get(ref.name); ok {
+			ref.dgst = dgst
+			req.URL.Path = fmt.Sprintf("/v2/%s/manifests/%s", ref.repository, dgst)
+			req.URL.RawPath = ""
+		}
 	}
 
+	key := ref.key()
+
 	log := r.log.WithValues("key", key, "path", req.URL.Path, "ip", getClientIP(req))
 
 	isExternal := r.isExternalRequest(req)
@@ -219,6 +806,8 @@ func (r *Registry) handleMirror(rw mux.ResponseWriter, req *http.Request, ref re
 		log.Info("handling mirror request from external node")
 	}
 
+	mirrorAttempts := 0
+	mirrorPeer := ""
 	defer func() {
 		sourceType := "internal"
 		if isExternal {
@@ -228,73 +817,196 @@ func (r *Registry) handleMirror(rw mux.ResponseWriter, req *http.Request, ref re
 		if rw.Status() != http.StatusOK {
 			cacheType = "miss"
 		}
-		metrics.MirrorRequestsTotal.WithLabelValues(ref.originalRegistry, cacheType, sourceType).Inc()
+		metrics.MirrorRequestsTotal.WithLabelValues(ref.registryLabel(), cacheType, sourceType).Inc()
+		// Misses only write an error body, which is not mirrored content.
+		mirroredBytes := int64(0)
+		if cacheType == "hit" {
+			mirroredBytes = rw.Size()
+			metrics.MirrorBytesTotal.WithLabelValues(ref.registryLabel()).Add(float64(mirroredBytes))
+		}
+		rw.SetAttrs("cache", cacheType, "attempts", mirrorAttempts)
+		if r.mirrorEvents != nil {
+			r.mirrorEvents(MirrorEvent{
+				Name:     ref.name,
+				Digest:   ref.dgst.String(),
+				Registry: ref.registryLabel(),
+				Peer:     mirrorPeer,
+				Outcome:  cacheType,
+				Bytes:    mirroredBytes,
+			})
+		}
 	}()
 
 	if !r.resolveLatestTag && ref.hasLatestTag() {
 		r.log.V(4).Info("skipping mirror request for image with latest tag", "image", ref.name)
-		rw.WriteHeader(http.StatusNotFound)
+		writeDistributionError(rw, req, http.StatusNotFound, errCodeManifestUnknown, fmt.Errorf("mirroring image %s with latest tag is disabled", ref.name))
+		return
+	}
+	if r.blockMutableTags && ref.hasTag() {
+		r.log.V(4).Info("skipping mirror request for image with tag", "image", ref.name)
+		writeDistributionError(rw, req, http.StatusNotFound, errCodeManifestUnknown, fmt.Errorf("mirroring image %s by tag is disabled", ref.name))
 		return
 	}
 
-	// Resolve mirror with the requested key
-	resolveCtx, cancel := context.WithTimeout(req.Context(), r.resolveTimeout)
-	defer cancel()
-	resolveCtx = logr.NewContext(resolveCtx, log)
-	peerCh, err := r.router.Resolve(resolveCtx, key, isExternal, r.resolveRetries)
+	// Resolve mirror with the requested key, concurrent requests for the same key share a single resolve.
+	resolveRetries := r.requestResolveRetries(req)
+	bufferSize := resolveRetries
+	if bufferSize == 0 {
+		bufferSize = 20
+	}
+	groupKey := fmt.Sprintf("%s+%t+%d", key, isExternal, resolveRetries)
+	peerCh, shared, err := r.resolveGroup.resolve(logr.NewContext(req.Context(), log), groupKey, r.resolveTimeout, bufferSize, func(ctx context.Context) (<-chan netip.AddrPort, error) {
+		return r.router.Resolve(ctx, key, isExternal, resolveRetries)
+	})
 	if err != nil {
-		rw.WriteError(http.StatusInternalServerError, fmt.Errorf("error occurred when attempting to resolve mirrors: %w", err))
+		writeDistributionError(rw, req, http.StatusInternalServerError, errCodeUnknown, fmt.Errorf("error occurred when attempting to resolve mirrors: %w", err))
 		return
 	}
+	if shared {
+		metrics.MirrorResolveCoalescedTotal.Inc()
+	}
 
-	mirrorAttempts := 0
 	for {
 		select {
 		case <-req.Context().Done():
 			// Request has been closed by server or client. No use continuing.
-			rw.WriteError(http.StatusNotFound, fmt.Errorf("mirroring for image component %s has been cancelled: %w", key, resolveCtx.Err()))
+			writeDistributionError(rw, req, http.StatusNotFound, ref.unknownErrorCode(), fmt.Errorf("mirroring for image component %s has been cancelled: %w", key, req.Context().Err()))
 			return
 		case ipAddr, ok := <-peerCh:
 			// Channel closed means no more mirrors will be received and max retries has been reached.
 			if !ok {
+				if r.allowUpstream(ref) {
+					r.handleUpstream(rw, req, ref)
+					return
+				}
 				err = fmt.Errorf("mirror with image component %s could not be found", key)
 				if mirrorAttempts > 0 {
 					err = errors.Join(err, fmt.Errorf("requests to %d mirrors failed, all attempts have been exhausted or timeout has been reached", mirrorAttempts))
 				}
-				rw.WriteError(http.StatusNotFound, err)
+				writeDistributionError(rw, req, http.StatusNotFound, ref.unknownErrorCode(), err)
 				return
 			}
+			if !r.breaker.allow(ipAddr) {
+				log.V(4).Info("skipping mirror with open circuit breaker", "mirror", ipAddr.String())
+				continue
+			}
 
+			// Stop retrying when most mirror requests are failing to avoid amplifying load on peers.
+			if mirrorAttempts > 0 && !r.retryBudget.withdraw() {
+				metrics.MirrorRetryBudgetExhaustedTotal.Inc()
+				if r.allowUpstream(ref) {
+					r.handleUpstream(rw, req, ref)
+					return
+				}
+				writeDistributionError(rw, req, http.StatusNotFound, ref.unknownErrorCode(), fmt.Errorf("mirror retry budget exhausted after %d attempts for image component %s", mirrorAttempts, key))
+				return
+			}
+
+			// Wait before attempting the next mirror to spread out load on peers.
+			if mirrorAttempts > 0 && r.retryBackoff > 0 {
+				timer := time.NewTimer(backoffDuration(r.retryBackoff, mirrorAttempts))
+				select {
+				case <-req.Context().Done():
+					timer.Stop()
+					writeDistributionError(rw, req, http.StatusNotFound, ref.unknownErrorCode(), fmt.Errorf("mirroring for image component %s has been cancelled: %w", key, req.Context().Err()))
+					return
+				case <-timer.C:
+				}
+			}
 			mirrorAttempts++
 
 			// Modify response returns and error on non 200 status code and NOP error handler skips response writing.
 			// If proxy fails no response is written and it is tried again against a different mirror.
 			// If the response writer has been written to it means that the request was properly proxied.
 			succeeded := false
-			scheme := "http"
-			if req.TLS != nil {
-				scheme = "https"
+			responded := false
+			tooLarge := false
+			scheme := r.peerScheme
+			if scheme == "" {
+				scheme = "http"
+				if req.TLS != nil {
+					scheme = "https"
+				}
 			}
 			u := &url.URL{
 				Scheme: scheme,
 				Host:   ipAddr.String(),
 			}
 			proxy := httputil.NewSingleHostReverseProxy(u)
-			proxy.Transport = r.transport
+			// Time to first byte is measured until the response headers are received, excluding the transfer of the body.
+			start := time.Now()
+			proxy.Transport = r.mirrorTransport
+			proxy.BufferPool = r.bufferPool
+			if len(r.forwardHeaders) > 0 || r.userAgent != "" {
+				director := proxy.Director
+				proxy.Director = func(outReq *http.Request) {
+					director(outReq)
+					if len(r.forwardHeaders) > 0 {
+						outReq.Header = filterHeaders(outReq.Header, r.forwardHeaders)
+					}
+					if r.userAgent != "" {
+						outReq.Header.Set("User-Agent", r.userAgent)
+					}
+				}
+			}
 			proxy.ErrorHandler = func(_ http.ResponseWriter, _ *http.Request, err error) {
 				log.Error(err, "request to mirror failed", "attempt", mirrorAttempts)
 			}
 			proxy.ModifyResponse = func(resp *http.Response) error {
-				if resp.StatusCode != http.StatusOK {
+				metrics.MirrorFirstByteDurHistogram.WithLabelValues(strings.ToLower(string(ref.kind))).Observe(time.Since(start).Seconds())
+				// Any response which is not a server error means that the mirror is healthy.
+				responded = resp.StatusCode < http.StatusInternalServerError
+				// Range requests can be served partially by mirrors reading from the content path.
+				partial := resp.StatusCode == http.StatusPartialContent && resp.Request != nil && resp.Request.Header.Get("Range") != ""
+				if resp.StatusCode != http.StatusOK && !partial {
 					return fmt.Errorf("expected mirror to respond with 200 OK but received: %s", resp.Status)
 				}
+				// Protect against peers responding with manifests that are too large to be reasonable.
+				if ref.kind == referenceKindManifest && r.maxManifestSize > 0 {
+					if resp.ContentLength > r.maxManifestSize {
+						return fmt.Errorf("mirror manifest size %d exceeds max manifest size %d", resp.ContentLength, r.maxManifestSize)
+					}
+					resp.Body = http.MaxBytesReader(nil, resp.Body, r.maxManifestSize)
+				}
+				if ref.kind == referenceKindBlob && r.maxBlobSize > 0 && resp.ContentLength > r.maxBlobSize {
+					tooLarge = true
+					return fmt.Errorf("mirror blob size %d exceeds max blob size %d", resp.ContentLength, r.maxBlobSize)
+				}
+				// Remember the digest the peer resolved the tag to.
+				if ref.hasTag() {
+					if dgst, err := digest.Parse(resp.Header.Get("Docker-Content-Digest")); err == nil {
+						r.tagCache.add(ref.name, dgst)
+					}
+				}
 				succeeded = true
 				return nil
 			}
 			proxy.ServeHTTP(rw, req)
+			// The proxied request is cancelled with the client request, which closes the connection to the mirror.
+			// A mirror failing because of the cancellation says nothing about its health and is not retried.
+			if !succeeded && req.Context().Err() != nil {
+				writeDistributionError(rw, req, http.StatusNotFound, ref.unknownErrorCode(), fmt.Errorf("mirroring for image component %s has been cancelled: %w", key, req.Context().Err()))
+				return
+			}
+			if responded {
+				r.breaker.success(ipAddr)
+			} else {
+				r.breaker.failure(ipAddr)
+			}
+			if tooLarge {
+				// Other mirrors will serve the same blob size so there is no use in continuing.
+				writeDistributionError(rw, req, http.StatusNotFound, errCodeBlobUnknown, fmt.Errorf("blob %s exceeds max blob size %d", key, r.maxBlobSize))
+				return
+			}
 			if !succeeded {
 				break
 			}
+			r.retryBudget.deposit()
+			mirrorPeer = ipAddr.String()
+			rw.SetAttrs("peer", mirrorPeer)
+			if ref.kind == referenceKindBlob && req.Method == http.MethodGet {
+				metrics.ServedBlobBytes.WithLabelValues("mirror").Observe(float64(rw.Size()))
+			}
 			log.V(4).Info("mirrored request", "url", u.String())
 			return
 		}
@@ -302,25 +1014,51 @@ func (r *Registry) handleMirror(rw mux.ResponseWriter, req *http.Request, ref re
 }
 
 func (r *Registry) handleManifest(rw mux.ResponseWriter, req *http.Request, ref reference) {
+	var err error
+	if r.blockMutableTags && ref.hasTag() {
+		writeDistributionError(rw, req, http.StatusNotFound, errCodeManifestUnknown, fmt.Errorf("resolving image %s by tag is disabled", ref.name))
+		return
+	}
 	if ref.dgst == "" {
-		var err error
-		ref.dgst, err = r.ociClient.Resolve(req.Context(), ref.name)
+		ref.dgst, err = r.resolveTag(req.Context(), ref.name)
 		if err != nil {
-			rw.WriteError(http.StatusNotFound, fmt.Errorf("could not get digest for image tag %s: %w", ref.name, err))
+			writeDistributionError(rw, req, http.StatusNotFound, errCodeManifestUnknown, fmt.Errorf("could not get digest for image tag %s: %w", ref.name, err))
 			return
 		}
 	}
-	b, mediaType, err := r.ociClient.GetManifest(req.Context(), ref.dgst)
-	if err != nil {
-		rw.WriteError(http.StatusNotFound, fmt.Errorf("could not get manifest content for digest %s: %w", ref.dgst.String(), err))
-		return
+	// Serve cached manifests without reading from the store.
+	b, mediaType, ok := r.manifestCache.get(ref.dgst)
+	if !ok {
+		b, mediaType, err = r.ociClient.GetManifest(req.Context(), ref.dgst)
+		if err != nil {
+			writeDistributionError(rw, req, http.StatusNotFound, errCodeManifestUnknown, fmt.Errorf("could not get manifest content for digest %s: %w", ref.dgst.String(), err))
+			return
+		}
+		r.manifestCache.add(ref.dgst, b, mediaType)
+	}
+	if r.localServes != nil {
+		if ref.name != "" {
+			r.localServes(ref.name)
+		}
+		r.localServes(ref.dgst.String())
 	}
 	rw.Header().Set("Content-Type", mediaType)
-	rw.Header().Set("Content-Length", strconv.FormatInt(int64(len(b)), 10))
 	rw.Header().Set("Docker-Content-Digest", ref.dgst.String())
 	if req.Method == http.MethodHead {
+		rw.Header().Set("Content-Length", strconv.FormatInt(int64(len(b)), 10))
 		return
 	}
+	// Digest header is always the digest of the uncompressed manifest.
+	rw.Header().Set("Vary", "Accept-Encoding")
+	if encoding := negotiateEncoding(req.Header.Get("Accept-Encoding")); encoding != "" {
+		b, err = r.encodingCache.encode(ref.dgst, b, encoding)
+		if err != nil {
+			writeDistributionError(rw, req, http.StatusInternalServerError, errCodeUnknown, fmt.Errorf("could not encode manifest content for digest %s: %w", ref.dgst.String(), err))
+			return
+		}
+		rw.Header().Set("Content-Encoding", encoding)
+	}
+	rw.Header().Set("Content-Length", strconv.FormatInt(int64(len(b)), 10))
 	_, err = rw.Write(b)
 	if err != nil {
 		r.log.Error(err, "error occurred when writing manifest")
@@ -328,10 +1066,33 @@ func (r *Registry) handleManifest(rw mux.ResponseWriter, req *http.Request, ref
 	}
 }
 
+// resolveTag resolves the tag with the name of every alias of the registry, as it may have been pulled through any of them.
+func (r *Registry) resolveTag(ctx context.Context, name string) (digest.Digest, error) {
+	registry, rest, _ := strings.Cut(name, "/")
+	errs := []error{}
+	for _, alias := range r.registryAliases.Registries(registry) {
+		dgst, err := r.ociClient.Resolve(ctx, alias+"/"+rest)
+		if err == nil {
+			return dgst, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", errors.Join(errs...)
+}
+
 func (r *Registry) handleBlob(rw mux.ResponseWriter, req *http.Request, ref reference) {
+	if req.Method == http.MethodGet {
+		if start, end, ok := parseBoundedRange(req.Header.Get("Range")); ok && r.handleBlobRange(rw, req, ref, start, end) {
+			return
+		}
+	}
 	size, err := r.ociClient.Size(req.Context(), ref.dgst)
 	if err != nil {
-		rw.WriteError(http.StatusInternalServerError, fmt.Errorf("could not determine size of blob with digest %s: %w", ref.dgst.String(), err))
+		writeDistributionError(rw, req, http.StatusInternalServerError, errCodeUnknown, fmt.Errorf("could not determine size of blob with digest %s: %w", ref.dgst.String(), err))
+		return
+	}
+	if r.maxBlobSize > 0 && size > r.maxBlobSize {
+		writeDistributionError(rw, req, http.StatusNotFound, errCodeBlobUnknown, fmt.Errorf("blob with digest %s size %d exceeds max blob size %d", ref.dgst.String(), size, r.maxBlobSize))
 		return
 	}
 	rw.Header().Set("Content-Length", strconv.FormatInt(size, 10))
@@ -345,21 +1106,203 @@ func (r *Registry) handleBlob(rw mux.ResponseWriter, req *http.Request, ref refe
 	}
 	rc, err := r.ociClient.GetBlob(req.Context(), ref.dgst)
 	if err != nil {
-		rw.WriteError(http.StatusInternalServerError, fmt.Errorf("could not get reader for blob with digest %s: %w", ref.dgst.String(), err))
+		writeDistributionError(rw, req, http.StatusInternalServerError, errCodeUnknown, fmt.Errorf("could not get reader for blob with digest %s: %w", ref.dgst.String(), err))
 		return
 	}
 	defer rc.Close()
-	_, err = io.Copy(w, rc)
+	buf := r.bufferPool.Get()
+	defer r.bufferPool.Put(buf)
+	n, err := io.CopyBuffer(w, rc, buf)
+	// Only record the bytes which were actually written to the client.
+	metrics.ServedBlobBytes.WithLabelValues("local").Observe(float64(n))
 	if err != nil {
 		r.log.Error(err, "error occurred when copying blob")
 		return
 	}
 }
 
+// handleBlobRange serves a bounded range directly from the blob file when the content is read from the content path.
+// The size is taken from the file, avoiding the separate size lookup in the content store. Returns false without
+// writing a response if the blob is not backed by a file, in which case the blob should be served through the content store.
+func (r *Registry) handleBlobRange(rw mux.ResponseWriter, req *http.Request, ref reference, start, end int64) bool {
+	rc, err := r.ociClient.GetBlob(req.Context(), ref.dgst)
+	if err != nil {
+		return false
+	}
+	file, ok := rc.(*os.File)
+	if !ok {
+		//nolint:errcheck // Blob will be read again through the content store.
+		rc.Close()
+		return false
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		writeDistributionError(rw, req, http.StatusInternalServerError, errCodeUnknown, fmt.Errorf("could not determine size of blob with digest %s: %w", ref.dgst.String(), err))
+		return true
+	}
+	size := fi.Size()
+	if r.maxBlobSize > 0 && size > r.maxBlobSize {
+		writeDistributionError(rw, req, http.StatusNotFound, errCodeBlobUnknown, fmt.Errorf("blob with digest %s size %d exceeds max blob size %d", ref.dgst.String(), size, r.maxBlobSize))
+		return true
+	}
+	if start >= size {
+		rw.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		rw.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+	end = min(end, size-1)
+	_, err = file.Seek(start, io.SeekStart)
+	if err != nil {
+		writeDistributionError(rw, req, http.StatusInternalServerError, errCodeUnknown, fmt.Errorf("could not seek in blob with digest %s: %w", ref.dgst.String(), err))
+		return true
+	}
+	rw.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	rw.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	rw.Header().Set("Docker-Content-Digest", ref.dgst.String())
+	rw.WriteHeader(http.StatusPartialContent)
+	var w io.Writer = rw
+	if r.throttler != nil {
+		w = r.throttler.Writer(rw)
+	}
+	buf := r.bufferPool.Get()
+	defer r.bufferPool.Put(buf)
+	n, err := io.CopyBuffer(w, io.LimitReader(file, end-start+1), buf)
+	metrics.ServedBlobBytes.WithLabelValues("local").Observe(float64(n))
+	if err != nil {
+		r.log.Error(err, "error occurred when copying blob range")
+	}
+	return true
+}
+
+// parseBoundedRange parses a Range header with a single range where both the start and end are set.
+func parseBoundedRange(header string) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok || startStr == "" || endStr == "" {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end, err := strconv.ParseInt(endStr, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func (r *Registry) handleReferrers(rw mux.ResponseWriter, req *http.Request, ref reference) {
+	descs, err := r.ociClient.ListReferrers(req.Context(), ref.dgst)
+	if err != nil {
+		writeDistributionError(rw, req, http.StatusInternalServerError, errCodeUnknown, fmt.Errorf("could not list referrers for digest %s: %w", ref.dgst.String(), err))
+		return
+	}
+	descs = filterReferrers(descs, req.URL.Query().Get("artifactType"))
+	// Respond with not found so that the mirror attempts the next peer.
+	if len(descs) == 0 {
+		writeDistributionError(rw, req, http.StatusNotFound, errCodeManifestUnknown, fmt.Errorf("could not find any referrers for digest %s", ref.dgst.String()))
+		return
+	}
+	r.writeReferrers(rw, req, descs)
+}
+
+func (r *Registry) writeReferrers(rw mux.ResponseWriter, req *http.Request, descs []ocispec.Descriptor) {
+	idx := ocispec.Index{
+		Versioned: specs.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: descs,
+	}
+	b, err := json.Marshal(&idx)
+	if err != nil {
+		writeDistributionError(rw, req, http.StatusInternalServerError, errCodeUnknown, fmt.Errorf("could not marshal referrers index: %w", err))
+		return
+	}
+	rw.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+	rw.Header().Set("Content-Length", strconv.FormatInt(int64(len(b)), 10))
+	if req.URL.Query().Get("artifactType") != "" {
+		rw.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	if req.Method == http.MethodHead {
+		return
+	}
+	_, err = rw.Write(b)
+	if err != nil {
+		r.log.Error(err, "error occurred when writing referrers")
+		return
+	}
+}
+
+// filterReferrers returns the referrers with the artifact type. All referrers are returned when the artifact type is empty.
+func filterReferrers(descs []ocispec.Descriptor, artifactType string) []ocispec.Descriptor {
+	if artifactType == "" {
+		return descs
+	}
+	filtered := []ocispec.Descriptor{}
+	for _, desc := range descs {
+		if desc.ArtifactType != artifactType {
+			continue
+		}
+		filtered = append(filtered, desc)
+	}
+	return filtered
+}
+
+// requestResolveRetries returns the resolve retries requested with the header clamped to the maximum.
+// Malformed values fall back to the configured resolve retries.
+func (r *Registry) requestResolveRetries(req *http.Request) int {
+	v := req.Header.Get(ResolveRetriesHeaderKey)
+	if v == "" || r.maxHeaderRetries <= 0 {
+		return r.resolveRetries
+	}
+	retries, err := strconv.Atoi(v)
+	if err != nil || retries <= 0 {
+		return r.resolveRetries
+	}
+	return min(retries, r.maxHeaderRetries)
+}
+
+// backoffDuration returns an exponentially increasing duration with jitter for the given attempt.
+func backoffDuration(base time.Duration, attempt int) time.Duration {
+	if base <= 0 || attempt <= 0 {
+		return 0
+	}
+	// Limit the exponent to avoid overflowing the duration.
+	attempt = min(attempt, 16)
+	d := base * time.Duration(1<<(attempt-1))
+	return d/2 + rand.N(d/2+1)
+}
+
 func (r *Registry) isExternalRequest(req *http.Request) bool {
 	return req.Host != r.localAddr
 }
 
+type localConnKey struct{}
+
+// isLocalClient returns true for requests which can only have been sent from the node, either over a Unix domain
+// socket or from a loopback address. Unlike the Host header neither can be set by the client.
+func isLocalClient(req *http.Request) bool {
+	if local, ok := req.Context().Value(localConnKey{}).(bool); ok && local {
+		return true
+	}
+	return remoteAddr(req).IsLoopback()
+}
+
+// remoteAddr returns the IP of the connected client. Forwarded headers are ignored as they can be set by the client.
+func remoteAddr(req *http.Request) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr()
+}
+
 func getClientIP(req *http.Request) string {
 	forwardedFor := req.Header.Get("X-Forwarded-For")
 	if forwardedFor != "" {
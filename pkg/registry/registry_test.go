@@ -1,16 +1,32 @@
 package registry
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	iofs "io/fs"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 
 	"github.com/spegel-org/spegel/internal/mux"
+	"github.com/spegel-org/spegel/pkg/metrics"
+	"github.com/spegel-org/spegel/pkg/oci"
 	"github.com/spegel-org/spegel/pkg/routing"
 )
 
@@ -62,15 +78,15 @@ func TestMirrorHandler(t *testing.T) {
 			name:            "request should timeout when no peers exists",
 			key:             "no-peers",
 			expectedStatus:  http.StatusNotFound,
-			expectedBody:    "",
-			expectedHeaders: nil,
+			expectedBody:    `{"errors":[{"code":"BLOB_UNKNOWN","message":"mirror with image component no-peers could not be found"}]}`,
+			expectedHeaders: map[string][]string{"Content-Type": {"application/json"}},
 		},
 		{
 			name:            "request should not timeout and give 404 if all peers fail",
 			key:             "no-working-peers",
 			expectedStatus:  http.StatusNotFound,
-			expectedBody:    "",
-			expectedHeaders: nil,
+			expectedBody:    `{"errors":[{"code":"BLOB_UNKNOWN","message":"mirror with image component no-working-peers could not be found\nrequests to 3 mirrors failed, all attempts have been exhausted or timeout has been reached"}]}`,
+			expectedHeaders: map[string][]string{"Content-Type": {"application/json"}},
 		},
 		{
 			name:            "request should work when first peer responds",
@@ -130,6 +146,1302 @@ func TestMirrorHandler(t *testing.T) {
 	}
 }
 
+func TestMirrorHandlerPeerScheme(t *testing.T) {
+	t.Parallel()
+
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//nolint:errcheck // ignore
+		w.Write([]byte("hello world"))
+	}))
+	t.Cleanup(func() {
+		svr.Close()
+	})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{
+		"sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9": {netip.MustParseAddrPort(svr.Listener.Addr().String())},
+	}, netip.AddrPort{})
+
+	for _, scheme := range []string{"", "https"} {
+		t.Run(scheme, func(t *testing.T) {
+			t.Parallel()
+
+			reg := NewRegistry(nil, router, WithTransport(svr.Client().Transport), WithPeerScheme(scheme))
+			m, err := mux.NewServeMux(reg.handle)
+			require.NoError(t, err)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", nil)
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			// The scheme is inferred as http from the request without TLS, which the peer does not accept.
+			if scheme == "" {
+				require.Equal(t, http.StatusNotFound, resp.StatusCode)
+				return
+			}
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, "hello world", string(b))
+		})
+	}
+}
+
+func TestMirrorFirstByteDuration(t *testing.T) {
+	t.Parallel()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//nolint:errcheck // ignore
+		w.Write([]byte("hello world"))
+	}))
+	t.Cleanup(func() {
+		svr.Close()
+	})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{
+		"sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9": {netip.MustParseAddrPort(svr.Listener.Addr().String())},
+	}, netip.AddrPort{})
+	reg := NewRegistry(nil, router)
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	sampleCount := func() uint64 {
+		t.Helper()
+
+		metric := &dto.Metric{}
+		err := metrics.MirrorFirstByteDurHistogram.WithLabelValues("manifest").(prometheus.Metric).Write(metric)
+		require.NoError(t, err)
+		return metric.GetHistogram().GetSampleCount()
+	}
+	before := sampleCount()
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/manifests/sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", nil)
+	m.ServeHTTP(rw, req)
+	resp := rw.Result()
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	// Other tests mirror manifests in parallel, so the count is only known to have increased.
+	require.Greater(t, sampleCount(), before)
+}
+
+func TestMirrorHandlerClientCancel(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	exited := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(exited)
+		close(started)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(func() {
+		svr.Close()
+	})
+	peer := netip.MustParseAddrPort(svr.Listener.Addr().String())
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{
+		"sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9": {peer},
+	}, netip.AddrPort{})
+	reg := NewRegistry(nil, router, WithCircuitBreaker(1, time.Minute))
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.ServeHTTP(rw, req)
+	}()
+
+	<-started
+	cancel()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer request was not cancelled with the client request")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mirror handler did not return after the client request was cancelled")
+	}
+	require.Equal(t, http.StatusNotFound, rw.Code)
+	// The cancelled request should not count as a failure of the peer.
+	require.True(t, reg.breaker.allow(peer))
+}
+
+func TestMirrorEventHandler(t *testing.T) {
+	t.Parallel()
+
+	goodSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//nolint:errcheck // ignore
+		w.Write([]byte("hello world"))
+	}))
+	t.Cleanup(func() {
+		goodSvr.Close()
+	})
+	goodAddrPort := netip.MustParseAddrPort(goodSvr.Listener.Addr().String())
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{
+		"sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9": {goodAddrPort},
+	}, netip.AddrPort{})
+	events := []MirrorEvent{}
+	reg := NewRegistry(nil, router, WithMirrorEventHandler(func(event MirrorEvent) {
+		events = append(events, event)
+	}))
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	for _, dgst := range []string{"sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", "sha256:a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"} {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/"+dgst+"?ns=docker.io", nil)
+		m.ServeHTTP(rw, req)
+		require.NoError(t, rw.Result().Body.Close())
+	}
+
+	expected := []MirrorEvent{
+		{
+			Digest:   "sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+			Registry: "docker.io",
+			Peer:     goodAddrPort.String(),
+			Outcome:  "hit",
+			Bytes:    11,
+		},
+		{
+			Digest:   "sha256:a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447",
+			Registry: "docker.io",
+			Outcome:  "miss",
+			Bytes:    0,
+		},
+	}
+	require.Equal(t, expected, events)
+}
+
+func TestMirrorHandlerMaxSize(t *testing.T) {
+	t.Parallel()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//nolint:errcheck // ignore
+		w.Write([]byte("hello world"))
+	}))
+	t.Cleanup(func() {
+		svr.Close()
+	})
+	dgst := "sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	resolver := map[string][]netip.AddrPort{
+		dgst: {netip.MustParseAddrPort(svr.Listener.Addr().String())},
+	}
+	router := routing.NewMemoryRouter(resolver, netip.AddrPort{})
+
+	tests := []struct {
+		name           string
+		kind           string
+		opt            Option
+		expectedStatus int
+	}{
+		{
+			name:           "manifest within limit",
+			kind:           "manifests",
+			opt:            WithMaxManifestSize(1024),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "manifest exceeds limit",
+			kind:           "manifests",
+			opt:            WithMaxManifestSize(5),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "blob within limit",
+			kind:           "blobs",
+			opt:            WithMaxMirrorBlobSize(1024),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "blob exceeds limit",
+			kind:           "blobs",
+			opt:            WithMaxMirrorBlobSize(5),
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reg := NewRegistry(nil, router, tt.opt)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/v2/foo/bar/%s/%s", tt.kind, dgst), nil)
+			m, err := mux.NewServeMux(reg.handle)
+			require.NoError(t, err)
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestMirrorHandlerForwardHeaders(t *testing.T) {
+	t.Parallel()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, k := range []string{"X-Meta-Source", "X-Other", "User-Agent", MirroredHeaderKey} {
+			w.Header().Set("Echo-"+k, r.Header.Get(k))
+		}
+	}))
+	t.Cleanup(func() {
+		svr.Close()
+	})
+	resolver := map[string][]netip.AddrPort{
+		"foo": {netip.MustParseAddrPort(svr.Listener.Addr().String())},
+	}
+	router := routing.NewMemoryRouter(resolver, netip.AddrPort{})
+
+	tests := []struct {
+		name              string
+		expectedOther     string
+		userAgent         string
+		expectedUserAgent string
+		forwardHeaders    []string
+	}{
+		{
+			name:              "all headers forwarded without allowlist",
+			forwardHeaders:    nil,
+			expectedOther:     "other",
+			expectedUserAgent: "containerd/v1.7.18",
+		},
+		{
+			name:              "only allowlisted headers forwarded",
+			forwardHeaders:    []string{"x-meta-source", "Connection"},
+			expectedOther:     "",
+			expectedUserAgent: "containerd/v1.7.18",
+		},
+		{
+			name:              "user agent overridden",
+			userAgent:         "spegel/custom",
+			expectedOther:     "other",
+			expectedUserAgent: "spegel/custom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reg := NewRegistry(nil, router, WithForwardHeaders(tt.forwardHeaders), WithUserAgent(tt.userAgent))
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/foo", nil)
+			req.Header.Set("X-Meta-Source", "source")
+			req.Header.Set("X-Other", "other")
+			req.Header.Set("User-Agent", "containerd/v1.7.18")
+			m, err := mux.NewServeMux(reg.handle)
+			require.NoError(t, err)
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "source", resp.Header.Get("Echo-X-Meta-Source"))
+			require.Equal(t, tt.expectedOther, resp.Header.Get("Echo-X-Other"))
+			require.Equal(t, tt.expectedUserAgent, resp.Header.Get("Echo-User-Agent"))
+			require.Equal(t, "true", resp.Header.Get("Echo-"+MirroredHeaderKey))
+		})
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	t.Parallel()
+
+	self := netip.MustParseAddrPort("127.0.0.1:5000")
+	resolver := map[string][]netip.AddrPort{
+		"foo": {self, netip.MustParseAddrPort("127.0.0.1:5001")},
+		"bar": {netip.MustParseAddrPort("127.0.0.1:5002")},
+	}
+	router := routing.NewMemoryRouter(resolver, self)
+
+	tests := []struct {
+		name           string
+		minReadyPeers  int
+		expectedStatus int
+		draining       bool
+	}{
+		{
+			name:           "no minimum peers",
+			minReadyPeers:  0,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "draining",
+			minReadyPeers:  0,
+			draining:       true,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "enough peers",
+			minReadyPeers:  2,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "too few peers",
+			minReadyPeers:  3,
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reg := NewRegistry(nil, router, WithMinReadyPeers(tt.minReadyPeers))
+			if tt.draining {
+				reg.Drain()
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/healthz", nil)
+			m, err := mux.NewServeMux(reg.handle)
+			require.NoError(t, err)
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestServeBlobsDisabled(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry(nil, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}), WithServeBlobs(false))
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", nil)
+	req.Header.Set(MirroredHeaderKey, "true")
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+	m.ServeHTTP(rw, req)
+
+	resp := rw.Result()
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"errors":[{"code":"BLOB_UNKNOWN","message":"serving blobs is disabled"}]}`, string(b))
+}
+
+type fileClient struct {
+	*oci.MockClient
+	path string
+}
+
+func (f *fileClient) Size(ctx context.Context, dgst digest.Digest) (int64, error) {
+	return 0, errors.New("size should be read from file")
+}
+
+func (f *fileClient) GetBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	return os.Open(f.path)
+}
+
+func TestBlobRange(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "blob")
+	err := os.WriteFile(path, []byte("hello world"), 0o600)
+	require.NoError(t, err)
+	reg := NewRegistry(&fileClient{path: path}, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}))
+
+	tests := []struct {
+		name                 string
+		rangeHeader          string
+		expectedContentRange string
+		expectedBody         string
+		expectedStatus       int
+	}{
+		{
+			name:                 "bounded range",
+			rangeHeader:          "bytes=4-8",
+			expectedStatus:       http.StatusPartialContent,
+			expectedContentRange: "bytes 4-8/11",
+			expectedBody:         "o wor",
+		},
+		{
+			name:                 "end past size",
+			rangeHeader:          "bytes=6-100",
+			expectedStatus:       http.StatusPartialContent,
+			expectedContentRange: "bytes 6-10/11",
+			expectedBody:         "world",
+		},
+		{
+			name:                 "start past size",
+			rangeHeader:          "bytes=11-20",
+			expectedStatus:       http.StatusRequestedRangeNotSatisfiable,
+			expectedContentRange: "bytes */11",
+		},
+		{
+			name:           "open ended range uses content store",
+			rangeHeader:    "bytes=6-",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", nil)
+			req.Header.Set(MirroredHeaderKey, "true")
+			req.Header.Set("Range", tt.rangeHeader)
+			m, err := mux.NewServeMux(reg.handle)
+			require.NoError(t, err)
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedContentRange == "" {
+				return
+			}
+			require.Equal(t, tt.expectedContentRange, resp.Header.Get("Content-Range"))
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedBody, string(b))
+		})
+	}
+}
+
+func TestMirrorHandlerBlobRange(t *testing.T) {
+	t.Parallel()
+
+	// The peer serves bounded ranges from the content path with a partial response.
+	path := filepath.Join(t.TempDir(), "blob")
+	err := os.WriteFile(path, []byte("hello world"), 0o600)
+	require.NoError(t, err)
+	peerReg := NewRegistry(&fileClient{path: path}, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}))
+	peerMux, err := mux.NewServeMux(peerReg.handle)
+	require.NoError(t, err)
+	svr := httptest.NewServer(peerMux)
+	t.Cleanup(func() {
+		svr.Close()
+	})
+	dgst := "sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	resolver := map[string][]netip.AddrPort{
+		dgst: {netip.MustParseAddrPort(svr.Listener.Addr().String())},
+	}
+	reg := NewRegistry(nil, routing.NewMemoryRouter(resolver, netip.AddrPort{}))
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/"+dgst, nil)
+	req.Header.Set("Range", "bytes=4-8")
+	m.ServeHTTP(rw, req)
+
+	resp := rw.Result()
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, "bytes 4-8/11", resp.Header.Get("Content-Range"))
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "o wor", string(b))
+}
+
+func TestParseBoundedRange(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		header        string
+		expectedStart int64
+		expectedEnd   int64
+		expectedOk    bool
+	}{
+		{header: "bytes=0-99", expectedStart: 0, expectedEnd: 99, expectedOk: true},
+		{header: "bytes=10-10", expectedStart: 10, expectedEnd: 10, expectedOk: true},
+		{header: "bytes=10-"},
+		{header: "bytes=-10"},
+		{header: "bytes=10-5"},
+		{header: "bytes=0-1,4-5"},
+		{header: "items=0-1"},
+		{header: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			t.Parallel()
+
+			start, end, ok := parseBoundedRange(tt.header)
+			require.Equal(t, tt.expectedOk, ok)
+			require.Equal(t, tt.expectedStart, start)
+			require.Equal(t, tt.expectedEnd, end)
+		})
+	}
+}
+
+func TestUnsupportedRequest(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry(nil, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}))
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/v2/foo/bar/blobs/uploads/", nil)
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+	m.ServeHTTP(rw, req)
+
+	resp := rw.Result()
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"errors":[{"code":"UNSUPPORTED","message":"POST /v2/foo/bar/blobs/uploads/ is not supported"}]}`, string(b))
+}
+
+func TestRepositoryPrefixes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		url              string
+		expectedMirrored bool
+	}{
+		{
+			name:             "matching prefix",
+			url:              "http://example.com/v2/myorg/app/manifests/latest?ns=ghcr.io",
+			expectedMirrored: true,
+		},
+		{
+			name:             "outside prefix",
+			url:              "http://example.com/v2/other/app/manifests/latest?ns=ghcr.io",
+			expectedMirrored: false,
+		},
+		{
+			name:             "registry without prefix",
+			url:              "http://example.com/v2/library/nginx/manifests/latest?ns=docker.io",
+			expectedMirrored: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mirrored := false
+			prefixes := []oci.RepositoryPrefix{{Registry: "ghcr.io", Repository: "myorg"}}
+			reg := NewRegistry(oci.NewMockClient(nil), routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}), WithRepositoryPrefixes(prefixes), WithMirrorEventHandler(func(MirrorEvent) {
+				mirrored = true
+			}))
+			m, err := mux.NewServeMux(reg.handle)
+			require.NoError(t, err)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusNotFound, resp.StatusCode)
+			require.Equal(t, tt.expectedMirrored, mirrored)
+		})
+	}
+}
+
+type resolveClient struct {
+	*oci.MockClient
+	tags map[string]digest.Digest
+}
+
+func (r *resolveClient) Resolve(ctx context.Context, ref string) (digest.Digest, error) {
+	dgst, ok := r.tags[ref]
+	if !ok {
+		return "", fmt.Errorf("could not resolve %s", ref)
+	}
+	return dgst, nil
+}
+
+func (r *resolveClient) GetManifest(ctx context.Context, dgst digest.Digest) ([]byte, string, error) {
+	return []byte("manifest"), ocispec.MediaTypeImageManifest, nil
+}
+
+func TestRegistryAliases(t *testing.T) {
+	t.Parallel()
+
+	aliases, err := oci.NewRegistryAliases(nil)
+	require.NoError(t, err)
+
+	// Tags pulled through an alias are looked up with the canonical registry.
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//nolint:errcheck // ignore
+		w.Write([]byte("hello world"))
+	}))
+	t.Cleanup(func() {
+		svr.Close()
+	})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{
+		"docker.io/library/nginx:1.27": {netip.MustParseAddrPort(svr.Listener.Addr().String())},
+	}, netip.AddrPort{})
+	reg := NewRegistry(nil, router, WithRegistryAliases(aliases))
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/library/nginx/manifests/1.27?ns=registry-1.docker.io", nil)
+	m.ServeHTTP(rw, req)
+	resp := rw.Result()
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Local tags stored with an alias are resolved from requests with the canonical registry.
+	dgst := digest.Digest("sha256:b060fffe8e1561c9c3e6dea6db487b900100fc26830b9ea2ec966c151ab4c020")
+	ociClient := &resolveClient{
+		MockClient: oci.NewMockClient(nil),
+		tags:       map[string]digest.Digest{"registry-1.docker.io/library/nginx:1.27": dgst},
+	}
+	reg = NewRegistry(ociClient, router, WithRegistryAliases(aliases))
+	m, err = mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/v2/library/nginx/manifests/1.27?ns=docker.io", nil)
+	req.Header.Set(MirroredHeaderKey, "true")
+	m.ServeHTTP(rw, req)
+	resp = rw.Result()
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, dgst.String(), resp.Header.Get("Docker-Content-Digest"))
+}
+
+func TestMirrorHandlerTagHead(t *testing.T) {
+	t.Parallel()
+
+	// The peer has resolved the tag while the local node does not have it.
+	dgst := digest.Digest("sha256:b060fffe8e1561c9c3e6dea6db487b900100fc26830b9ea2ec966c151ab4c020")
+	peerClient := &resolveClient{
+		MockClient: oci.NewMockClient(nil),
+		tags:       map[string]digest.Digest{"docker.io/library/nginx:1.27": dgst},
+	}
+	peerReg := NewRegistry(peerClient, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}))
+	peerMux, err := mux.NewServeMux(peerReg.handle)
+	require.NoError(t, err)
+	peerSvr := httptest.NewServer(peerMux)
+	t.Cleanup(func() {
+		peerSvr.Close()
+	})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{
+		"docker.io/library/nginx:1.27": {netip.MustParseAddrPort(peerSvr.Listener.Addr().String())},
+	}, netip.AddrPort{})
+	localClient := &resolveClient{
+		MockClient: oci.NewMockClient(nil),
+		tags:       map[string]digest.Digest{},
+	}
+	reg := NewRegistry(localClient, router)
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodHead, "http://example.com/v2/library/nginx/manifests/1.27?ns=docker.io", nil)
+	m.ServeHTTP(rw, req)
+	resp := rw.Result()
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, dgst.String(), resp.Header.Get("Docker-Content-Digest"))
+	require.Empty(t, b)
+}
+
+func TestPeerTagCache(t *testing.T) {
+	t.Parallel()
+
+	dgst := digest.Digest("sha256:b060fffe8e1561c9c3e6dea6db487b900100fc26830b9ea2ec966c151ab4c020")
+	peerClient := &resolveClient{
+		MockClient: oci.NewMockClient(nil),
+		tags:       map[string]digest.Digest{"docker.io/library/nginx:1.27": dgst},
+	}
+	peerReg := NewRegistry(peerClient, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}))
+	peerMux, err := mux.NewServeMux(peerReg.handle)
+	require.NoError(t, err)
+	tagPeerSvr := httptest.NewServer(peerMux)
+	t.Cleanup(func() {
+		tagPeerSvr.Close()
+	})
+	// The digest peer does not have the tag and only serves the manifest by digest.
+	digestPeerPaths := make(chan string, 1)
+	digestPeerSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		digestPeerPaths <- req.URL.Path
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+	}))
+	t.Cleanup(func() {
+		digestPeerSvr.Close()
+	})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{
+		"docker.io/library/nginx:1.27": {netip.MustParseAddrPort(tagPeerSvr.Listener.Addr().String())},
+		dgst.String():                  {netip.MustParseAddrPort(digestPeerSvr.Listener.Addr().String())},
+	}, netip.AddrPort{})
+	localClient := &resolveClient{
+		MockClient: oci.NewMockClient(nil),
+		tags:       map[string]digest.Digest{},
+	}
+	reg := NewRegistry(localClient, router, WithPeerTagCacheTTL(time.Minute))
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	// The tag is resolved by the peer with the tag on the first mirror request.
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/library/nginx/manifests/1.27?ns=docker.io", nil)
+	m.ServeHTTP(rw, req)
+	resp := rw.Result()
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Later mirror requests for the tag are resolved with the digest.
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/v2/library/nginx/manifests/1.27?ns=docker.io", nil)
+	m.ServeHTTP(rw, req)
+	resp = rw.Result()
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, dgst.String(), resp.Header.Get("Docker-Content-Digest"))
+	require.Equal(t, "/v2/library/nginx/manifests/"+dgst.String(), <-digestPeerPaths)
+
+	// Cached tags are not served to peers as the tag is not present locally.
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/v2/library/nginx/manifests/1.27?ns=docker.io", nil)
+	req.Header.Set(MirroredHeaderKey, "true")
+	m.ServeHTTP(rw, req)
+	resp = rw.Result()
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestLocalServeHandler(t *testing.T) {
+	t.Parallel()
+
+	dgst := digest.Digest("sha256:b060fffe8e1561c9c3e6dea6db487b900100fc26830b9ea2ec966c151ab4c020")
+	ociClient := &resolveClient{
+		MockClient: oci.NewMockClient(nil),
+		tags:       map[string]digest.Digest{"docker.io/library/nginx:1.27": dgst},
+	}
+	served := []string{}
+	reg := NewRegistry(ociClient, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}), WithLocalServeHandler(func(key string) {
+		served = append(served, key)
+	}))
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	for _, p := range []string{"/v2/library/nginx/manifests/1.27?ns=docker.io", "/v2/library/nginx/manifests/" + dgst.String()} {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+p, nil)
+		req.Header.Set(MirroredHeaderKey, "true")
+		m.ServeHTTP(rw, req)
+		resp := rw.Result()
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	require.Equal(t, []string{"docker.io/library/nginx:1.27", dgst.String(), dgst.String()}, served)
+}
+
+func TestBlockMutableTags(t *testing.T) {
+	t.Parallel()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//nolint:errcheck // ignore
+		w.Write([]byte("hello world"))
+	}))
+	t.Cleanup(func() {
+		svr.Close()
+	})
+	dgst := digest.Digest("sha256:b060fffe8e1561c9c3e6dea6db487b900100fc26830b9ea2ec966c151ab4c020")
+	addrPort := netip.MustParseAddrPort(svr.Listener.Addr().String())
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{
+		"docker.io/library/nginx:1.27": {addrPort},
+		dgst.String():                  {addrPort},
+	}, netip.AddrPort{})
+	ociClient := &resolveClient{
+		MockClient: oci.NewMockClient(nil),
+		tags:       map[string]digest.Digest{"docker.io/library/nginx:1.27": dgst},
+	}
+	reg := NewRegistry(ociClient, router, WithBlockMutableTags(true))
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		target         string
+		expectedBody   string
+		expectedStatus int
+		mirrored       bool
+	}{
+		{
+			name:           "mirror tag",
+			target:         "http://example.com/v2/library/nginx/manifests/1.27?ns=docker.io",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"mirroring image docker.io/library/nginx:1.27 by tag is disabled"}]}`,
+		},
+		{
+			name:           "resolve tag for peer",
+			target:         "http://example.com/v2/library/nginx/manifests/1.27?ns=docker.io",
+			mirrored:       true,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"resolving image docker.io/library/nginx:1.27 by tag is disabled"}]}`,
+		},
+		{
+			name:           "mirror digest",
+			target:         fmt.Sprintf("http://example.com/v2/library/nginx/manifests/%s?ns=docker.io", dgst.String()),
+			expectedStatus: http.StatusOK,
+			expectedBody:   "hello world",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.mirrored {
+				req.Header.Set(MirroredHeaderKey, "true")
+			}
+			m.ServeHTTP(rw, req)
+			resp := rw.Result()
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			require.Equal(t, tt.expectedBody, string(b))
+		})
+	}
+}
+
+type referrersClient struct {
+	*oci.MockClient
+	descs []ocispec.Descriptor
+}
+
+func (r *referrersClient) ListReferrers(ctx context.Context, dgst digest.Digest) ([]ocispec.Descriptor, error) {
+	return r.descs, nil
+}
+
+func TestReferrersArtifactTypeFilter(t *testing.T) {
+	t.Parallel()
+
+	signature := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json",
+		Digest:       digest.Digest("sha256:1f2e7a45c5b9b8e0aa4bd2fd5b8f0b1fb21a5b3e2a6bd7c1ce7ec3f35f3c27de"),
+		Size:         100,
+	}
+	sbom := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: "application/spdx+json",
+		Digest:       digest.Digest("sha256:7d9f3b4d0c4a7e3be5d05f0d2e3c6cc0a4ad0c1d9c8a6ef46c0d2e8b7d0d6b3a"),
+		Size:         200,
+	}
+	ociClient := &referrersClient{MockClient: oci.NewMockClient(nil), descs: []ocispec.Descriptor{signature, sbom}}
+	reg := NewRegistry(ociClient, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}))
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name                string
+		query               string
+		expectedFilters     string
+		expectedDescriptors []ocispec.Descriptor
+		expectedStatus      int
+	}{
+		{
+			name:                "no filter",
+			expectedStatus:      http.StatusOK,
+			expectedDescriptors: []ocispec.Descriptor{signature, sbom},
+		},
+		{
+			name:                "filter signatures",
+			query:               "?artifactType=application/vnd.dev.cosign.artifact.sig.v1%2Bjson",
+			expectedStatus:      http.StatusOK,
+			expectedFilters:     "artifactType",
+			expectedDescriptors: []ocispec.Descriptor{signature},
+		},
+		{
+			name:           "no matching artifact type",
+			query:          "?artifactType=application/vnd.example",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/referrers/sha256:295c7be079025306c4f1d65997fcf7adb411c88f139ad1d34b537164aa060369"+tt.query, nil)
+			req.Header.Set(MirroredHeaderKey, "true")
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			require.Equal(t, tt.expectedFilters, resp.Header.Get("OCI-Filters-Applied"))
+			idx := ocispec.Index{}
+			err := json.NewDecoder(resp.Body).Decode(&idx)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedDescriptors, idx.Manifests)
+		})
+	}
+}
+
+func TestReferrersLocal(t *testing.T) {
+	t.Parallel()
+
+	signature := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json",
+		Digest:       digest.Digest("sha256:1f2e7a45c5b9b8e0aa4bd2fd5b8f0b1fb21a5b3e2a6bd7c1ce7ec3f35f3c27de"),
+		Size:         100,
+	}
+
+	tests := []struct {
+		name                string
+		query               string
+		descs               []ocispec.Descriptor
+		expectedDescriptors []ocispec.Descriptor
+		expectedStatus      int
+	}{
+		{
+			name:                "local referrers",
+			descs:               []ocispec.Descriptor{signature},
+			expectedStatus:      http.StatusOK,
+			expectedDescriptors: []ocispec.Descriptor{signature},
+		},
+		{
+			name:           "no local referrers",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "no local referrers matching artifact type",
+			query:          "?artifactType=application/vnd.example",
+			descs:          []ocispec.Descriptor{signature},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Requests without the mirrored header are mirrored unless the referrers exist locally.
+			ociClient := &referrersClient{MockClient: oci.NewMockClient(nil), descs: tt.descs}
+			reg := NewRegistry(ociClient, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}))
+			m, err := mux.NewServeMux(reg.handle)
+			require.NoError(t, err)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/referrers/sha256:295c7be079025306c4f1d65997fcf7adb411c88f139ad1d34b537164aa060369"+tt.query, nil)
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			idx := ocispec.Index{}
+			err = json.NewDecoder(resp.Body).Decode(&idx)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedDescriptors, idx.Manifests)
+		})
+	}
+}
+
+func TestMirrorHandlerUpstreamFallback(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(MirroredHeaderKey) != "" || r.URL.RawQuery != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		//nolint:errcheck // ignore
+		w.Write([]byte("upstream"))
+	}))
+	t.Cleanup(func() {
+		upstream.Close()
+	})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{})
+	upstreamHost := upstream.Listener.Addr().String()
+
+	tests := []struct {
+		name           string
+		ns             string
+		expectedBody   string
+		registries     []url.URL
+		expectedStatus int
+	}{
+		{
+			name:           "fallback disabled",
+			ns:             upstreamHost,
+			registries:     nil,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"errors":[{"code":"BLOB_UNKNOWN","message":"mirror with image component foo could not be found"}]}`,
+		},
+		{
+			name:           "fallback enabled",
+			ns:             upstreamHost,
+			registries:     []url.URL{{Scheme: "https", Host: upstreamHost}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "upstream",
+		},
+		{
+			name:           "registry not configured",
+			ns:             "example.com",
+			registries:     []url.URL{{Scheme: "https", Host: upstreamHost}},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"errors":[{"code":"BLOB_UNKNOWN","message":"mirror with image component foo could not be found"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reg := NewRegistry(nil, router, WithTransport(upstream.Client().Transport), WithUpstreamFallback(tt.registries))
+			rw := httptest.NewRecorder()
+			target := fmt.Sprintf("http://example.com/v2/foo/bar/blobs/foo?ns=%s", tt.ns)
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			m, err := mux.NewServeMux(reg.handle)
+			require.NoError(t, err)
+			m.ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			require.Equal(t, tt.expectedBody, string(b))
+		})
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	t.Parallel()
+
+	self := netip.MustParseAddrPort("127.0.0.1:5000")
+	resolver := map[string][]netip.AddrPort{
+		"foo": {self, netip.MustParseAddrPort("127.0.0.1:5001")},
+		"bar": {self},
+		"baz": {netip.MustParseAddrPort("127.0.0.1:5002")},
+	}
+	router := routing.NewMemoryRouter(resolver, self)
+	reg := NewRegistry(nil, router)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/_spegel/status", nil)
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+	m.ServeHTTP(rw, req)
+
+	resp := rw.Result()
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	status := routing.Status{}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	require.NoError(t, err)
+	require.Equal(t, 2, status.AdvertisedKeys)
+	require.Equal(t, 2, status.Peers)
+}
+
+func TestBlocklistHandler(t *testing.T) {
+	t.Parallel()
+
+	blocklist := routing.NewBlocklist(netip.MustParseAddr("10.0.0.1"))
+	reg := NewRegistry(nil, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}), WithPeerBlocklist(blocklist))
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		expectedBody   string
+		expectedStatus int
+	}{
+		{
+			name:           "list peers",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `["10.0.0.1"]`,
+		},
+		{
+			name:           "add peer",
+			method:         http.MethodPut,
+			query:          "?ip=10.0.0.2",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "remove peer",
+			method:         http.MethodDelete,
+			query:          "?ip=10.0.0.1",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, "http://example.com/v2/_spegel/blocklist"+tt.query, nil)
+		m.ServeHTTP(rw, req)
+
+		resp := rw.Result()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, tt.name)
+		require.NoError(t, resp.Body.Close(), tt.name)
+		require.Equal(t, tt.expectedStatus, resp.StatusCode, tt.name)
+		if tt.expectedStatus != http.StatusOK {
+			continue
+		}
+		require.JSONEq(t, tt.expectedBody, string(b), tt.name)
+	}
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, blocklist.List())
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry(nil, routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.AddrPort{}), WithMaxConcurrentRequests(1))
+	m, err := mux.NewServeMux(reg.handle)
+	require.NoError(t, err)
+
+	// Occupy the only available slot.
+	reg.requestSem <- struct{}{}
+	rw := httptest.NewRecorder()
+	m.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://example.com/v2", nil))
+	resp := rw.Result()
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	<-reg.requestSem
+	rw = httptest.NewRecorder()
+	m.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "http://example.com/v2", nil))
+	resp = rw.Result()
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, reg.requestSem)
+}
+
+func TestAccessLogKVs(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry(nil, nil, WithAccessLogFields([]string{"key", "peer", "status", "unknown", "duration"}))
+	var kvs []any
+	m, err := mux.NewServeMux(func(rw mux.ResponseWriter, req *http.Request) {
+		rw.SetAttrs("key", "foo", "cache", "hit")
+		rw.SetAttrs("peer", "10.0.0.1:5000")
+		rw.WriteHeader(http.StatusOK)
+		kvs = reg.accessLogKVs(rw, time.Second)
+	})
+	require.NoError(t, err)
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/foo", nil))
+
+	expected := []any{"key", "foo", "peer", "10.0.0.1:5000", "status", http.StatusOK, "duration", "1s"}
+	require.Equal(t, expected, kvs)
+}
+
+func TestRequestResolveRetries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name              string
+		header            string
+		maxResolveRetries int
+		expected          int
+	}{
+		{
+			name:              "no header",
+			maxResolveRetries: 10,
+			expected:          3,
+		},
+		{
+			name:              "header within max",
+			header:            "5",
+			maxResolveRetries: 10,
+			expected:          5,
+		},
+		{
+			name:              "header clamped to max",
+			header:            "50",
+			maxResolveRetries: 10,
+			expected:          10,
+		},
+		{
+			name:              "malformed header",
+			header:            "foo",
+			maxResolveRetries: 10,
+			expected:          3,
+		},
+		{
+			name:              "negative header",
+			header:            "-1",
+			maxResolveRetries: 10,
+			expected:          3,
+		},
+		{
+			name:              "header disabled",
+			header:            "5",
+			maxResolveRetries: 0,
+			expected:          3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			reg := NewRegistry(nil, nil, WithResolveRetries(3), WithMaxResolveRetries(tt.maxResolveRetries))
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/v2/foo/bar/blobs/sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", nil)
+			if tt.header != "" {
+				req.Header.Set(ResolveRetriesHeaderKey, tt.header)
+			}
+			require.Equal(t, tt.expected, reg.requestResolveRetries(req))
+		})
+	}
+}
+
+func TestBackoffDuration(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, time.Duration(0), backoffDuration(0, 1))
+	require.Equal(t, time.Duration(0), backoffDuration(10*time.Millisecond, 0))
+	for attempt := 1; attempt <= 5; attempt++ {
+		upper := 10 * time.Millisecond * time.Duration(1<<(attempt-1))
+		for range 100 {
+			d := backoffDuration(10*time.Millisecond, attempt)
+			require.GreaterOrEqual(t, d, upper/2)
+			require.LessOrEqual(t, d, upper)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	t.Parallel()
+
+	l, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	require.Equal(t, "tcp", l.Addr().Network())
+	require.NoError(t, l.Close())
+
+	// Files which are not sockets are never removed.
+	filePath := path.Join(t.TempDir(), "registry.sock")
+	err = os.WriteFile(filePath, []byte("data"), 0o644)
+	require.NoError(t, err)
+	_, err = Listen("unix://" + filePath)
+	require.EqualError(t, err, "refusing to remove "+filePath+" which is not a socket")
+	require.FileExists(t, filePath)
+
+	// Sockets left behind by a previous process are replaced.
+	socketPath := path.Join(t.TempDir(), "registry.sock")
+	stale, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	unixListener, ok := stale.(*net.UnixListener)
+	require.True(t, ok)
+	unixListener.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+	l, err = Listen("unix://" + socketPath)
+	require.NoError(t, err)
+	require.Equal(t, "unix", l.Addr().Network())
+	fi, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	require.Equal(t, iofs.FileMode(0o600), fi.Mode().Perm())
+	require.NoError(t, l.Close())
+	_, err = os.Stat(socketPath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
 func TestGetClientIP(t *testing.T) {
 	t.Parallel()
 
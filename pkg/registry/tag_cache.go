@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+type tagCacheEntry struct {
+	expires time.Time
+	dgst    digest.Digest
+}
+
+// tagCache remembers the digests which peers resolved tags to, so that the tags do not have to be resolved again.
+// Entries expire after the TTL as tags are mutable.
+type tagCache struct {
+	pruned  time.Time
+	entries map[string]tagCacheEntry
+	mx      sync.Mutex
+	ttl     time.Duration
+}
+
+func newTagCache(ttl time.Duration) *tagCache {
+	return &tagCache{
+		entries: map[string]tagCacheEntry{},
+		ttl:     ttl,
+	}
+}
+
+func (t *tagCache) get(name string) (digest.Digest, bool) {
+	if t == nil {
+		return "", false
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.prune(time.Now())
+	entry, ok := t.entries[name]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expires) {
+		delete(t.entries, name)
+		return "", false
+	}
+	return entry.dgst, true
+}
+
+// add caches the digest the tag was resolved to.
+func (t *tagCache) add(name string, dgst digest.Digest) {
+	if t == nil {
+		return
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	now := time.Now()
+	t.prune(now)
+	t.entries[name] = tagCacheEntry{dgst: dgst, expires: now.Add(t.ttl)}
+}
+
+// prune removes expired entries at most once per TTL, so that entries which are never requested again are removed
+// without scanning all entries on every call. The lock has to be held by the caller.
+func (t *tagCache) prune(now time.Time) {
+	if now.Sub(t.pruned) < t.ttl {
+		return
+	}
+	t.pruned = now
+	for k, entry := range t.entries {
+		if now.After(entry.expires) {
+			delete(t.entries, k)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagCache(t *testing.T) {
+	t.Parallel()
+
+	tc := newTagCache(50 * time.Millisecond)
+
+	_, ok := tc.get("docker.io/library/nginx:1.27")
+	require.False(t, ok)
+
+	tc.add("docker.io/library/nginx:1.27", digest.FromString("foo"))
+	dgst, ok := tc.get("docker.io/library/nginx:1.27")
+	require.True(t, ok)
+	require.Equal(t, digest.FromString("foo"), dgst)
+
+	// Entries expire after the TTL.
+	time.Sleep(100 * time.Millisecond)
+	_, ok = tc.get("docker.io/library/nginx:1.27")
+	require.False(t, ok)
+
+	// Expired entries which are not requested again are pruned.
+	tc.add("docker.io/library/nginx:1.28", digest.FromString("bar"))
+	time.Sleep(100 * time.Millisecond)
+	tc.add("docker.io/library/nginx:1.29", digest.FromString("baz"))
+	require.Len(t, tc.entries, 1)
+
+	var nilCache *tagCache
+	nilCache.add("docker.io/library/nginx:1.27", digest.FromString("foo"))
+	_, ok = nilCache.get("docker.io/library/nginx:1.27")
+	require.False(t, ok)
+}
@@ -0,0 +1,59 @@
+package routing
+
+import (
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+// Blocklist is a set of peer IP addresses which should not be returned when resolving.
+// It is safe for concurrent use and can be updated at runtime.
+type Blocklist struct {
+	addrs map[netip.Addr]struct{}
+	mx    sync.RWMutex
+}
+
+func NewBlocklist(addrs ...netip.Addr) *Blocklist {
+	b := &Blocklist{
+		addrs: map[netip.Addr]struct{}{},
+	}
+	for _, addr := range addrs {
+		b.Add(addr)
+	}
+	return b
+}
+
+func (b *Blocklist) Add(addr netip.Addr) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.addrs[addr.Unmap()] = struct{}{}
+}
+
+func (b *Blocklist) Remove(addr netip.Addr) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	delete(b.addrs, addr.Unmap())
+}
+
+func (b *Blocklist) Contains(addr netip.Addr) bool {
+	if b == nil {
+		return false
+	}
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+	_, ok := b.addrs[addr.Unmap()]
+	return ok
+}
+
+func (b *Blocklist) List() []netip.Addr {
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+	addrs := make([]netip.Addr, 0, len(b.addrs))
+	for addr := range b.addrs {
+		addrs = append(addrs, addr)
+	}
+	slices.SortFunc(addrs, func(a, b netip.Addr) int {
+		return a.Compare(b)
+	})
+	return addrs
+}
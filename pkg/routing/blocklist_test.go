@@ -0,0 +1,27 @@
+package routing
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlocklist(t *testing.T) {
+	t.Parallel()
+
+	b := NewBlocklist(netip.MustParseAddr("10.0.0.2"))
+	require.True(t, b.Contains(netip.MustParseAddr("10.0.0.2")))
+	require.True(t, b.Contains(netip.MustParseAddr("::ffff:10.0.0.2")))
+	require.False(t, b.Contains(netip.MustParseAddr("10.0.0.1")))
+
+	b.Add(netip.MustParseAddr("10.0.0.1"))
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")}, b.List())
+
+	b.Remove(netip.MustParseAddr("10.0.0.2"))
+	require.False(t, b.Contains(netip.MustParseAddr("10.0.0.2")))
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, b.List())
+
+	var nilBlocklist *Blocklist
+	require.False(t, nilBlocklist.Contains(netip.MustParseAddr("10.0.0.1")))
+}
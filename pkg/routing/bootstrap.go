@@ -160,3 +160,36 @@ func (h *HTTPBootstrapper) Get() (*peer.AddrInfo, error) {
 	}
 	return addrInfo, err
 }
+
+// DynamicBootstrapper returns a bootstrap peer which can be changed at any time. It is intended for tests
+// which simulate peers joining and leaving the cluster.
+type DynamicBootstrapper struct {
+	addrInfo *peer.AddrInfo
+	mx       sync.RWMutex
+}
+
+func NewDynamicBootstrapper() *DynamicBootstrapper {
+	return &DynamicBootstrapper{}
+}
+
+func (d *DynamicBootstrapper) Run(ctx context.Context, id string) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (d *DynamicBootstrapper) Get() (*peer.AddrInfo, error) {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+	if d.addrInfo == nil {
+		return nil, errors.New("no bootstrap peer is set")
+	}
+	addrInfo := *d.addrInfo
+	return &addrInfo, nil
+}
+
+// Set changes the bootstrap peer. Setting nil removes the peer, simulating the loss of all peers.
+func (d *DynamicBootstrapper) Set(addrInfo *peer.AddrInfo) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.addrInfo = addrInfo
+}
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/stretchr/testify/require"
 )
 
@@ -31,3 +32,34 @@ func TestHTTPBootstrap(t *testing.T) {
 	require.Equal(t, "/ip4/104.131.131.82/tcp/4001", addrInfo.Addrs[0].String())
 	require.Equal(t, "QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ", addrInfo.ID.String())
 }
+
+func TestDynamicBootstrap(t *testing.T) {
+	t.Parallel()
+
+	bootstrapper := NewDynamicBootstrapper()
+	_, err := bootstrapper.Get()
+	require.EqualError(t, err, "no bootstrap peer is set")
+
+	first, err := peer.AddrInfoFromString("/ip4/10.0.0.1/tcp/5001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+	require.NoError(t, err)
+	bootstrapper.Set(first)
+	addrInfo, err := bootstrapper.Get()
+	require.NoError(t, err)
+	require.Equal(t, first, addrInfo)
+
+	second, err := peer.AddrInfoFromString("/ip4/10.0.0.2/tcp/5001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+	require.NoError(t, err)
+	bootstrapper.Set(second)
+	addrInfo, err = bootstrapper.Get()
+	require.NoError(t, err)
+	require.Equal(t, second, addrInfo)
+
+	bootstrapper.Set(nil)
+	_, err = bootstrapper.Get()
+	require.EqualError(t, err, "no bootstrap peer is set")
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	err = bootstrapper.Run(ctx, "")
+	require.NoError(t, err)
+}
@@ -0,0 +1,78 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/spegel-org/spegel/pkg/metrics"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+// peerHealth tracks peers whose registry failed the last health check.
+type peerHealth struct {
+	client    *http.Client
+	unhealthy map[netip.Addr]struct{}
+	mx        sync.RWMutex
+}
+
+func newPeerHealth() *peerHealth {
+	return &peerHealth{
+		client: &http.Client{
+			Timeout: healthCheckTimeout,
+		},
+		unhealthy: map[netip.Addr]struct{}{},
+	}
+}
+
+// isHealthy reports if the peer passed the last health check. Peers which have not been checked are healthy.
+func (p *peerHealth) isHealthy(addr netip.Addr) bool {
+	if p == nil {
+		return true
+	}
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+	_, ok := p.unhealthy[addr]
+	return !ok
+}
+
+// check probes the registry of each peer and replaces the set of unhealthy peers with the result.
+func (p *peerHealth) check(ctx context.Context, peers []netip.AddrPort) {
+	unhealthy := map[netip.Addr]struct{}{}
+	metrics.PeerHealth.Reset()
+	for _, peer := range peers {
+		err := p.probe(ctx, peer)
+		if err != nil {
+			logr.FromContextOrDiscard(ctx).Info("peer failed health check", "peer", peer.String(), "err", err.Error())
+			unhealthy[peer.Addr()] = struct{}{}
+			metrics.PeerHealth.WithLabelValues(peer.Addr().String()).Set(0)
+			continue
+		}
+		metrics.PeerHealth.WithLabelValues(peer.Addr().String()).Set(1)
+	}
+	p.mx.Lock()
+	p.unhealthy = unhealthy
+	p.mx.Unlock()
+}
+
+func (p *peerHealth) probe(ctx context.Context, peer netip.AddrPort) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fmt.Sprintf("http://%s/v2", peer.String()), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("registry responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package routing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerHealth(t *testing.T) {
+	t.Parallel()
+
+	healthySvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(healthySvr.Close)
+	healthy := netip.MustParseAddrPort(healthySvr.Listener.Addr().String())
+	unhealthySvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(unhealthySvr.Close)
+	unhealthy := netip.MustParseAddrPort(unhealthySvr.Listener.Addr().String())
+	unreachable := netip.MustParseAddrPort("127.0.0.2:0")
+
+	var nilHealth *peerHealth
+	require.True(t, nilHealth.isHealthy(unhealthy.Addr()))
+
+	p := newPeerHealth()
+	require.True(t, p.isHealthy(unreachable.Addr()))
+	p.check(context.TODO(), []netip.AddrPort{healthy, unreachable})
+	require.True(t, p.isHealthy(healthy.Addr()))
+	require.False(t, p.isHealthy(unreachable.Addr()))
+
+	// Results of previous checks are replaced.
+	p.check(context.TODO(), []netip.AddrPort{unhealthy})
+	require.False(t, p.isHealthy(unhealthy.Addr()))
+	require.True(t, p.isHealthy(unreachable.Addr()))
+}
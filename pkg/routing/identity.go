@@ -0,0 +1,105 @@
+package routing
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+const identityFileName = "identity.pem"
+
+type IdentityKeyType string
+
+const (
+	IdentityKeyTypeEd25519 IdentityKeyType = "ed25519"
+	// IdentityKeyTypeECDSA generates ECDSA keys with the P-256 curve.
+	IdentityKeyTypeECDSA IdentityKeyType = "ecdsa"
+)
+
+func generatePrivateKey(keyType IdentityKeyType) (crypto.PrivKey, error) {
+	switch keyType {
+	case IdentityKeyTypeEd25519:
+		priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		return priv, err
+	case IdentityKeyTypeECDSA:
+		priv, _, err := crypto.GenerateECDSAKeyPair(rand.Reader)
+		return priv, err
+	default:
+		return nil, fmt.Errorf("unknown identity key type %s", keyType)
+	}
+}
+
+// loadOrCreatePrivateKey loads the host identity persisted in the data directory, or generates and persists a new one.
+// A persisted key is used regardless of the key type, as changing it would change the peer ID of the host.
+func loadOrCreatePrivateKey(dataDir string, keyType IdentityKeyType) (crypto.PrivKey, error) {
+	p := filepath.Join(dataDir, identityFileName)
+	b, err := os.ReadFile(p)
+	if err == nil {
+		return parsePrivateKey(b)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	priv, err := generatePrivateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	b, err = marshalPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	// Write to a temporary file first so that a partially written key is never read.
+	err = os.WriteFile(p+".tmp", b, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(p+".tmp", p)
+	if err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+func marshalPrivateKey(priv crypto.PrivKey) ([]byte, error) {
+	stdKey, err := crypto.PrivKeyToStdKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	// PKCS #8 only accepts Ed25519 keys by value.
+	if k, ok := stdKey.(*ed25519.PrivateKey); ok {
+		stdKey = *k
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(stdKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal identity key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func parsePrivateKey(b []byte) (crypto.PrivKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("identity key is not a PEM encoded private key")
+	}
+	stdKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse identity key: %w", err)
+	}
+	switch k := stdKey.(type) {
+	case ed25519.PrivateKey:
+		return crypto.UnmarshalEd25519PrivateKey(k)
+	case *ecdsa.PrivateKey:
+		priv, _, err := crypto.ECDSAKeyPairFromKey(k)
+		return priv, err
+	default:
+		return nil, fmt.Errorf("unsupported identity key type %T", stdKey)
+	}
+}
@@ -0,0 +1,56 @@
+package routing
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrCreatePrivateKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expectedType crypto.PrivKey
+		keyType      IdentityKeyType
+	}{
+		{
+			keyType:      IdentityKeyTypeEd25519,
+			expectedType: &crypto.Ed25519PrivateKey{},
+		},
+		{
+			keyType:      IdentityKeyTypeECDSA,
+			expectedType: &crypto.ECDSAPrivateKey{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.keyType), func(t *testing.T) {
+			t.Parallel()
+
+			dataDir := t.TempDir()
+			priv, err := loadOrCreatePrivateKey(dataDir, tt.keyType)
+			require.NoError(t, err)
+			require.IsType(t, tt.expectedType, priv)
+
+			// The persisted key is loaded, regardless of the configured type.
+			loaded, err := loadOrCreatePrivateKey(dataDir, "")
+			require.NoError(t, err)
+			require.True(t, priv.Equals(loaded))
+		})
+	}
+}
+
+func TestLoadOrCreatePrivateKeyErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := loadOrCreatePrivateKey(t.TempDir(), "rsa")
+	require.EqualError(t, err, "unknown identity key type rsa")
+
+	dataDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dataDir, identityFileName), []byte("foo"), 0o600)
+	require.NoError(t, err)
+	_, err = loadOrCreatePrivateKey(dataDir, IdentityKeyTypeEd25519)
+	require.EqualError(t, err, "identity key is not a PEM encoded private key")
+}
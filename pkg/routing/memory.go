@@ -51,6 +51,44 @@ func (m *MemoryRouter) Advertise(ctx context.Context, keys []string) error {
 	return nil
 }
 
+func (m *MemoryRouter) Withdraw(ctx context.Context, keys []string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	for _, key := range keys {
+		peers := []netip.AddrPort{}
+		for _, peer := range m.resolver[key] {
+			if peer == m.self {
+				continue
+			}
+			peers = append(peers, peer)
+		}
+		if len(peers) == 0 {
+			delete(m.resolver, key)
+			continue
+		}
+		m.resolver[key] = peers
+	}
+	return nil
+}
+
+func (m *MemoryRouter) Status(ctx context.Context) (Status, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	status := Status{}
+	peers := map[netip.AddrPort]interface{}{}
+	for _, v := range m.resolver {
+		for _, peer := range v {
+			if peer == m.self {
+				status.AdvertisedKeys++
+				continue
+			}
+			peers[peer] = nil
+		}
+	}
+	status.Peers = len(peers)
+	return status, nil
+}
+
 func (m *MemoryRouter) Add(key string, ap netip.AddrPort) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
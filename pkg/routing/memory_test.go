@@ -31,4 +31,21 @@ func TestMemoryRouter(t *testing.T) {
 		peers = append(peers, peer)
 	}
 	require.Len(t, peers, 2)
+
+	status, err := r.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, status.AdvertisedKeys)
+	require.Equal(t, 1, status.Peers)
+
+	err = r.Withdraw(ctx, []string{"foo"})
+	require.NoError(t, err)
+	peers, ok := r.Lookup("foo")
+	require.True(t, ok)
+	require.Equal(t, []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:9090")}, peers)
+	err = r.Advertise(ctx, []string{"bar"})
+	require.NoError(t, err)
+	err = r.Withdraw(ctx, []string{"bar"})
+	require.NoError(t, err)
+	_, ok = r.Lookup("bar")
+	require.False(t, ok)
 }
@@ -2,21 +2,29 @@ package routing
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	cid "github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 	mc "github.com/multiformats/go-multicodec"
@@ -26,17 +34,176 @@ import (
 	"github.com/spegel-org/spegel/pkg/metrics"
 )
 
-const KeyTTL = 10 * time.Minute
+const (
+	advertisedFileName = "advertised.json"
+	KeyTTL             = 10 * time.Minute
+	// Delay before advertised keys are persisted, so that advertising many images results in a single write.
+	advertisedPersistDelay = 10 * time.Second
+	// DefaultReprovideInterval gives a margin for keys to be reprovided before they expire.
+	DefaultReprovideInterval = KeyTTL - time.Minute
+)
+
+type AddressFamilyPreference string
+
+const (
+	AddressFamilyIPv6 AddressFamilyPreference = "ipv6"
+	AddressFamilyIPv4 AddressFamilyPreference = "ipv4"
+	// AddressFamilyAuto selects the first non loopback address regardless of address family.
+	AddressFamilyAuto AddressFamilyPreference = "auto"
+)
 
 type P2PRouter struct {
-	bootstrapper Bootstrapper
-	host         host.Host
-	kdht         *dht.IpfsDHT
-	rd           *routing.RoutingDiscovery
-	registryPort uint16
+	lastBootstrap     time.Time
+	bootstrapper      Bootstrapper
+	blocklist         *Blocklist
+	health            *peerHealth
+	host              host.Host
+	kdht              *dht.IpfsDHT
+	rd                *routing.RoutingDiscovery
+	federationDHT     *dht.IpfsDHT
+	federationRD      *routing.RoutingDiscovery
+	advertised        map[string]time.Time
+	restored          map[string]time.Time
+	persistCh         chan struct{}
+	dataDir           string
+	mx                sync.RWMutex
+	reprovideInterval time.Duration
+	healthInterval    time.Duration
+	registryPort      uint16
+}
+
+type p2pConfig struct {
+	blocklist         *Blocklist
+	familyPreference  AddressFamilyPreference
+	dataDir           string
+	protocolPrefix    string
+	federationPrefix  string
+	identityKeyType   IdentityKeyType
+	libp2pOpts        []libp2p.Option
+	federationPeers   []peer.AddrInfo
+	advertiseCIDR     netip.Prefix
+	reprovideInterval time.Duration
+	healthInterval    time.Duration
+}
+
+type P2PRouterOption func(*p2pConfig)
+
+func WithLibP2POptions(opts ...libp2p.Option) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.libp2pOpts = append(cfg.libp2pOpts, opts...)
+	}
 }
 
-func NewP2PRouter(ctx context.Context, addr string, bootstrapper Bootstrapper, registryPortStr string, opts ...libp2p.Option) (*P2PRouter, error) {
+// WithPeerBlocklist sets a blocklist of peer IPs which will never be returned when resolving.
+func WithPeerBlocklist(blocklist *Blocklist) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.blocklist = blocklist
+	}
+}
+
+// WithAddressFamilyPreference sets which address family is advertised when the host has both IPv6 and IPv4 addresses.
+func WithAddressFamilyPreference(pref AddressFamilyPreference) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.familyPreference = pref
+	}
+}
+
+// WithReprovideInterval sets the interval at which keys are expected to be reprovided. It has to be shorter
+// than the key TTL so that provider records do not expire between reprovides.
+func WithReprovideInterval(d time.Duration) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.reprovideInterval = d
+	}
+}
+
+// WithAdvertiseCIDR restricts the advertised host address to addresses within the CIDR.
+// Useful on hosts with multiple network interfaces where only some are reachable by peers.
+func WithAdvertiseCIDR(cidr netip.Prefix) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.advertiseCIDR = cidr
+	}
+}
+
+// WithProtocolPrefix sets the protocol prefix used by the DHT. Clusters sharing a network can use different
+// prefixes to stop them from discovering each others peers.
+func WithProtocolPrefix(prefix string) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.protocolPrefix = prefix
+	}
+}
+
+// WithFederation publishes keys to and resolves keys from a second DHT shared by multiple clusters. The federation
+// DHT uses its own protocol prefix and bootstrap peers, and is only queried when no peer in the cluster provides a key.
+// The federation lookup starts when the in-cluster lookup ends without providers and gets a timeout of its own, equal
+// to the timeout of the resolve.
+func WithFederation(prefix string, peers []peer.AddrInfo) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.federationPrefix = prefix
+		cfg.federationPeers = peers
+	}
+}
+
+// WithSwarmKey makes the host part of a private network where only peers with the same pre-shared key can connect.
+// Private networks are only supported by the TCP transport, so other transports are disabled.
+func WithSwarmKey(psk []byte) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.libp2pOpts = append(cfg.libp2pOpts, libp2p.PrivateNetwork(psk), libp2p.Transport(tcp.NewTCPTransport))
+	}
+}
+
+// WithPeerHealthCheck probes the registry of connected peers at the interval. Peers which fail the probe are
+// not returned when resolving until they pass a later probe.
+func WithPeerHealthCheck(interval time.Duration) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.healthInterval = interval
+	}
+}
+
+// WithIdentityKeyType sets the type of key generated for the host identity.
+func WithIdentityKeyType(keyType IdentityKeyType) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.identityKeyType = keyType
+	}
+}
+
+// WithDataDir persists the host identity and the advertised keys in the directory. Keys restored after a restart are
+// not provided again while their provider records are still valid, avoiding a burst of writes to the DHT on startup.
+func WithDataDir(dir string) P2PRouterOption {
+	return func(cfg *p2pConfig) {
+		cfg.dataDir = dir
+	}
+}
+
+func NewP2PRouter(ctx context.Context, addr string, bootstrapper Bootstrapper, registryPortStr string, opts ...P2PRouterOption) (*P2PRouter, error) {
+	cfg := p2pConfig{
+		familyPreference:  AddressFamilyIPv6,
+		reprovideInterval: DefaultReprovideInterval,
+		protocolPrefix:    "/spegel",
+		identityKeyType:   IdentityKeyTypeEd25519,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	switch cfg.familyPreference {
+	case AddressFamilyIPv6, AddressFamilyIPv4, AddressFamilyAuto:
+	default:
+		return nil, fmt.Errorf("unknown address family preference %s", cfg.familyPreference)
+	}
+	if cfg.reprovideInterval <= 0 || cfg.reprovideInterval >= KeyTTL {
+		return nil, fmt.Errorf("reprovide interval %s has to be greater than zero and less than key TTL %s", cfg.reprovideInterval, KeyTTL)
+	}
+	if !strings.HasPrefix(cfg.protocolPrefix, "/") {
+		return nil, fmt.Errorf("protocol prefix %s has to start with /", cfg.protocolPrefix)
+	}
+	if len(cfg.federationPeers) > 0 {
+		if !strings.HasPrefix(cfg.federationPrefix, "/") {
+			return nil, fmt.Errorf("federation protocol prefix %s has to start with /", cfg.federationPrefix)
+		}
+		if cfg.federationPrefix == cfg.protocolPrefix {
+			return nil, fmt.Errorf("federation protocol prefix %s has to differ from the protocol prefix", cfg.federationPrefix)
+		}
+	}
+
 	registryPort, err := strconv.ParseUint(registryPortStr, 10, 16)
 	if err != nil {
 		return nil, err
@@ -47,31 +214,31 @@ func NewP2PRouter(ctx context.Context, addr string, bootstrapper Bootstrapper, r
 		return nil, err
 	}
 	addrFactoryOpt := libp2p.AddrsFactory(func(addrs []ma.Multiaddr) []ma.Multiaddr {
-		var ip4Ma, ip6Ma ma.Multiaddr
-		for _, addr := range addrs {
-			if manet.IsIPLoopback(addr) {
-				continue
-			}
-			if isIp6(addr) {
-				ip6Ma = addr
-				continue
-			}
-			ip4Ma = addr
-		}
-		if ip6Ma != nil {
-			return []ma.Multiaddr{ip6Ma}
-		}
-		if ip4Ma != nil {
-			return []ma.Multiaddr{ip4Ma}
+		if cfg.advertiseCIDR.IsValid() {
+			addrs = filterAddrsByCIDR(addrs, cfg.advertiseCIDR)
 		}
-		return nil
+		return selectAddr(addrs, cfg.familyPreference)
 	})
-	opts = append(opts,
+	var priv crypto.PrivKey
+	if cfg.dataDir != "" {
+		err = os.MkdirAll(cfg.dataDir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+		priv, err = loadOrCreatePrivateKey(cfg.dataDir, cfg.identityKeyType)
+	} else {
+		priv, err = generatePrivateKey(cfg.identityKeyType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get host identity: %w", err)
+	}
+	cfg.libp2pOpts = append(cfg.libp2pOpts,
+		libp2p.Identity(priv),
 		libp2p.ListenAddrs(multiAddrs...),
 		libp2p.PrometheusRegisterer(metrics.DefaultRegisterer),
 		addrFactoryOpt,
 	)
-	host, err := libp2p.New(opts...)
+	host, err := libp2p.New(cfg.libp2pOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("could not create host: %w", err)
 	}
@@ -98,7 +265,7 @@ func NewP2PRouter(ctx context.Context, addr string, bootstrapper Bootstrapper, r
 	})
 	dhtOpts := []dht.Option{
 		dht.Mode(dht.ModeServer),
-		dht.ProtocolPrefix("/spegel"),
+		dht.ProtocolPrefix(protocol.ID(cfg.protocolPrefix)),
 		dht.DisableValues(),
 		dht.MaxRecordAge(KeyTTL),
 		bootstrapPeerOpt,
@@ -108,13 +275,54 @@ func NewP2PRouter(ctx context.Context, addr string, bootstrapper Bootstrapper, r
 		return nil, fmt.Errorf("could not create distributed hash table: %w", err)
 	}
 	rd := routing.NewRoutingDiscovery(kdht)
+	var federationDHT *dht.IpfsDHT
+	var federationRD *routing.RoutingDiscovery
+	if len(cfg.federationPeers) > 0 {
+		federationOpts := []dht.Option{
+			dht.Mode(dht.ModeServer),
+			dht.ProtocolPrefix(protocol.ID(cfg.federationPrefix)),
+			dht.DisableValues(),
+			dht.MaxRecordAge(KeyTTL),
+			dht.BootstrapPeers(cfg.federationPeers...),
+		}
+		federationDHT, err = dht.New(ctx, host, federationOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("could not create federation distributed hash table: %w", err)
+		}
+		federationRD = routing.NewRoutingDiscovery(federationDHT)
+	}
+
+	restored := map[string]time.Time{}
+	var persistCh chan struct{}
+	if cfg.dataDir != "" {
+		restored, err = loadAdvertised(cfg.dataDir)
+		if err != nil {
+			return nil, err
+		}
+		persistCh = make(chan struct{}, 1)
+	}
+
+	var health *peerHealth
+	if cfg.healthInterval > 0 {
+		health = newPeerHealth()
+	}
 
 	return &P2PRouter{
-		bootstrapper: bootstrapper,
-		host:         host,
-		kdht:         kdht,
-		rd:           rd,
-		registryPort: uint16(registryPort),
+		health:            health,
+		healthInterval:    cfg.healthInterval,
+		bootstrapper:      bootstrapper,
+		blocklist:         cfg.blocklist,
+		host:              host,
+		kdht:              kdht,
+		rd:                rd,
+		federationDHT:     federationDHT,
+		federationRD:      federationRD,
+		advertised:        map[string]time.Time{},
+		restored:          restored,
+		persistCh:         persistCh,
+		dataDir:           cfg.dataDir,
+		reprovideInterval: cfg.reprovideInterval,
+		registryPort:      uint16(registryPort),
 	}, nil
 }
 
@@ -124,6 +332,18 @@ func (r *P2PRouter) Run(ctx context.Context) error {
 	if err := r.kdht.Bootstrap(ctx); err != nil {
 		return fmt.Errorf("could not boostrap distributed hash table: %w", err)
 	}
+	r.setLastBootstrap()
+	if r.federationDHT != nil {
+		if err := r.federationDHT.Bootstrap(ctx); err != nil {
+			return fmt.Errorf("could not bootstrap federation distributed hash table: %w", err)
+		}
+	}
+	if r.health != nil {
+		go r.runHealthChecks(ctx)
+	}
+	if r.persistCh != nil {
+		go r.runPersist(ctx)
+	}
 	err := r.bootstrapper.Run(ctx, self)
 	if err != nil {
 		return err
@@ -131,8 +351,55 @@ func (r *P2PRouter) Run(ctx context.Context) error {
 	return nil
 }
 
+func (r *P2PRouter) runHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(r.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			peers := []netip.AddrPort{}
+			for _, p := range r.host.Network().Peers() {
+				addrs := r.host.Peerstore().Addrs(p)
+				if len(addrs) == 0 {
+					continue
+				}
+				ipAddr, err := ipInMultiaddr(addrs[0])
+				if err != nil {
+					continue
+				}
+				peers = append(peers, netip.AddrPortFrom(ipAddr, r.registryPort))
+			}
+			r.health.check(ctx, peers)
+		}
+	}
+}
+
+// Bootstrap connects to the bootstrap peer and refreshes the routing table, returning the resulting routing table size.
+func (r *P2PRouter) Bootstrap(ctx context.Context) (int, error) {
+	err := r.kdht.Bootstrap(ctx)
+	if err != nil {
+		return 0, err
+	}
+	r.setLastBootstrap()
+	select {
+	case <-ctx.Done():
+		return r.kdht.RoutingTable().Size(), ctx.Err()
+	case err := <-r.kdht.RefreshRoutingTable():
+		return r.kdht.RoutingTable().Size(), err
+	}
+}
+
+// Close stops the DHTs before closing the host they depend on. Provider records of advertised keys can not be
+// removed from the DHT, so peers will keep resolving this host until the records expire after the key TTL.
 func (r *P2PRouter) Close() error {
-	return r.host.Close()
+	errs := []error{}
+	if r.federationDHT != nil {
+		errs = append(errs, r.federationDHT.Close())
+	}
+	errs = append(errs, r.kdht.Close(), r.host.Close())
+	return errors.Join(errs...)
 }
 
 func (r *P2PRouter) Ready(ctx context.Context) (bool, error) {
@@ -148,6 +415,7 @@ func (r *P2PRouter) Ready(ctx context.Context) (bool, error) {
 		if err != nil {
 			return false, err
 		}
+		r.setLastBootstrap()
 		return false, nil
 	}
 	return true, nil
@@ -165,56 +433,318 @@ func (r *P2PRouter) Resolve(ctx context.Context, key string, allowSelf bool, cou
 	if peerBufferSize == 0 {
 		peerBufferSize = 20
 	}
+	timeout := time.Duration(0)
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
 	addrCh := r.rd.FindProvidersAsync(ctx, c, count)
 	peerCh := make(chan netip.AddrPort, peerBufferSize)
 	go func() {
 		resolveTimer := prometheus.NewTimer(metrics.ResolveDurHistogram.WithLabelValues("libp2p"))
-		for info := range addrCh {
-			resolveTimer.ObserveDuration()
-			if !allowSelf && info.ID == r.host.ID() {
-				continue
-			}
-			if len(info.Addrs) != 1 {
-				addrs := []string{}
-				for _, addr := range info.Addrs {
-					addrs = append(addrs, addr.String())
-				}
-				log.Info("expected address list to only contain a single item", "addresses", strings.Join(addrs, ", "))
-				continue
-			}
-			ipAddr, err := ipInMultiaddr(info.Addrs[0])
-			if err != nil {
-				log.Error(err, "could not get IP address")
-				continue
-			}
-			peer := netip.AddrPortFrom(ipAddr, r.registryPort)
-			// Don't block if the client has disconnected before reading all values from the channel
-			select {
-			case peerCh <- peer:
-			default:
-				log.V(4).Info("mirror endpoint dropped: peer channel is full")
+		sent := r.sendProviders(log, addrCh, peerCh, allowSelf, resolveTimer)
+		// Peers in other clusters are only used when no peer in the cluster provides the key.
+		if sent == 0 && r.federationRD != nil {
+			federationCtx, cancel, ok := federationContext(ctx, timeout)
+			if ok {
+				log.V(4).Info("resolving key in federation")
+				federationTimer := prometheus.NewTimer(metrics.ResolveDurHistogram.WithLabelValues("libp2p-federation"))
+				r.sendProviders(log, r.federationRD.FindProvidersAsync(federationCtx, c, count), peerCh, allowSelf, federationTimer)
 			}
+			cancel()
 		}
 		close(peerCh)
 	}()
 	return peerCh, nil
 }
 
+// federationContext returns the context for the federation lookup, which starts after the in-cluster lookup has ended.
+// The in-cluster lookup usually ends when the resolve reaches its deadline, in which case the federation lookup gets a new
+// deadline after the timeout. Returns false if the resolve was cancelled or the timeout is unknown.
+func federationContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc, bool) {
+	switch {
+	case ctx.Err() == nil:
+		federationCtx, cancel := context.WithCancel(ctx)
+		return federationCtx, cancel, true
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) && timeout > 0:
+		federationCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		return federationCtx, cancel, true
+	default:
+		return nil, func() {}, false
+	}
+}
+
+// sendProviders writes the registry address of each usable provider to the peer channel and returns the amount written.
+func (r *P2PRouter) sendProviders(log logr.Logger, addrCh <-chan peer.AddrInfo, peerCh chan<- netip.AddrPort, allowSelf bool, resolveTimer *prometheus.Timer) int {
+	sent := 0
+	for info := range addrCh {
+		resolveTimer.ObserveDuration()
+		if !allowSelf && info.ID == r.host.ID() {
+			continue
+		}
+		if len(info.Addrs) != 1 {
+			addrs := []string{}
+			for _, addr := range info.Addrs {
+				addrs = append(addrs, addr.String())
+			}
+			log.Info("expected address list to only contain a single item", "addresses", strings.Join(addrs, ", "))
+			continue
+		}
+		ipAddr, err := ipInMultiaddr(info.Addrs[0])
+		if err != nil {
+			log.Error(err, "could not get IP address")
+			continue
+		}
+		if r.blocklist.Contains(ipAddr) {
+			log.V(4).Info("skipping blocklisted peer", "ip", ipAddr.String())
+			continue
+		}
+		if !r.health.isHealthy(ipAddr) {
+			log.V(4).Info("skipping unhealthy peer", "ip", ipAddr.String())
+			continue
+		}
+		peer := netip.AddrPortFrom(ipAddr, r.registryPort)
+		// Don't block if the client has disconnected before reading all values from the channel
+		select {
+		case peerCh <- peer:
+			sent++
+		default:
+			log.V(4).Info("mirror endpoint dropped: peer channel is full")
+		}
+	}
+	return sent
+}
+
 func (r *P2PRouter) Advertise(ctx context.Context, keys []string) error {
-	logr.FromContextOrDiscard(ctx).V(4).Info("advertising keys", "host", r.host.ID().String(), "keys", keys)
+	log := logr.FromContextOrDiscard(ctx)
+	log.V(4).Info("advertising keys", "host", r.host.ID().String(), "keys", keys)
+	defer r.updateAdvertisedMetric()
 	for _, key := range keys {
+		if t, ok := r.restoredAt(key); ok {
+			r.mx.Lock()
+			r.advertised[key] = t
+			r.mx.Unlock()
+			continue
+		}
 		c, err := createCid(key)
 		if err != nil {
 			return err
 		}
 		err = r.rd.Provide(ctx, c, false)
 		if err != nil {
+			metrics.AdvertiseFailuresTotal.Inc()
+			log.Error(err, "could not advertise key", "key", key)
 			return err
 		}
+		metrics.AdvertiseTotal.Inc()
+		// Failing to advertise to other clusters does not stop peers in the cluster from finding the key.
+		if r.federationRD != nil {
+			err = r.federationRD.Provide(ctx, c, false)
+			if err != nil {
+				log.Error(err, "could not advertise key to federation", "key", key)
+			}
+		}
+		r.mx.Lock()
+		r.advertised[key] = time.Now()
+		r.mx.Unlock()
+	}
+	r.schedulePersist()
+	return nil
+}
+
+// restoredAt returns the time a restored key was advertised if its provider record will remain valid until the next reprovide.
+func (r *P2PRouter) restoredAt(key string) (time.Time, bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	t, ok := r.restored[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	delete(r.restored, key)
+	if time.Since(t) >= KeyTTL-r.reprovideInterval {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// schedulePersist requests the advertised keys to be persisted without blocking.
+func (r *P2PRouter) schedulePersist() {
+	if r.persistCh == nil {
+		return
+	}
+	select {
+	case r.persistCh <- struct{}{}:
+	default:
+	}
+}
+
+// runPersist persists the advertised keys after the persist delay when requested, and a final time when stopped.
+func (r *P2PRouter) runPersist(ctx context.Context) {
+	log := logr.FromContextOrDiscard(ctx).WithName("p2p")
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.persistAdvertised(); err != nil {
+				log.Error(err, "could not persist advertised keys")
+			}
+			return
+		case <-r.persistCh:
+		}
+		timer := time.NewTimer(advertisedPersistDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		if err := r.persistAdvertised(); err != nil {
+			log.Error(err, "could not persist advertised keys")
+		}
+	}
+}
+
+// persistAdvertised writes the advertised keys to the data directory, leaving out keys whose records have expired.
+func (r *P2PRouter) persistAdvertised() error {
+	if r.dataDir == "" {
+		return nil
+	}
+	r.mx.RLock()
+	advertised := make(map[string]time.Time, len(r.advertised))
+	for k, v := range r.advertised {
+		if time.Since(v) >= KeyTTL {
+			continue
+		}
+		advertised[k] = v
+	}
+	r.mx.RUnlock()
+	b, err := json.Marshal(advertised)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a partially written file is never read.
+	p := filepath.Join(r.dataDir, advertisedFileName)
+	err = os.WriteFile(p+".tmp", b, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+func loadAdvertised(dataDir string) (map[string]time.Time, error) {
+	advertised := map[string]time.Time{}
+	b, err := os.ReadFile(filepath.Join(dataDir, advertisedFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return advertised, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &advertised)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse persisted advertised keys: %w", err)
+	}
+	return advertised, nil
+}
+
+// Withdraw stops tracking the keys as advertised. The DHT does not have a way to remove provider records,
+// so existing records will remain until they expire after the key TTL.
+func (r *P2PRouter) Withdraw(ctx context.Context, keys []string) error {
+	logr.FromContextOrDiscard(ctx).V(4).Info("withdrawing keys", "host", r.host.ID().String(), "keys", keys)
+	r.mx.Lock()
+	for _, key := range keys {
+		delete(r.advertised, key)
+		delete(r.restored, key)
 	}
+	r.mx.Unlock()
+	r.updateAdvertisedMetric()
+	r.schedulePersist()
 	return nil
 }
 
+func (r *P2PRouter) Status(ctx context.Context) (Status, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	// Keys which have not been advertised within the TTL are no longer provided.
+	for k, v := range r.advertised {
+		if time.Since(v) < KeyTTL {
+			continue
+		}
+		delete(r.advertised, k)
+	}
+	status := Status{
+		LastBootstrap:     r.lastBootstrap,
+		AdvertisedKeys:    len(r.advertised),
+		Peers:             len(r.host.Network().Peers()),
+		RecordTTL:         KeyTTL,
+		ReprovideInterval: r.reprovideInterval,
+	}
+	return status, nil
+}
+
+// AdvertisedKeys returns the sorted keys which the router believes it is providing.
+func (r *P2PRouter) AdvertisedKeys() []string {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	keys := []string{}
+	for k, v := range r.advertised {
+		if time.Since(v) >= KeyTTL {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Topology returns the host with its connected peers, sorted by ID, and the amount of advertised keys.
+func (r *P2PRouter) Topology() Topology {
+	peers := []Peer{}
+	for _, p := range r.host.Network().Peers() {
+		peers = append(peers, Peer{
+			ID:    p.String(),
+			Addrs: multiaddrStrings(r.host.Peerstore().Addrs(p)),
+		})
+	}
+	slices.SortFunc(peers, func(a, b Peer) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return Topology{
+		ID:             r.host.ID().String(),
+		Addrs:          multiaddrStrings(r.host.Addrs()),
+		Peers:          peers,
+		AdvertisedKeys: len(r.AdvertisedKeys()),
+	}
+}
+
+// Providers returns all providers of the key found in the DHT, including the host itself.
+func (r *P2PRouter) Providers(ctx context.Context, key string) ([]peer.AddrInfo, error) {
+	c, err := createCid(key)
+	if err != nil {
+		return nil, err
+	}
+	infos := []peer.AddrInfo{}
+	for info := range r.rd.FindProvidersAsync(ctx, c, 0) {
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (r *P2PRouter) updateAdvertisedMetric() {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	count := 0
+	for _, v := range r.advertised {
+		if time.Since(v) < KeyTTL {
+			count++
+		}
+	}
+	metrics.RouterAdvertisedKeys.Set(float64(count))
+}
+
+func (r *P2PRouter) setLastBootstrap() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.lastBootstrap = time.Now()
+}
+
 func listenMultiaddrs(addr string) ([]ma.Multiaddr, error) {
 	h, p, err := net.SplitHostPort(addr)
 	if err != nil {
@@ -267,6 +797,53 @@ func ipInMultiaddr(multiAddr ma.Multiaddr) (netip.Addr, error) {
 	return netip.Addr{}, errors.New("IP not found in address")
 }
 
+// filterAddrsByCIDR returns the addresses with an IP within the CIDR.
+func filterAddrsByCIDR(addrs []ma.Multiaddr, cidr netip.Prefix) []ma.Multiaddr {
+	filtered := []ma.Multiaddr{}
+	for _, addr := range addrs {
+		ip, err := ipInMultiaddr(addr)
+		if err != nil {
+			continue
+		}
+		if !cidr.Contains(ip.Unmap()) {
+			continue
+		}
+		filtered = append(filtered, addr)
+	}
+	return filtered
+}
+
+// selectAddr returns the single non loopback address to advertise based on the address family preference.
+func selectAddr(addrs []ma.Multiaddr, pref AddressFamilyPreference) []ma.Multiaddr {
+	var firstMa, ip4Ma, ip6Ma ma.Multiaddr
+	for _, addr := range addrs {
+		if manet.IsIPLoopback(addr) {
+			continue
+		}
+		if firstMa == nil {
+			firstMa = addr
+		}
+		if isIp6(addr) {
+			ip6Ma = addr
+			continue
+		}
+		ip4Ma = addr
+	}
+	preferred := []ma.Multiaddr{ip6Ma, ip4Ma}
+	switch pref {
+	case AddressFamilyIPv4:
+		preferred = []ma.Multiaddr{ip4Ma, ip6Ma}
+	case AddressFamilyAuto:
+		preferred = []ma.Multiaddr{firstMa}
+	}
+	for _, addr := range preferred {
+		if addr != nil {
+			return []ma.Multiaddr{addr}
+		}
+	}
+	return nil
+}
+
 func isIp6(m ma.Multiaddr) bool {
 	c, _ := ma.SplitFirst(m)
 	if c == nil || c.Protocol().Code != ma.P_IP6 {
@@ -288,3 +865,11 @@ func createCid(key string) (cid.Cid, error) {
 	}
 	return c, nil
 }
+
+func multiaddrStrings(addrs []ma.Multiaddr) []string {
+	strs := []string{}
+	for _, addr := range addrs {
+		strs = append(strs, addr.String())
+	}
+	return strs
+}
@@ -1,9 +1,15 @@
 package routing
 
 import (
+	"context"
+	"net"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
 )
@@ -88,3 +94,262 @@ func TestIsIp6(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, isIp6(m))
 }
+
+func TestSelectAddr(t *testing.T) {
+	t.Parallel()
+
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/127.0.0.1/tcp/5001"),
+		ma.StringCast("/ip4/10.244.1.2/tcp/5001"),
+		ma.StringCast("/ip6/::1/tcp/5001"),
+		ma.StringCast("/ip6/fd00::2/tcp/5001"),
+	}
+
+	tests := []struct {
+		name     string
+		pref     AddressFamilyPreference
+		addrs    []ma.Multiaddr
+		expected []ma.Multiaddr
+	}{
+		{
+			name:     "prefer ipv6",
+			pref:     AddressFamilyIPv6,
+			addrs:    addrs,
+			expected: []ma.Multiaddr{ma.StringCast("/ip6/fd00::2/tcp/5001")},
+		},
+		{
+			name:     "prefer ipv4",
+			pref:     AddressFamilyIPv4,
+			addrs:    addrs,
+			expected: []ma.Multiaddr{ma.StringCast("/ip4/10.244.1.2/tcp/5001")},
+		},
+		{
+			name:     "auto",
+			pref:     AddressFamilyAuto,
+			addrs:    []ma.Multiaddr{addrs[3], addrs[1]},
+			expected: []ma.Multiaddr{ma.StringCast("/ip6/fd00::2/tcp/5001")},
+		},
+		{
+			name:     "prefer ipv4 with only ipv6",
+			pref:     AddressFamilyIPv4,
+			addrs:    addrs[2:],
+			expected: []ma.Multiaddr{ma.StringCast("/ip6/fd00::2/tcp/5001")},
+		},
+		{
+			name:     "only loopback",
+			pref:     AddressFamilyIPv6,
+			addrs:    []ma.Multiaddr{addrs[0], addrs[2]},
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expected, selectAddr(tt.addrs, tt.pref))
+		})
+	}
+}
+
+func TestNewP2PRouterReprovideInterval(t *testing.T) {
+	t.Parallel()
+
+	for _, d := range []time.Duration{0, KeyTTL, KeyTTL + time.Minute} {
+		_, err := NewP2PRouter(context.Background(), ":0", nil, "5000", WithReprovideInterval(d))
+		require.Error(t, err)
+	}
+}
+
+func TestNewP2PRouterProtocolPrefix(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewP2PRouter(context.Background(), ":0", nil, "5000", WithProtocolPrefix("spegel"))
+	require.EqualError(t, err, "protocol prefix spegel has to start with /")
+}
+
+func TestNewP2PRouterFederation(t *testing.T) {
+	t.Parallel()
+
+	peers, err := peer.AddrInfosFromP2pAddrs(ma.StringCast("/ip4/10.0.0.1/tcp/5001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"))
+	require.NoError(t, err)
+	_, err = NewP2PRouter(context.Background(), ":0", nil, "5000", WithFederation("spegel-federation", peers))
+	require.EqualError(t, err, "federation protocol prefix spegel-federation has to start with /")
+	_, err = NewP2PRouter(context.Background(), ":0", nil, "5000", WithFederation("/spegel", peers))
+	require.EqualError(t, err, "federation protocol prefix /spegel has to differ from the protocol prefix")
+}
+
+func TestFederationContext(t *testing.T) {
+	t.Parallel()
+
+	// Default mirror resolve timeout.
+	timeout := 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	<-ctx.Done()
+	federationCtx, federationCancel, ok := federationContext(ctx, timeout)
+	require.True(t, ok)
+	require.NoError(t, federationCtx.Err())
+	deadline, ok := federationCtx.Deadline()
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(timeout), deadline, timeout)
+	<-federationCtx.Done()
+	require.ErrorIs(t, federationCtx.Err(), context.DeadlineExceeded)
+	federationCancel()
+
+	// Lookups which ended before the deadline use the resolve context.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	federationCtx, federationCancel, ok = federationContext(ctx, time.Minute)
+	require.True(t, ok)
+	require.NoError(t, federationCtx.Err())
+	cancel()
+	require.ErrorIs(t, federationCtx.Err(), context.Canceled)
+	federationCancel()
+
+	// Cancelled resolves are not continued.
+	_, federationCancel, ok = federationContext(ctx, time.Minute)
+	require.False(t, ok)
+	federationCancel()
+}
+
+func TestPersistAdvertised(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+	restored, err := loadAdvertised(dataDir)
+	require.NoError(t, err)
+	require.Empty(t, restored)
+
+	now := time.Now()
+	r := &P2PRouter{
+		dataDir: dataDir,
+		advertised: map[string]time.Time{
+			"recent":  now,
+			"old":     now.Add(-5 * time.Minute),
+			"expired": now.Add(-KeyTTL),
+		},
+		reprovideInterval: DefaultReprovideInterval,
+	}
+	err = r.persistAdvertised()
+	require.NoError(t, err)
+	restored, err = loadAdvertised(dataDir)
+	require.NoError(t, err)
+	require.Len(t, restored, 2)
+	require.True(t, now.Equal(restored["recent"]))
+
+	r.restored = restored
+	_, ok := r.restoredAt("recent")
+	require.True(t, ok)
+	_, ok = r.restoredAt("recent")
+	require.False(t, ok)
+	_, ok = r.restoredAt("old")
+	require.False(t, ok)
+	_, ok = r.restoredAt("unknown")
+	require.False(t, ok)
+
+	// Scheduled writes are persisted when stopped.
+	err = os.Remove(filepath.Join(dataDir, advertisedFileName))
+	require.NoError(t, err)
+	r.persistCh = make(chan struct{}, 1)
+	r.schedulePersist()
+	r.schedulePersist()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.runPersist(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+	require.FileExists(t, filepath.Join(dataDir, advertisedFileName))
+}
+
+func TestFilterAddrsByCIDR(t *testing.T) {
+	t.Parallel()
+
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/192.168.1.2/tcp/5001"),
+		ma.StringCast("/ip4/10.244.1.2/tcp/5001"),
+		ma.StringCast("/ip6/fd00::2/tcp/5001"),
+	}
+	filtered := filterAddrsByCIDR(addrs, netip.MustParsePrefix("10.244.0.0/16"))
+	require.Equal(t, []ma.Multiaddr{ma.StringCast("/ip4/10.244.1.2/tcp/5001")}, filtered)
+	filtered = filterAddrsByCIDR(addrs, netip.MustParsePrefix("fd00::/64"))
+	require.Equal(t, []ma.Multiaddr{ma.StringCast("/ip6/fd00::2/tcp/5001")}, filtered)
+	filtered = filterAddrsByCIDR(addrs, netip.MustParsePrefix("172.16.0.0/12"))
+	require.Empty(t, filtered)
+}
+
+func TestP2PRouterRebootstrap(t *testing.T) {
+	t.Parallel()
+
+	// Routers only advertise non loopback addresses.
+	addrs, err := net.InterfaceAddrs()
+	require.NoError(t, err)
+	hasAddr := false
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			hasAddr = true
+			break
+		}
+	}
+	if !hasAddr {
+		t.Skip("host does not have a non loopback IPv4 address")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	bootstrapper := NewDynamicBootstrapper()
+	newRouter := func() *P2PRouter {
+		t.Helper()
+
+		router, err := NewP2PRouter(ctx, "0.0.0.0:0", bootstrapper, "5000", WithAddressFamilyPreference(AddressFamilyIPv4))
+		require.NoError(t, err)
+		//nolint:errcheck // ignore
+		go router.Run(ctx)
+		return router
+	}
+	addrInfo := func(router *P2PRouter) *peer.AddrInfo {
+		return &peer.AddrInfo{ID: router.host.ID(), Addrs: router.host.Addrs()}
+	}
+	isReady := func(router *P2PRouter) func() bool {
+		return func() bool {
+			ready, err := router.Ready(ctx)
+			return err == nil && ready
+		}
+	}
+
+	first := newRouter()
+	bootstrapper.Set(addrInfo(first))
+	require.Eventually(t, isReady(first), 5*time.Second, 50*time.Millisecond)
+	router := newRouter()
+	t.Cleanup(func() {
+		//nolint:errcheck // ignore
+		router.Close()
+	})
+	require.Eventually(t, isReady(router), 10*time.Second, 50*time.Millisecond)
+
+	// Losing all peers makes the router not ready.
+	bootstrapper.Set(nil)
+	err = first.Close()
+	require.NoError(t, err)
+	router.kdht.RoutingTable().RemovePeer(first.host.ID())
+	ready, err := router.Ready(ctx)
+	require.EqualError(t, err, "no bootstrap peer is set")
+	require.False(t, ready)
+
+	// The router bootstraps again once a peer is added.
+	second := newRouter()
+	t.Cleanup(func() {
+		//nolint:errcheck // ignore
+		second.Close()
+	})
+	bootstrapper.Set(addrInfo(second))
+	require.Eventually(t, isReady(router), 10*time.Second, 50*time.Millisecond)
+	require.Equal(t, second.host.ID(), router.kdht.RoutingTable().Find(second.host.ID()))
+}
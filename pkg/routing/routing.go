@@ -3,10 +3,35 @@ package routing
 import (
 	"context"
 	"net/netip"
+	"time"
 )
 
 type Router interface {
 	Ready(ctx context.Context) (bool, error)
 	Resolve(ctx context.Context, key string, allowSelf bool, count int) (<-chan netip.AddrPort, error)
 	Advertise(ctx context.Context, keys []string) error
+	Withdraw(ctx context.Context, keys []string) error
+	Status(ctx context.Context) (Status, error)
+}
+
+// Peer is a libp2p peer with the addresses it is known to be reachable on.
+type Peer struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+// Topology is the view of the network from a single node.
+type Topology struct {
+	ID             string   `json:"id"`
+	Addrs          []string `json:"addrs"`
+	Peers          []Peer   `json:"peers"`
+	AdvertisedKeys int      `json:"advertisedKeys"`
+}
+
+type Status struct {
+	LastBootstrap     time.Time     `json:"lastBootstrap"`
+	AdvertisedKeys    int           `json:"advertisedKeys"`
+	Peers             int           `json:"peers"`
+	RecordTTL         time.Duration `json:"recordTTL"`
+	ReprovideInterval time.Duration `json:"reprovideInterval"`
 }
@@ -2,8 +2,11 @@ package state
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand/v2"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -14,51 +17,342 @@ import (
 	"github.com/spegel-org/spegel/pkg/routing"
 )
 
-func Track(ctx context.Context, ociClient oci.Client, router routing.Router, resolveLatestTag bool) error {
+// Duration to wait between attempts to subscribe to image events after the subscription has been closed.
+const resubscribeBackoff = time.Second
+
+// Interval at which the router is checked while waiting for it to be ready before advertising.
+const routerPollInterval = time.Second
+
+// UsageTracker records when content was last served, so that images which are pulled by peers are considered used.
+type UsageTracker struct {
+	used map[string]time.Time
+	mx   sync.Mutex
+}
+
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{
+		used: map[string]time.Time{},
+	}
+}
+
+// Use records that the content with the key, either an image name or digest, was served.
+func (u *UsageTracker) Use(key string) {
+	if u == nil {
+		return
+	}
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	u.used[key] = time.Now()
+}
+
+// lastUsed returns the last time the image was served by name or by digest.
+func (u *UsageTracker) lastUsed(img oci.Image) (time.Time, bool) {
+	if u == nil {
+		return time.Time{}, false
+	}
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	nameUsed, nameOk := u.used[img.Name]
+	digestUsed, digestOk := u.used[img.Digest.String()]
+	if digestUsed.After(nameUsed) {
+		return digestUsed, true
+	}
+	return nameUsed, nameOk || digestOk
+}
+
+// prune removes keys which were last served before the max age, as they can no longer keep an image from going stale.
+func (u *UsageTracker) prune(maxAge time.Duration) {
+	if u == nil {
+		return
+	}
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	for k, t := range u.used {
+		if time.Since(t) > maxAge {
+			delete(u.used, k)
+		}
+	}
+}
+
+type config struct {
+	deleteHandler     func(oci.Image)
+	usage             *UsageTracker
+	registryAliases   oci.RegistryAliases
+	annotationKey     string
+	annotationValue   string
+	reconcileInterval time.Duration
+	reprovideInterval time.Duration
+	maxImageAge       time.Duration
+	startupSpread     time.Duration
+	advertiseGrace    time.Duration
+	minRoutingPeers   int
+	skipBlobs         bool
+	skipTags          bool
+}
+
+type Option func(*config)
+
+// WithReconcileInterval sets the interval at which advertised keys are compared with local content.
+// Keys for content which no longer exists are withdrawn. Reconciliation is disabled when zero.
+func WithReconcileInterval(reconcileInterval time.Duration) Option {
+	return func(c *config) {
+		c.reconcileInterval = reconcileInterval
+	}
+}
+
+// WithReprovideInterval sets the interval at which all keys are advertised again.
+func WithReprovideInterval(reprovideInterval time.Duration) Option {
+	return func(c *config) {
+		c.reprovideInterval = reprovideInterval
+	}
+}
+
+// WithStartupSpread delays the initial advertisement of all images by a random duration within the spread. Nodes
+// started at the same time, for example during a rollout, will then not all write to the DHT at once. The spread
+// should be less than the reprovide interval, as the first reprovide advertises all images regardless.
+func WithStartupSpread(startupSpread time.Duration) Option {
+	return func(c *config) {
+		c.startupSpread = startupSpread
+	}
+}
+
+// WithAdvertiseGrace delays advertising until the router is ready and connected to at least the minimum amount of
+// peers, and the grace period has passed after that. A node advertising before it is well connected can be found
+// by peers while lookups routed through it are unreliable. The minimum peers should be less than the amount of nodes,
+// as advertising waits until it is reached.
+func WithAdvertiseGrace(grace time.Duration, minPeers int) Option {
+	return func(c *config) {
+		c.advertiseGrace = grace
+		c.minRoutingPeers = minPeers
+	}
+}
+
+// WithMaxImageAge stops reproviding keys of images which have not been created, updated or served within the duration.
+// The provider records of those keys lapse after the key TTL, reducing DHT traffic for content which is rarely
+// pulled. Images are considered recently used when tracking starts. All images are reprovided when zero.
+func WithMaxImageAge(maxImageAge time.Duration) Option {
+	return func(c *config) {
+		c.maxImageAge = maxImageAge
+	}
+}
+
+// WithUsageTracker considers images served by the tracker as used when applying the max image age.
+func WithUsageTracker(usage *UsageTracker) Option {
+	return func(c *config) {
+		c.usage = usage
+	}
+}
+
+// WithAdvertiseBlobs controls if config and layer blob digests are advertised. When false only
+// image indexes and manifests are advertised, which should be used when blobs are not served.
+func WithAdvertiseBlobs(advertiseBlobs bool) Option {
+	return func(c *config) {
+		c.skipBlobs = !advertiseBlobs
+	}
+}
+
+// WithAdvertiseTags controls if image tags are advertised. Tags should not be advertised when they are not resolved for peers.
+func WithAdvertiseTags(advertiseTags bool) Option {
+	return func(c *config) {
+		c.skipTags = !advertiseTags
+	}
+}
+
+// WithAdvertiseAnnotation only advertises images with the annotation set on their manifest or index.
+// Any annotation value is accepted when the value is empty.
+func WithAdvertiseAnnotation(key, value string) Option {
+	return func(c *config) {
+		c.annotationKey = key
+		c.annotationValue = value
+	}
+}
+
+// WithRegistryAliases advertises tags pulled through a registry alias with the canonical registry.
+func WithRegistryAliases(aliases oci.RegistryAliases) Option {
+	return func(c *config) {
+		c.registryAliases = aliases
+	}
+}
+
+// WithDeleteHandler sets a function which is called for every deleted image.
+func WithDeleteHandler(fn func(oci.Image)) Option {
+	return func(c *config) {
+		c.deleteHandler = fn
+	}
+}
+
+func Track(ctx context.Context, ociClient oci.Client, router routing.Router, resolveLatestTag bool, opts ...Option) error {
+	cfg := config{
+		reprovideInterval: routing.DefaultReprovideInterval,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 	log := logr.FromContextOrDiscard(ctx)
+	if cfg.advertiseGrace > 0 || cfg.minRoutingPeers > 0 {
+		err := waitForRouter(ctx, router, cfg.advertiseGrace, cfg.minRoutingPeers)
+		if err != nil {
+			return nil
+		}
+	}
 	eventCh, errCh, err := ociClient.Subscribe(ctx)
 	if err != nil {
 		return err
 	}
-	immediateCh := make(chan time.Time, 1)
-	immediateCh <- time.Now()
-	close(immediateCh)
-	expirationTicker := time.NewTicker(routing.KeyTTL - time.Minute)
+	var startupCh <-chan time.Time
+	if cfg.startupSpread > 0 {
+		delay := rand.N(cfg.startupSpread)
+		log.Info("delaying initial image state update", "delay", delay)
+		startupCh = time.After(delay)
+	} else {
+		immediateCh := make(chan time.Time, 1)
+		immediateCh <- time.Now()
+		close(immediateCh)
+		startupCh = immediateCh
+	}
+	expirationTicker := time.NewTicker(cfg.reprovideInterval)
 	defer expirationTicker.Stop()
-	tickerCh := channel.Merge(immediateCh, expirationTicker.C)
+	tickerCh := channel.Merge(startupCh, expirationTicker.C)
+	var reconcileCh <-chan time.Time
+	if cfg.reconcileInterval > 0 {
+		reconcileTicker := time.NewTicker(cfg.reconcileInterval)
+		defer reconcileTicker.Stop()
+		reconcileCh = reconcileTicker.C
+	}
+	// Advertised keys are only tracked when they are reconciled, as the set grows with every image seen.
+	var advertised map[string]interface{}
+	if cfg.reconcileInterval > 0 {
+		advertised = map[string]interface{}{}
+	}
+	trackAdvertised := func(keys []string) {
+		if advertised == nil {
+			return
+		}
+		for _, key := range keys {
+			advertised[key] = nil
+		}
+	}
+	// Time at which each image was last created or updated, used to stop reproviding stale images.
+	lastUsed := map[string]time.Time{}
+	syncAll := func() {
+		keys, err := all(ctx, ociClient, router, cfg, lastUsed, resolveLatestTag)
+		trackAdvertised(keys)
+		if err != nil {
+			log.Error(err, "received errors when updating all images")
+		}
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		case <-tickerCh:
 			log.Info("running scheduled image state update")
-			if err := all(ctx, ociClient, router, resolveLatestTag); err != nil {
-				log.Error(err, "received errors when updating all images")
+			syncAll()
+		case <-reconcileCh:
+			log.Info("running scheduled image state reconcile")
+			if err := reconcile(ctx, ociClient, router, cfg, advertised, resolveLatestTag); err != nil {
+				log.Error(err, "received error when reconciling advertised keys")
 				continue
 			}
 		case event, ok := <-eventCh:
 			if !ok {
-				return errors.New("image event channel closed")
+				// The subscription is closed when the connection to the image store is lost, for example when Containerd restarts.
+				log.Info("image event channel closed, resubscribing")
+				eventCh, errCh, err = resubscribe(ctx, ociClient)
+				if err != nil {
+					return nil
+				}
+				// Events received while the subscription was closed have been missed.
+				log.Info("resubscribed to image events, running image state update")
+				syncAll()
+				continue
 			}
 			log.Info("received image event", "image", event.Image.String(), "type", event.Type)
-			if _, err := update(ctx, ociClient, router, event, false, resolveLatestTag); err != nil {
+			if event.Type == oci.DeleteEvent && cfg.deleteHandler != nil {
+				cfg.deleteHandler(event.Image)
+			}
+			if event.Type == oci.DeleteEvent {
+				delete(lastUsed, event.Image.Name)
+			} else {
+				lastUsed[event.Image.Name] = time.Now()
+			}
+			keys, err := update(ctx, ociClient, router, cfg, event, false, resolveLatestTag)
+			metrics.ImageEventsTotal.WithLabelValues(string(event.Type)).Inc()
+			if !event.Timestamp.IsZero() {
+				metrics.ImageEventLag.Set(time.Since(event.Timestamp).Seconds())
+			}
+			if err != nil {
 				log.Error(err, "received error when updating image")
 				continue
 			}
+			trackAdvertised(keys)
 		case err, ok := <-errCh:
 			if !ok {
-				return errors.New("image error channel closed")
+				// Resubscribing is done when the event channel is closed.
+				errCh = nil
+				continue
 			}
 			log.Error(err, "event channel error")
 		}
 	}
 }
 
-func all(ctx context.Context, ociClient oci.Client, router routing.Router, resolveLatestTag bool) error {
+// resubscribe retries subscribing to image events until it succeeds or the context is cancelled.
+func resubscribe(ctx context.Context, ociClient oci.Client) (<-chan oci.ImageEvent, <-chan error, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(resubscribeBackoff):
+		}
+		eventCh, errCh, err := ociClient.Subscribe(ctx)
+		if err != nil {
+			log.Error(err, "could not resubscribe to image events")
+			continue
+		}
+		return eventCh, errCh, nil
+	}
+}
+
+// waitForRouter blocks until the router is ready and connected to the minimum amount of peers, and the grace period has passed.
+// Only context errors are returned, router errors are logged and checked again.
+func waitForRouter(ctx context.Context, router routing.Router, grace time.Duration, minPeers int) error {
+	log := logr.FromContextOrDiscard(ctx)
+	log.Info("waiting for router before advertising", "minPeers", minPeers, "grace", grace)
+	for {
+		ready, err := router.Ready(ctx)
+		if err != nil {
+			log.Error(err, "could not check if router is ready")
+		}
+		if ready {
+			status, err := router.Status(ctx)
+			if err != nil {
+				log.Error(err, "could not get router status")
+			} else if status.Peers >= minPeers {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(routerPollInterval):
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(grace):
+	}
+	log.Info("router is ready, starting to advertise")
+	return nil
+}
+
+func all(ctx context.Context, ociClient oci.Client, router routing.Router, cfg config, lastUsed map[string]time.Time, resolveLatestTag bool) ([]string, error) {
 	log := logr.FromContextOrDiscard(ctx).V(4)
 	imgs, err := ociClient.ListImages(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// TODO: Update metrics on subscribed events. This will require keeping state in memory to know about key count changes.
@@ -67,20 +361,41 @@ func all(ctx context.Context, ociClient oci.Client, router routing.Router, resol
 	metrics.AdvertisedImageTags.Reset()
 	metrics.AdvertisedImageDigests.Reset()
 	errs := []error{}
+	allKeys := []string{}
 	targets := map[string]interface{}{}
+	if cfg.maxImageAge > 0 {
+		cfg.usage.prune(cfg.maxImageAge)
+	}
 	for _, img := range imgs {
+		if cfg.maxImageAge > 0 {
+			if served, ok := cfg.usage.lastUsed(img); ok && served.After(lastUsed[img.Name]) {
+				lastUsed[img.Name] = served
+			}
+			t, ok := lastUsed[img.Name]
+			if !ok {
+				lastUsed[img.Name] = time.Now()
+			} else if time.Since(t) > cfg.maxImageAge {
+				log.Info("skipping stale image", "image", img.String(), "lastUsed", t)
+				continue
+			}
+		}
 		_, skipDigests := targets[img.Digest.String()]
 		// Handle the list re-sync as update events; this will also prevent the
 		// update function from setting metrics values.
 		event := oci.ImageEvent{Image: img, Type: oci.UpdateEvent}
 		log.Info("sync image event", "image", event.Image.String(), "type", event.Type)
-		keyTotal, err := update(ctx, ociClient, router, event, skipDigests, resolveLatestTag)
+		keys, err := update(ctx, ociClient, router, cfg, event, skipDigests, resolveLatestTag)
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
+		allKeys = append(allKeys, keys...)
 		targets[img.Digest.String()] = nil
-		metrics.AdvertisedKeys.WithLabelValues(img.Registry).Add(float64(keyTotal))
+		// Images without the annotation are not advertised.
+		if cfg.annotationKey != "" && len(keys) == 0 {
+			continue
+		}
+		metrics.AdvertisedKeys.WithLabelValues(img.Registry).Add(float64(len(keys)))
 		metrics.AdvertisedImages.WithLabelValues(img.Registry).Add(1)
 		if img.Tag == "" {
 			metrics.AdvertisedImageDigests.WithLabelValues(event.Image.Registry).Add(1)
@@ -88,15 +403,13 @@ func all(ctx context.Context, ociClient oci.Client, router routing.Router, resol
 			metrics.AdvertisedImageTags.WithLabelValues(event.Image.Registry).Add(1)
 		}
 	}
-	return errors.Join(errs...)
+	return allKeys, errors.Join(errs...)
 }
 
-func update(ctx context.Context, ociClient oci.Client, router routing.Router, event oci.ImageEvent, skipDigests, resolveLatestTag bool) (int, error) {
+func update(ctx context.Context, ociClient oci.Client, router routing.Router, cfg config, event oci.ImageEvent, skipDigests, resolveLatestTag bool) ([]string, error) {
 	keys := []string{}
-	if !(!resolveLatestTag && event.Image.IsLatestTag()) {
-		if tagRef, ok := event.Image.TagName(); ok {
-			keys = append(keys, tagRef)
-		}
+	if tagRef, ok := tagKey(event.Image, cfg, resolveLatestTag); ok {
+		keys = append(keys, tagRef)
 	}
 	if event.Type == oci.DeleteEvent {
 		// We don't know how many digest keys were associated with the deleted image;
@@ -104,18 +417,27 @@ func update(ctx context.Context, ociClient oci.Client, router routing.Router, ev
 		metrics.AdvertisedImages.WithLabelValues(event.Image.Registry).Sub(1)
 		// DHT doesn't actually have any way to stop providing a key, you just have to wait for the record to expire
 		// from the datastore. Record TTL is a datastore-level value, so we can't even re-provide with a shorter TTL.
-		return 0, nil
+		return nil, nil
+	}
+	if cfg.annotationKey != "" {
+		ok, err := hasAnnotation(ctx, ociClient, event.Image, cfg.annotationKey, cfg.annotationValue)
+		if err != nil {
+			return nil, fmt.Errorf("could not get annotations for image %s: %w", event.Image.String(), err)
+		}
+		if !ok {
+			return nil, nil
+		}
 	}
 	if !skipDigests {
-		dgsts, err := ociClient.AllIdentifiers(ctx, event.Image)
+		dgsts, err := identifiers(ctx, ociClient, cfg, event.Image)
 		if err != nil {
-			return 0, fmt.Errorf("could not get digests for image %s: %w", event.Image.String(), err)
+			return nil, fmt.Errorf("could not get digests for image %s: %w", event.Image.String(), err)
 		}
 		keys = append(keys, dgsts...)
 	}
 	err := router.Advertise(ctx, keys)
 	if err != nil {
-		return 0, fmt.Errorf("could not advertise image %s: %w", event.Image.String(), err)
+		return nil, fmt.Errorf("could not advertise image %s: %w", event.Image.String(), err)
 	}
 	if event.Type == oci.CreateEvent {
 		// We don't know how many unique digest keys will be associated with the new image;
@@ -127,5 +449,87 @@ func update(ctx context.Context, ociClient oci.Client, router routing.Router, ev
 			metrics.AdvertisedImageTags.WithLabelValues(event.Image.Registry).Add(1)
 		}
 	}
-	return len(keys), nil
+	return keys, nil
+}
+
+// reconcile withdraws advertised keys which no longer belong to any local image.
+// It catches content removed without a delete event being received, for example during garbage collection.
+func reconcile(ctx context.Context, ociClient oci.Client, router routing.Router, cfg config, advertised map[string]interface{}, resolveLatestTag bool) error {
+	imgs, err := ociClient.ListImages(ctx)
+	if err != nil {
+		return err
+	}
+	current := map[string]interface{}{}
+	for _, img := range imgs {
+		if tagRef, ok := tagKey(img, cfg, resolveLatestTag); ok {
+			current[tagRef] = nil
+		}
+		// Abort on errors as keys would otherwise be withdrawn for content which still exists.
+		dgsts, err := identifiers(ctx, ociClient, cfg, img)
+		if err != nil {
+			return fmt.Errorf("could not get digests for image %s: %w", img.String(), err)
+		}
+		for _, dgst := range dgsts {
+			current[dgst] = nil
+		}
+	}
+	withdraw := []string{}
+	for key := range advertised {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		withdraw = append(withdraw, key)
+	}
+	if len(withdraw) == 0 {
+		return nil
+	}
+	err = router.Withdraw(ctx, withdraw)
+	if err != nil {
+		return err
+	}
+	for _, key := range withdraw {
+		delete(advertised, key)
+	}
+	return nil
+}
+
+func identifiers(ctx context.Context, ociClient oci.Client, cfg config, img oci.Image) ([]string, error) {
+	if cfg.skipBlobs {
+		return ociClient.ManifestIdentifiers(ctx, img)
+	}
+	return ociClient.AllIdentifiers(ctx, img)
+}
+
+// hasAnnotation checks if the manifest or index of the image has the annotation.
+func hasAnnotation(ctx context.Context, ociClient oci.Client, img oci.Image, key, value string) (bool, error) {
+	b, _, err := ociClient.GetManifest(ctx, img.Digest)
+	if err != nil {
+		return false, err
+	}
+	var doc struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return false, err
+	}
+	v, ok := doc.Annotations[key]
+	if !ok {
+		return false, nil
+	}
+	return value == "" || v == value, nil
+}
+
+func tagKey(img oci.Image, cfg config, resolveLatestTag bool) (string, bool) {
+	if cfg.skipTags {
+		return "", false
+	}
+	if !resolveLatestTag && img.IsLatestTag() {
+		return "", false
+	}
+	tagName, ok := img.TagName()
+	if !ok {
+		return "", false
+	}
+	return cfg.registryAliases.CanonicalName(tagName), true
 }
@@ -2,10 +2,14 @@ package state
 
 import (
 	"context"
+	"errors"
 	"net/netip"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/require"
 
 	"github.com/spegel-org/spegel/pkg/oci"
@@ -75,3 +79,247 @@ func TestBasic(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	img, err := oci.Parse("ghcr.io/spegel-org/spegel:v0.0.9@sha256:fa32bd3bcd49a45a62cfc1b0fed6a0b63bf8af95db5bad7ec22865aee0a4b795", "")
+	require.NoError(t, err)
+	ociClient := oci.NewMockClient([]oci.Image{img})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.MustParseAddrPort("127.0.0.1:5000"))
+
+	tagName, ok := img.TagName()
+	require.True(t, ok)
+	keys := []string{tagName, img.Digest.String(), "sha256:e80e36564e9617f684eb5972bf86dc9e9e761216e0d40ff78ca07741ec70725a"}
+	err = router.Advertise(context.TODO(), keys)
+	require.NoError(t, err)
+	advertised := map[string]interface{}{}
+	for _, key := range keys {
+		advertised[key] = nil
+	}
+
+	err = reconcile(context.TODO(), ociClient, router, config{}, advertised, true)
+	require.NoError(t, err)
+	require.Len(t, advertised, 2)
+	for _, key := range keys[:2] {
+		_, ok := router.Lookup(key)
+		require.True(t, ok)
+	}
+	_, ok = router.Lookup(keys[2])
+	require.False(t, ok)
+}
+
+func TestRegistryAliases(t *testing.T) {
+	t.Parallel()
+
+	img, err := oci.Parse("registry-1.docker.io/library/nginx:1.27@sha256:b060fffe8e1561c9c3e6dea6db487b900100fc26830b9ea2ec966c151ab4c020", "")
+	require.NoError(t, err)
+	ociClient := oci.NewMockClient([]oci.Image{img})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.MustParseAddrPort("127.0.0.1:5000"))
+	aliases, err := oci.NewRegistryAliases(nil)
+	require.NoError(t, err)
+
+	event := oci.ImageEvent{Image: img, Type: oci.CreateEvent}
+	keys, err := update(context.TODO(), ociClient, router, config{registryAliases: aliases}, event, false, true)
+	require.NoError(t, err)
+	require.Contains(t, keys, "docker.io/library/nginx:1.27")
+	_, ok := router.Lookup("docker.io/library/nginx:1.27")
+	require.True(t, ok)
+	_, ok = router.Lookup("registry-1.docker.io/library/nginx:1.27")
+	require.False(t, ok)
+}
+
+type resubscribeClient struct {
+	*oci.MockClient
+	subscriptions atomic.Int32
+}
+
+// Subscribe returns a closed subscription the first time, simulating the image store restarting.
+func (r *resubscribeClient) Subscribe(ctx context.Context) (<-chan oci.ImageEvent, <-chan error, error) {
+	eventCh := make(chan oci.ImageEvent)
+	errCh := make(chan error)
+	if r.subscriptions.Add(1) == 1 {
+		close(eventCh)
+		close(errCh)
+	}
+	return eventCh, errCh, nil
+}
+
+// ListImages only returns images after resubscribing, to verify that missed events are recovered.
+func (r *resubscribeClient) ListImages(ctx context.Context) ([]oci.Image, error) {
+	if r.subscriptions.Load() < 2 {
+		return nil, nil
+	}
+	return r.MockClient.ListImages(ctx)
+}
+
+func TestResubscribe(t *testing.T) {
+	t.Parallel()
+
+	img, err := oci.Parse("ghcr.io/spegel-org/spegel:v0.0.9@sha256:fa32bd3bcd49a45a62cfc1b0fed6a0b63bf8af95db5bad7ec22865aee0a4b795", "")
+	require.NoError(t, err)
+	ociClient := &resubscribeClient{MockClient: oci.NewMockClient([]oci.Image{img})}
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.MustParseAddrPort("127.0.0.1:5000"))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*resubscribeBackoff)
+	defer cancel()
+	err = Track(ctx, ociClient, router, true)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), ociClient.subscriptions.Load())
+	_, ok := router.Lookup(img.Digest.String())
+	require.True(t, ok)
+}
+
+func TestStartupSpread(t *testing.T) {
+	t.Parallel()
+
+	img, err := oci.Parse("ghcr.io/spegel-org/spegel:v0.0.9@sha256:fa32bd3bcd49a45a62cfc1b0fed6a0b63bf8af95db5bad7ec22865aee0a4b795", "")
+	require.NoError(t, err)
+	ociClient := oci.NewMockClient([]oci.Image{img})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.MustParseAddrPort("127.0.0.1:5000"))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Second)
+	defer cancel()
+	err = Track(ctx, ociClient, router, true, WithStartupSpread(time.Second))
+	require.NoError(t, err)
+	_, ok := router.Lookup(img.Digest.String())
+	require.True(t, ok)
+}
+
+func TestAdvertiseGrace(t *testing.T) {
+	t.Parallel()
+
+	img, err := oci.Parse("ghcr.io/spegel-org/spegel:v0.0.9@sha256:fa32bd3bcd49a45a62cfc1b0fed6a0b63bf8af95db5bad7ec22865aee0a4b795", "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name             string
+		minPeers         int
+		expectAdvertised bool
+	}{
+		{
+			name:             "enough peers",
+			minPeers:         1,
+			expectAdvertised: true,
+		},
+		{
+			name:             "too few peers",
+			minPeers:         2,
+			expectAdvertised: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ociClient := oci.NewMockClient([]oci.Image{img})
+			router := routing.NewMemoryRouter(map[string][]netip.AddrPort{
+				"foo": {netip.MustParseAddrPort("127.0.0.2:5000")},
+			}, netip.MustParseAddrPort("127.0.0.1:5000"))
+
+			ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+			defer cancel()
+			err := Track(ctx, ociClient, router, true, WithAdvertiseGrace(100*time.Millisecond, tt.minPeers))
+			require.NoError(t, err)
+			_, ok := router.Lookup(img.Digest.String())
+			require.Equal(t, tt.expectAdvertised, ok)
+		})
+	}
+}
+
+func TestMaxImageAge(t *testing.T) {
+	t.Parallel()
+
+	stale, err := oci.Parse("docker.io/library/ubuntu:latest@sha256:b060fffe8e1561c9c3e6dea6db487b900100fc26830b9ea2ec966c151ab4c020", "")
+	require.NoError(t, err)
+	fresh, err := oci.Parse("ghcr.io/spegel-org/spegel:v0.0.9@sha256:fa32bd3bcd49a45a62cfc1b0fed6a0b63bf8af95db5bad7ec22865aee0a4b795", "")
+	require.NoError(t, err)
+	unseen, err := oci.Parse("docker.io/library/alpine@sha256:25fad2a32ad1f6f510e528448ae1ec69a28ef81916a004d3629874104f8a7f70", "")
+	require.NoError(t, err)
+	ociClient := oci.NewMockClient([]oci.Image{stale, fresh, unseen})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.MustParseAddrPort("127.0.0.1:5000"))
+	lastUsed := map[string]time.Time{
+		stale.Name: time.Now().Add(-2 * time.Hour),
+		fresh.Name: time.Now(),
+	}
+
+	keys, err := all(context.TODO(), ociClient, router, config{maxImageAge: time.Hour}, lastUsed, true)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"ghcr.io/spegel-org/spegel:v0.0.9", fresh.Digest.String(), unseen.Digest.String()}, keys)
+	_, ok := router.Lookup(stale.Digest.String())
+	require.False(t, ok)
+	require.Contains(t, lastUsed, unseen.Name)
+
+	// Images served by digest are considered used.
+	usage := NewUsageTracker()
+	usage.Use(stale.Digest.String())
+	keys, err = all(context.TODO(), ociClient, router, config{maxImageAge: time.Hour, usage: usage}, lastUsed, true)
+	require.NoError(t, err)
+	require.Contains(t, keys, stale.Digest.String())
+	require.WithinDuration(t, time.Now(), lastUsed[stale.Name], time.Minute)
+}
+
+func TestAdvertiseTags(t *testing.T) {
+	t.Parallel()
+
+	img, err := oci.Parse("ghcr.io/spegel-org/spegel:v0.0.9@sha256:fa32bd3bcd49a45a62cfc1b0fed6a0b63bf8af95db5bad7ec22865aee0a4b795", "")
+	require.NoError(t, err)
+	ociClient := oci.NewMockClient([]oci.Image{img})
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.MustParseAddrPort("127.0.0.1:5000"))
+	cfg := config{}
+	WithAdvertiseTags(false)(&cfg)
+
+	event := oci.ImageEvent{Image: img, Type: oci.CreateEvent}
+	keys, err := update(context.TODO(), ociClient, router, cfg, event, false, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{img.Digest.String()}, keys)
+	_, ok := router.Lookup("ghcr.io/spegel-org/spegel:v0.0.9")
+	require.False(t, ok)
+}
+
+type manifestClient struct {
+	*oci.MockClient
+	manifests map[string][]byte
+}
+
+func (m *manifestClient) GetManifest(ctx context.Context, dgst digest.Digest) ([]byte, string, error) {
+	b, ok := m.manifests[dgst.String()]
+	if !ok {
+		return nil, "", errors.New("manifest not found")
+	}
+	return b, ocispec.MediaTypeImageManifest, nil
+}
+
+func TestAdvertiseAnnotation(t *testing.T) {
+	t.Parallel()
+
+	annotated, err := oci.Parse("docker.io/library/ubuntu:latest@sha256:b060fffe8e1561c9c3e6dea6db487b900100fc26830b9ea2ec966c151ab4c020", "")
+	require.NoError(t, err)
+	other, err := oci.Parse("ghcr.io/spegel-org/spegel:v0.0.9@sha256:fa32bd3bcd49a45a62cfc1b0fed6a0b63bf8af95db5bad7ec22865aee0a4b795", "")
+	require.NoError(t, err)
+	ociClient := &manifestClient{
+		MockClient: oci.NewMockClient([]oci.Image{annotated, other}),
+		manifests: map[string][]byte{
+			annotated.Digest.String(): []byte(`{"annotations":{"spegel.dev/distribute":"true"}}`),
+			other.Digest.String():     []byte(`{"annotations":{"spegel.dev/distribute":"false"}}`),
+		},
+	}
+	router := routing.NewMemoryRouter(map[string][]netip.AddrPort{}, netip.MustParseAddrPort("127.0.0.1:5000"))
+	cfg := config{
+		annotationKey:   "spegel.dev/distribute",
+		annotationValue: "true",
+	}
+
+	keys, err := all(context.TODO(), ociClient, router, cfg, nil, true)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"docker.io/library/ubuntu:latest", annotated.Digest.String()}, keys)
+	_, ok := router.Lookup(other.Digest.String())
+	require.False(t, ok)
+
+	ok, err = hasAnnotation(context.TODO(), ociClient, other, "spegel.dev/distribute", "")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = hasAnnotation(context.TODO(), ociClient, other, "spegel.dev/missing", "")
+	require.NoError(t, err)
+	require.False(t, ok)
+}